@@ -0,0 +1,178 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// aclConfig is an item in "acl" section in config file, e.g.
+//
+//	acl:
+//	  - route: /_turbo/
+//	    allow: [10.0.0.0/8, 192.168.0.0/16, 127.0.0.1]
+//	  - route: GET,POST /v1/
+//	    deny: [203.0.113.0/24]
+//
+// "route" matches requests the same way as component mappings do, methods are optional,
+// the first matched item is used. A client IP in "deny" is rejected with 403,
+// and if "allow" is not empty, a client IP not in it is rejected too. See ClientIP for how the client IP is found.
+type aclConfig struct {
+	Route string   `mapstructure:"route"`
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+}
+
+type aclRule struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (r *aclRule) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// allowed returns true if ip is not denied, and is allowed
+func (r *aclRule) allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(r.allow) == 0 && len(r.deny) == 0
+	}
+	if containsIP(r.deny, ip) {
+		return false
+	}
+	return len(r.allow) == 0 || containsIP(r.allow, ip)
+}
+
+// loadACL loads "trusted_proxies" and the "acl" section, acl is nil if there is no such section
+func (c *Config) loadACL() {
+	proxies, err := parseCIDRs(configList(c.configs[trustedProxies], ""))
+	panicIf(err)
+	c.trustedProxies = proxies
+	c.acl = nil
+	if !c.IsSet("acl") {
+		return
+	}
+	items := make([]aclConfig, 0)
+	panicIf(c.UnmarshalKey("acl", &items))
+	for _, item := range items {
+		methods, path := parseRoute(item.Route)
+		if len(path) == 0 {
+			panic("turbo: invalid acl route: " + item.Route)
+		}
+		rule := &aclRule{}
+		rule.allow, err = parseCIDRs(item.Allow)
+		panicIf(err)
+		rule.deny, err = parseCIDRs(item.Deny)
+		panicIf(err)
+		c.acl = setComponent(c.acl, methods, path, rule)
+	}
+}
+
+// parseCIDRs parses CIDRs like "10.0.0.0/8", an IP is a CIDR with all bits, e.g. "127.0.0.1" is "127.0.0.1/32"
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("turbo: invalid IP: " + s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("turbo: invalid CIDR: " + s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// resolveClientIP returns the client IP of a request, if the peer is a trusted proxy,
+// addresses in "X-Forwarded-For" are checked from right to left, the first one not trusted is the client,
+// "X-Real-IP" is used if there is no "X-Forwarded-For".
+func resolveClientIP(req *http.Request, trusted []*net.IPNet) string {
+	client := remoteIP(req)
+	if ip := net.ParseIP(client); ip == nil || !containsIP(trusted, ip) {
+		return client
+	}
+	forwarded := make([]string, 0)
+	for _, value := range req.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	if len(forwarded) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return client
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !containsIP(trusted, ip) {
+			break
+		}
+	}
+	return client
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the IP of the client sending a request, derived from "X-Forwarded-For" or "X-Real-IP"
+// if the peer is in "trusted_proxies", it's also bound to the request field named "client_ip",
+// which wins over values sent by clients, see FindValue.
+func ClientIP(req *http.Request) string {
+	if ip, ok := req.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(req)
+}
+
+// accessHandler puts the client IP into the request context,
+// and rejects requests denied by the "acl" section with 403.
+func accessHandler(s Servable, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		c := s.ServerField().Config
+		ip := resolveClientIP(req, c.trustedProxies)
+		ctx := context.WithValue(req.Context(), clientIPKey{}, ip)
+		req = req.WithContext(bindValue(ctx, "client_ip", ip))
+		if cp := component(c.acl, req); cp != nil && !cp.(*aclRule).allowed(net.ParseIP(ip)) {
+			log.WithField("client_ip", ip).Info("turbo: denied by acl: ", req.Method, " ", req.URL.Path)
+			http.Error(resp, "turbo: forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,80 @@
+package turbo
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveClientIP(t *testing.T) {
+	trusted, err := parseCIDRs([]string{"10.0.0.0/8", "127.0.0.1", "::1"})
+	assert.Nil(t, err)
+	request := func(remote string, headers ...string) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remote
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Add(headers[i], headers[i+1])
+		}
+		return req
+	}
+	assert.Equal(t, "203.0.113.9", resolveClientIP(request("203.0.113.9:80", "X-Forwarded-For", "1.2.3.4"), trusted))
+	assert.Equal(t, "1.2.3.4", resolveClientIP(request("10.1.1.1:80", "X-Forwarded-For", "9.9.9.9, 1.2.3.4, 10.0.0.2"), trusted))
+	assert.Equal(t, "1.2.3.4", resolveClientIP(request("127.0.0.1:80", "X-Forwarded-For", "9.9.9.9",
+		"X-Forwarded-For", "1.2.3.4"), trusted))
+	assert.Equal(t, "10.0.0.3", resolveClientIP(request("10.1.1.1:80", "X-Forwarded-For", "10.0.0.3, 10.0.0.2"), trusted))
+	assert.Equal(t, "10.0.0.2", resolveClientIP(request("10.1.1.1:80", "X-Forwarded-For", "garbage, 10.0.0.2"), trusted))
+	assert.Equal(t, "5.6.7.8", resolveClientIP(request("[::1]:80", "X-Real-IP", "5.6.7.8"), trusted))
+	assert.Equal(t, "::1", resolveClientIP(request("[::1]:80"), trusted))
+
+	_, err = parseCIDRs([]string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+	_, err = parseCIDRs([]string{"localhost"})
+	assert.NotNil(t, err)
+}
+
+func TestACL(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	s.Config.configs[trustedProxies] = "10.0.0.0/8"
+	s.Config.Set("acl", []map[string]interface{}{
+		{"route": "/_turbo/", "allow": []string{"192.168.0.0/16", "127.0.0.1"}},
+		{"route": "GET /v1/", "deny": []string{"203.0.113.0/24"}},
+	})
+	s.Config.loadACL()
+	rule := component(s.Config.acl, httptest.NewRequest("GET", "/_turbo/routes", nil)).(*aclRule)
+	assert.True(t, rule.allowed(net.ParseIP("192.168.1.1")))
+	assert.False(t, rule.allowed(net.ParseIP("10.0.0.1")))
+	assert.False(t, rule.allowed(nil))
+
+	var clientIP string
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		clientIP = ClientIP(req)
+		v, _ := findValue("ClientIp", req)
+		return &testRuleRequest{Name: v}, nil
+	}
+	serve := func(url, remote, forwarded string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", forwarded)
+		resp := httptest.NewRecorder()
+		accessHandler(s, router(s)).ServeHTTP(resp, req)
+		return resp
+	}
+	resp := serve("/v1/rules/12", "10.0.0.1:1234", "203.0.113.7")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = serve("/v1/rules/12", "203.0.113.7:1234", "1.2.3.4")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = serve("/v1/rules/12", "10.0.0.1:1234", "1.2.3.4")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "1.2.3.4", clientIP)
+	assert.Contains(t, resp.Body.String(), `"name":"1.2.3.4"`)
+	resp = serve("/v1/rules/12?client_ip=6.6.6.6", "10.0.0.1:1234", "1.2.3.4")
+	assert.Contains(t, resp.Body.String(), `"name":"1.2.3.4"`)
+	resp = serve("/_turbo/routes", "10.0.0.1:1234", "1.2.3.4")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	resp = serve("/_turbo/routes", "10.0.0.1:1234", "192.168.3.4")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
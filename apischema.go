@@ -0,0 +1,234 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// apiSchema describes the request and response types of rpc methods,
+// it's loaded from .proto descriptors or thrift Args structs, and used to generate API documents and clients.
+type apiSchema struct {
+	Methods  map[string]*apiMethod  `json:"methods"`
+	Messages map[string]*apiMessage `json:"messages"`
+	Enums    map[string][]string    `json:"enums"`
+	// Packages maps message, enum and service names to their proto packages, only set for grpc
+	Packages map[string]string `json:"-"`
+}
+
+// apiMethod holds the request and response message names of a rpc method
+type apiMethod struct {
+	Name     string `json:"name"`
+	Request  string `json:"request"`
+	Response string `json:"response"`
+}
+
+// apiMessage is a struct, a proto message or a thrift struct
+type apiMessage struct {
+	Name   string      `json:"name"`
+	Fields []*apiField `json:"fields"`
+}
+
+// apiField is a field of a message,
+// Type is one of: string, bool, int32, uint32, int64, uint64, float, double, bytes, enum, message, map
+type apiField struct {
+	// Name is the field name in .proto|.thrift
+	Name string `json:"name"`
+	// JSONName is the lowerCamelCase name
+	JSONName string `json:"json_name"`
+	// GoName is the name of the generated go struct field
+	GoName   string    `json:"go_name"`
+	Type     string    `json:"type"`
+	Ref      string    `json:"ref,omitempty"`
+	Repeated bool      `json:"repeated,omitempty"`
+	Value    *apiField `json:"value,omitempty"`
+	// Optional is true if the field is omitted in json when it's empty, only set for thrift
+	Optional bool `json:"optional,omitempty"`
+	// Oneof is true if the field is a member of a oneof, or a proto3 optional field, only set for grpc
+	Oneof bool `json:"oneof,omitempty"`
+}
+
+func (s *apiSchema) message(name string) *apiMessage {
+	if s == nil {
+		return nil
+	}
+	return s.Messages[name]
+}
+
+// loadGrpcSchema loads the descriptor set written by protoc-gen-buildfields,
+// only methods in service 'serviceName' are loaded, all services are loaded if 'serviceName' is empty.
+func loadGrpcSchema(descriptorSetFile, serviceName string) (*apiSchema, error) {
+	data, err := ioutil.ReadFile(descriptorSetFile)
+	if err != nil {
+		return nil, err
+	}
+	set := new(descriptor.FileDescriptorSet)
+	if err = proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return grpcSchema(set.File, serviceName), nil
+}
+
+func grpcSchema(files []*descriptor.FileDescriptorProto, serviceName string) *apiSchema {
+	s := &apiSchema{
+		Methods:  make(map[string]*apiMethod),
+		Messages: make(map[string]*apiMessage),
+		Enums:    make(map[string][]string),
+		Packages: make(map[string]string)}
+	// full name, e.g. ".proto.Outer.Inner" -> schema name, e.g. "Outer_Inner"
+	names := make(map[string]string)
+	mapEntries := make(map[string]*descriptor.DescriptorProto)
+	for _, f := range files {
+		prefix := "."
+		if len(f.GetPackage()) > 0 {
+			prefix += f.GetPackage() + "."
+		}
+		fileNames := make(map[string]string)
+		collectGrpcNames(fileNames, mapEntries, prefix, "", f.MessageType, f.EnumType)
+		for fullName, name := range fileNames {
+			names[fullName] = name
+			s.Packages[name] = f.GetPackage()
+		}
+	}
+	for _, f := range files {
+		prefix := "."
+		if len(f.GetPackage()) > 0 {
+			prefix += f.GetPackage() + "."
+		}
+		s.addGrpcMessages(names, mapEntries, prefix, f.MessageType, f.EnumType)
+		for _, service := range f.Service {
+			if len(serviceName) > 0 && service.GetName() != serviceName {
+				continue
+			}
+			s.Packages[service.GetName()] = f.GetPackage()
+			for _, m := range service.Method {
+				name := camelCase(m.GetName())
+				s.Methods[name] = &apiMethod{
+					Name:     name,
+					Request:  names[m.GetInputType()],
+					Response: names[m.GetOutputType()]}
+			}
+		}
+	}
+	return s
+}
+
+func collectGrpcNames(names map[string]string, mapEntries map[string]*descriptor.DescriptorProto,
+	prefix, parent string, messages []*descriptor.DescriptorProto, enums []*descriptor.EnumDescriptorProto) {
+	for _, e := range enums {
+		names[prefix+e.GetName()] = parent + e.GetName()
+	}
+	for _, m := range messages {
+		names[prefix+m.GetName()] = parent + m.GetName()
+		if m.Options.GetMapEntry() {
+			mapEntries[prefix+m.GetName()] = m
+		}
+		collectGrpcNames(names, mapEntries, prefix+m.GetName()+".", parent+m.GetName()+"_", m.NestedType, m.EnumType)
+	}
+}
+
+func (s *apiSchema) addGrpcMessages(names map[string]string, mapEntries map[string]*descriptor.DescriptorProto,
+	prefix string, messages []*descriptor.DescriptorProto, enums []*descriptor.EnumDescriptorProto) {
+	for _, e := range enums {
+		values := make([]string, 0, len(e.Value))
+		for _, v := range e.Value {
+			values = append(values, v.GetName())
+		}
+		s.Enums[names[prefix+e.GetName()]] = values
+	}
+	for _, m := range messages {
+		s.addGrpcMessages(names, mapEntries, prefix+m.GetName()+".", m.NestedType, m.EnumType)
+		if m.Options.GetMapEntry() {
+			continue
+		}
+		message := &apiMessage{Name: names[prefix+m.GetName()]}
+		for _, f := range m.Field {
+			field := grpcField(names, f)
+			if entry, ok := mapEntries[f.GetTypeName()]; ok && len(entry.Field) == 2 {
+				field.Type = "map"
+				field.Ref = ""
+				field.Repeated = false
+				field.Value = grpcField(names, entry.Field[1])
+			}
+			message.Fields = append(message.Fields, field)
+		}
+		s.Messages[message.Name] = message
+	}
+}
+
+func grpcField(names map[string]string, f *descriptor.FieldDescriptorProto) *apiField {
+	field := &apiField{
+		Name:     f.GetName(),
+		JSONName: f.GetJsonName(),
+		GoName:   camelCase(f.GetName()),
+		Repeated: f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED,
+		Oneof:    f.OneofIndex != nil,
+	}
+	if len(field.JSONName) == 0 {
+		field.JSONName = lowerCamelCase(f.GetName())
+	}
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		field.Type = "string"
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		field.Type = "bool"
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SINT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		field.Type = "int32"
+	case descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		field.Type = "uint32"
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		field.Type = "int64"
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		field.Type = "uint64"
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		field.Type = "float"
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		field.Type = "double"
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		field.Type = "bytes"
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		field.Type = "enum"
+		field.Ref = names[f.GetTypeName()]
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		field.Type = "message"
+		field.Ref = names[f.GetTypeName()]
+	}
+	return field
+}
+
+// camelCase converts a name in .proto into the go name generated by protoc-gen-go, e.g. "your_name" -> "YourName"
+func camelCase(name string) string {
+	var result string
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			result += strings.ToUpper(string(r))
+			upper = false
+			continue
+		}
+		result += string(r)
+	}
+	return result
+}
+
+// lowerCamelCase converts a name into lowerCamelCase, e.g. "your_name" -> "yourName"
+func lowerCamelCase(name string) string {
+	s := camelCase(name)
+	if len(s) == 0 {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
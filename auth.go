@@ -0,0 +1,303 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+)
+
+const (
+	authAPIKey = "apikey"
+	authHMAC   = "hmac"
+	authJWT    = "jwt"
+)
+
+// authConfig is the "auth" section in config file, e.g.
+//
+//	auth:
+//	  keys_file: keys/partners.yaml
+//	  apikey_header: X-Api-Key
+//	  hmac_window: 5m
+//	  principal_field: partner_id
+//	  routes:
+//	    - GET,POST /v1/partners/ apikey
+//	    - POST /v1/webhooks/ hmac
+//	    - GET /v1/reports/ apikey,hmac
+//
+// Each line in "routes" is "[HTTP methods] [url] [providers]", urls match requests the same way as component mappings,
+// a request is authenticated if any of the providers accepts it. The keys file is reloaded with the config file:
+//
+//	apikeys:
+//	  - partner-a 6f1ed002ab5595859014ebf0951522d9
+//	hmac_keys:
+//	  - partner-b 3d5a9c3f6a2e4c0f8f0b7c8a2a1d0e4b
+//
+// Each line is "[principal] [key]", an "apikey" request sends the key in "apikey_header" (default "X-Api-Key"),
+// an "hmac" request is signed with the key of the principal, see verifyHMAC.
+// The principal is put into the request context, see PrincipalOf, and bound to the request field
+// named "principal_field" (default "principal"), it wins over values sent by clients, see FindValue.
+// Bodies of "hmac" requests are read into memory, up to "max_request_body_size" in the "config" section.
+type authConfig struct {
+	KeysFile       string   `mapstructure:"keys_file"`
+	APIKeyHeader   string   `mapstructure:"apikey_header"`
+	HMACWindow     string   `mapstructure:"hmac_window"`
+	PrincipalField string   `mapstructure:"principal_field"`
+	Routes         []string `mapstructure:"routes"`
+}
+
+// authProviders are providers of a route in "auth" section
+type authProviders []string
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (p authProviders) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+type authenticator struct {
+	apiKeyHeader   string
+	window         time.Duration
+	principalField string
+	// maxBodySize is the max size of a body read to verify its signature, see Config.MaxRequestBodySize
+	maxBodySize int64
+	// apiKeys are principals by sha256 of keys
+	apiKeys  map[[sha256.Size]byte]string
+	hmacKeys map[string][]byte
+	nonces   *nonceCache
+	routes   *mux.Router
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Name is the principal in the keys file, or the "sub" claim of a JWT
+	Name string
+	// Provider is "apikey", "hmac" or "jwt"
+	Provider string
+}
+
+type principalKey struct{}
+
+// PrincipalOf returns the authenticated caller of a request, interceptors can find it in req.Context()
+func PrincipalOf(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// loadAuth loads the "auth" section and the keys file, auth is nil if there is no such section
+func (c *Config) loadAuth() {
+	c.auth = nil
+	if !c.IsSet("auth") {
+		return
+	}
+	conf := &authConfig{}
+	panicIf(c.UnmarshalKey("auth", conf))
+	a := &authenticator{apiKeyHeader: conf.APIKeyHeader, principalField: conf.PrincipalField, window: 5 * time.Minute,
+		maxBodySize: c.MaxRequestBodySize(), apiKeys: make(map[[sha256.Size]byte]string), hmacKeys: make(map[string][]byte), nonces: newNonceCache()}
+	if len(a.apiKeyHeader) == 0 {
+		a.apiKeyHeader = "X-Api-Key"
+	}
+	if len(a.principalField) == 0 {
+		a.principalField = "principal"
+	}
+	if len(conf.HMACWindow) > 0 {
+		window, err := time.ParseDuration(conf.HMACWindow)
+		if err != nil || window <= 0 {
+			panic("turbo: invalid hmac_window: " + conf.HMACWindow)
+		}
+		a.window = window
+	}
+	if len(conf.KeysFile) > 0 {
+		panicIf(a.loadKeys(c.absolutePath(conf.KeysFile)))
+	}
+	for _, line := range conf.Routes {
+		values := strings.Fields(line)
+		if len(values) != 3 {
+			panic("turbo: invalid auth route: '" + line + "', should be '[HTTP methods] [url] [providers]'")
+		}
+		providers := strings.Split(values[2], ",")
+		for _, p := range providers {
+			if p != authAPIKey && p != authHMAC {
+				panic("turbo: invalid auth provider: " + p + ", should be 'apikey' or 'hmac'")
+			}
+		}
+		a.routes = setComponent(a.routes, strings.Split(values[0], ","), values[1], authProviders(providers))
+	}
+	c.auth = a
+}
+
+func (a *authenticator) loadKeys(file string) error {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	for _, line := range v.GetStringSlice("apikeys") {
+		values := strings.Fields(line)
+		if len(values) != 2 {
+			return errors.New("turbo: invalid api key in " + file + ", should be '[principal] [key]'")
+		}
+		a.apiKeys[sha256.Sum256([]byte(values[1]))] = values[0]
+	}
+	for _, line := range v.GetStringSlice("hmac_keys") {
+		values := strings.Fields(line)
+		if len(values) != 2 {
+			return errors.New("turbo: invalid hmac key in " + file + ", should be '[principal] [key]'")
+		}
+		a.hmacKeys[values[0]] = []byte(values[1])
+	}
+	return nil
+}
+
+// inheritNonces keeps nonces seen before reloading config, so requests can not be replayed after a reload
+func (c *Config) inheritNonces(old *Config) {
+	if c.auth != nil && old.auth != nil {
+		c.auth.nonces = old.auth.nonces
+	}
+}
+
+// verifyAPIKey returns the principal of the api key in the header
+func (a *authenticator) verifyAPIKey(req *http.Request) (string, error) {
+	key := req.Header.Get(a.apiKeyHeader)
+	principal, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return "", errors.New("invalid api key")
+	}
+	return principal, nil
+}
+
+// verifyHMAC verifies a request signed in "Authorization" header like:
+//
+//	Authorization: HMAC-SHA256 key=partner-b,timestamp=1500000000,nonce=8f2d6c,signature=<hex>
+//
+// the signature is the hex encoded HMAC-SHA256, with the key of the principal, of
+// "[HTTP method]\n[path and query]\n[timestamp]\n[nonce]\n[hex encoded sha256 of body]",
+// the timestamp (in seconds) should be within "hmac_window" from now, and a nonce can be used only once,
+// it returns errBodyTooLarge if the body is larger than maxBodySize.
+func (a *authenticator) verifyHMAC(resp http.ResponseWriter, req *http.Request, now time.Time) (string, error) {
+	params := make(map[string]string)
+	for _, kv := range strings.Split(strings.TrimPrefix(req.Header.Get("Authorization"), "HMAC-SHA256 "), ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			params[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+		}
+	}
+	principal, timestamp, nonce := params["key"], params["timestamp"], params["nonce"]
+	signature, err := hex.DecodeString(params["signature"])
+	if len(principal) == 0 || len(timestamp) == 0 || len(nonce) == 0 || err != nil || len(signature) == 0 {
+		return "", errors.New("malformed signature")
+	}
+	key, ok := a.hmacKeys[principal]
+	if !ok {
+		return "", errors.New("invalid signature")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || math.Abs(now.Sub(time.Unix(ts, 0)).Seconds()) > a.window.Seconds() {
+		return "", errors.New("timestamp is out of window")
+	}
+	var body []byte
+	if req.Body != nil {
+		if body, err = readBody(resp, req.Body, a.maxBodySize); err != nil {
+			return "", err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + nonce + "\n" +
+		hex.EncodeToString(bodyHash[:])))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return "", errors.New("invalid signature")
+	}
+	if !a.nonces.add(principal+"\n"+nonce, now, now.Add(2*a.window)) {
+		return "", errors.New("nonce has been used")
+	}
+	return principal, nil
+}
+
+// nonceCache holds nonces until they expire
+type nonceCache struct {
+	mutex   sync.Mutex
+	nonces  map[string]time.Time
+	sweepAt int
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{nonces: make(map[string]time.Time), sweepAt: 1024}
+}
+
+// add returns false if the nonce is seen and not expired
+func (n *nonceCache) add(nonce string, now, expiry time.Time) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if e, ok := n.nonces[nonce]; ok && now.Before(e) {
+		return false
+	}
+	if len(n.nonces) >= n.sweepAt {
+		for k, e := range n.nonces {
+			if !now.Before(e) {
+				delete(n.nonces, k)
+			}
+		}
+		if 2*len(n.nonces) > n.sweepAt {
+			n.sweepAt = 2 * len(n.nonces)
+		}
+	}
+	n.nonces[nonce] = expiry
+	return true
+}
+
+// authenticated authenticates a request by JWT, see jwtConfig, and by providers in "auth" section,
+// it responds with 401 and returns false if it fails.
+func authenticated(s Servable, resp http.ResponseWriter, req *http.Request) bool {
+	return jwtAuthenticated(s, resp, req) && keyAuthenticated(s, resp, req)
+}
+
+// keyAuthenticated authenticates a request by providers of its route in "auth" section, if any
+func keyAuthenticated(s Servable, resp http.ResponseWriter, req *http.Request) bool {
+	a := s.ServerField().Config.auth
+	if a == nil {
+		return true
+	}
+	cp := component(a.routes, req)
+	if cp == nil {
+		return true
+	}
+	err := errors.New("missing credentials")
+	for _, provider := range cp.(authProviders) {
+		var principal string
+		switch {
+		case provider == authAPIKey && len(req.Header.Get(a.apiKeyHeader)) > 0:
+			principal, err = a.verifyAPIKey(req)
+		case provider == authHMAC && strings.HasPrefix(req.Header.Get("Authorization"), "HMAC-SHA256 "):
+			principal, err = a.verifyHMAC(resp, req, time.Now())
+		default:
+			continue
+		}
+		if err == nil {
+			ctx := context.WithValue(req.Context(), principalKey{}, Principal{Name: principal, Provider: provider})
+			*req = *req.WithContext(bindValue(ctx, a.principalField, principal))
+			return true
+		}
+		if err == errBodyTooLarge {
+			http.Error(resp, err.Error(), http.StatusRequestEntityTooLarge)
+			return false
+		}
+	}
+	http.Error(resp, "turbo: "+err.Error(), http.StatusUnauthorized)
+	return false
+}
@@ -0,0 +1,147 @@
+package turbo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signTestHMAC(req *http.Request, principal, key, nonce string, timestamp time.Time, body string) {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	bodyHash := sha256.Sum256([]byte(body))
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + ts + "\n" + nonce + "\n" + hex.EncodeToString(bodyHash[:])))
+	req.Header.Set("Authorization", "HMAC-SHA256 key="+principal+", timestamp="+ts+", nonce="+nonce+
+		", signature="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// testAuthConfig writes a keys file into dir, and returns an "auth" section using it
+func testAuthConfig(t *testing.T, dir string) map[string]interface{} {
+	assert.Nil(t, ioutil.WriteFile(dir+"/keys.yaml", []byte("apikeys:\n  - Partner-A key-a\nhmac_keys:\n  - Partner-B key-b\n"), 0600))
+	return map[string]interface{}{
+		"keys_file":       dir + "/keys.yaml",
+		"hmac_window":     "1m",
+		"principal_field": "name",
+		"routes":          []string{"GET /v1/rules/ apikey,hmac", "POST /hello hmac"},
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_auth")
+	defer os.RemoveAll(dir)
+	a := newTestServerWith(map[string]interface{}{"auth": testAuthConfig(t, dir)}).Config.auth
+	now := time.Now()
+	request := func(nonce string, timestamp time.Time, body, signedBody string) *http.Request {
+		req := httptest.NewRequest("POST", "/hello?a=1", strings.NewReader(body))
+		signTestHMAC(req, "Partner-B", "key-b", nonce, timestamp, signedBody)
+		return req
+	}
+	req := request("n1", now, `{"a":1}`, `{"a":1}`)
+	principal, err := a.verifyHMAC(httptest.NewRecorder(), req, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "Partner-B", principal)
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, `{"a":1}`, string(body))
+
+	_, err = a.verifyHMAC(httptest.NewRecorder(), request("n1", now, `{"a":1}`, `{"a":1}`), now)
+	assert.Equal(t, "nonce has been used", err.Error())
+	_, err = a.verifyHMAC(httptest.NewRecorder(), request("n2", now, `{"a":2}`, `{"a":1}`), now)
+	assert.Equal(t, "invalid signature", err.Error())
+	_, err = a.verifyHMAC(httptest.NewRecorder(), request("n3", now.Add(-2*time.Minute), ``, ``), now)
+	assert.Equal(t, "timestamp is out of window", err.Error())
+	req = request("n4", now, ``, ``)
+	req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "Partner-B", "Partner-C", 1))
+	_, err = a.verifyHMAC(httptest.NewRecorder(), req, now)
+	assert.Equal(t, "invalid signature", err.Error())
+	req.Header.Set("Authorization", "HMAC-SHA256 key=Partner-B")
+	_, err = a.verifyHMAC(httptest.NewRecorder(), req, now)
+	assert.Equal(t, "malformed signature", err.Error())
+
+	a.maxBodySize = 4
+	_, err = a.verifyHMAC(httptest.NewRecorder(), request("n6", now, `{"a":1}`, `{"a":1}`), now)
+	assert.Equal(t, errBodyTooLarge, err)
+	_, err = a.verifyHMAC(httptest.NewRecorder(), request("n7", now, `{}`, `{}`), now)
+	assert.Nil(t, err)
+
+	// nonces expire
+	_, err = a.verifyHMAC(httptest.NewRecorder(), request("n1", now.Add(3*time.Minute), ``, ``), now.Add(3*time.Minute))
+	assert.Nil(t, err)
+	a.nonces.sweepAt = 1
+	a.nonces.add("n5", now.Add(10*time.Minute), now.Add(11*time.Minute))
+	assert.Equal(t, 1, len(a.nonces.nonces))
+}
+
+func TestKeyAuthentication(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_auth")
+	defer os.RemoveAll(dir)
+	s := newTestServerWith(map[string]interface{}{"auth": testAuthConfig(t, dir)})
+	var principal Principal
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		principal, _ = PrincipalOf(req.Context())
+		v := &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequest(s, v, req)
+		return v, err
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		router(s).ServeHTTP(resp, req)
+		return resp
+	}
+	resp := serve(httptest.NewRequest("GET", "/v1/rules/12", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "turbo: missing credentials\n", resp.Body.String())
+
+	req := httptest.NewRequest("GET", "/v1/rules/12", nil)
+	req.Header.Set("X-Api-Key", "key-b")
+	resp = serve(req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "turbo: invalid api key\n", resp.Body.String())
+
+	req.Header.Set("X-Api-Key", "key-a")
+	resp = serve(req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"name":"Partner-A"`)
+	assert.Equal(t, Principal{Name: "Partner-A", Provider: "apikey"}, principal)
+
+	// the principal wins over values sent by clients
+	req = httptest.NewRequest("GET", "/v1/rules/12?name=Partner-B", nil)
+	req.Header.Set("X-Api-Key", "key-a")
+	assert.Contains(t, serve(req).Body.String(), `"name":"Partner-A"`)
+	req = httptest.NewRequest("POST", "/hello", strings.NewReader(`{"name":"Partner-A"}`))
+	req.Header.Set("Content-Type", "application/json")
+	signTestHMAC(req, "Partner-B", "key-b", "n0", time.Now(), `{"name":"Partner-A"}`)
+	resp = serve(req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"name":"Partner-B"`)
+
+	req = httptest.NewRequest("GET", "/v1/rules/12", nil)
+	signTestHMAC(req, "Partner-B", "key-b", "n1", time.Now(), "")
+	resp = serve(req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, Principal{Name: "Partner-B", Provider: "hmac"}, principal)
+
+	s.Config.auth.maxBodySize = 4
+	req = httptest.NewRequest("POST", "/hello", strings.NewReader(`{"name":"Partner-A"}`))
+	signTestHMAC(req, "Partner-B", "key-b", "n2", time.Now(), `{"name":"Partner-A"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(req).Code)
+
+	// keys are reloaded with config
+	ioutil.WriteFile(dir+"/keys.yaml", []byte("apikeys:\n  - Partner-A key-a2\n"), 0600)
+	s.Config.loadAuth()
+	req = httptest.NewRequest("GET", "/v1/rules/12", nil)
+	req.Header.Set("X-Api-Key", "key-a")
+	assert.Equal(t, http.StatusUnauthorized, serve(req).Code)
+	req.Header.Set("X-Api-Key", "key-a2")
+	assert.Equal(t, http.StatusOK, serve(req).Code)
+}
@@ -0,0 +1,512 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Binder sets values in req to fields of v, v is a pointer to a struct.
+// Binders are generated for each request message, they do the same as BuildStruct without reflection.
+type Binder func(s Servable, v interface{}, req *http.Request)
+
+// BuildRequestWithBinder is the same as BuildRequest, except that values in query params,
+// form params and path params are set by 'bind' instead of BuildStruct, json bodies are still unmarshalled by jsonpb.
+func BuildRequestWithBinder(s Servable, v proto.Message, req *http.Request, bind Binder) error {
+	var err error
+	if rule, ok := httpRuleOf(s, req); ok {
+		if rule.body != "*" {
+			bind(s, v, req)
+		}
+		if err = buildRequestBody(v, req, rule); err == nil {
+			setFieldPaths(reflect.ValueOf(v).Elem(), req)
+		}
+	} else if contentTypes, ok := req.Header["Content-Type"]; ok && contentTypes[0] == "application/json" {
+		err = unmarshalRequest(v, req)
+	} else {
+		bind(s, v, req)
+	}
+	if err == nil {
+		setFieldMask(s, v, req)
+	}
+	return err
+}
+
+// unmarshalRequest unmarshals a json request body into v, then sets path params to v
+func unmarshalRequest(v proto.Message, req *http.Request) error {
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(req.Body)
+	bodyStr := buf.String()
+	unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if err := unmarshaler.Unmarshal(strings.NewReader(bodyStr), v); err != nil {
+		return errors.New(fmt.Sprintf("turbo: failed to BuildRequest for json api, "+
+			"request body: %s, error: %s", bodyStr, err))
+	}
+	setPathParams(reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
+	return nil
+}
+
+// BuildThriftRequestWithBinder sets values in req to 'args', a pointer to a "[Service][Method]Args" struct,
+// a json request body is unmarshalled into the first argument, other values are set by 'bind'.
+func BuildThriftRequestWithBinder(s Servable, args interface{}, req *http.Request, bind Binder) error {
+	contentTypes, ok := req.Header["Content-Type"]
+	if !ok || contentTypes[0] != "application/json" {
+		bind(s, args, req)
+		return nil
+	}
+	field := reflect.ValueOf(args).Elem().Field(0)
+	if field.Kind() != reflect.Ptr || field.Type().Elem().Kind() != reflect.Struct {
+		return errors.New("turbo: failed to BuildThriftRequest for json api, the first argument is not a struct")
+	}
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(req.Body)
+	v := reflect.New(field.Type().Elem())
+	if err := json.Unmarshal(buf.Bytes(), v.Interface()); err != nil {
+		return errors.New(fmt.Sprintf("turbo: failed to BuildThriftRequest for json api, "+
+			"request body: %s, error: %s", buf.String(), err))
+	}
+	setPathParams(v.Type().Elem(), v.Elem(), req)
+	field.Set(v)
+	return nil
+}
+
+// BuildThriftArgs sets values in req to 'args', a pointer to a "[Service][Method]Args" struct, with reflection,
+// the same way BuildThriftRequest does, it's used by generated switchers for methods without generated Binders.
+func BuildThriftArgs(s Servable, args interface{}, req *http.Request, buildStructArg func(s Servable, typeName string, req *http.Request) (v reflect.Value, err error)) error {
+	v := reflect.ValueOf(args).Elem()
+	params, err := BuildThriftRequest(s, v.Interface(), req, buildStructArg)
+	if err != nil {
+		return err
+	}
+	for i, p := range params {
+		field := v.Field(i)
+		if !p.IsValid() {
+			continue
+		}
+		if !p.Type().AssignableTo(field.Type()) {
+			if !p.Type().ConvertibleTo(field.Type()) {
+				return errors.New(fmt.Sprintf("turbo: failed to BuildThriftArgs, can not set %s to %s",
+					p.Type(), v.Type().Field(i).Name))
+			}
+			p = p.Convert(field.Type())
+		}
+		field.Set(p)
+	}
+	return nil
+}
+
+// boundValuesKey is the context key of values bound to request fields by turbo itself,
+// such as claims of a verified JWT, they are trusted, unlike values sent by clients, see bindValue.
+type boundValuesKey struct{}
+
+// bindValue returns a copy of ctx in which 'value' is bound to request fields named 'name',
+// bound values win over query params, form params, path params and json request bodies,
+// so clients can't override them.
+func bindValue(ctx context.Context, name, value string) context.Context {
+	old, _ := ctx.Value(boundValuesKey{}).(map[string]string)
+	values := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		values[k] = v
+	}
+	values[name] = value
+	return context.WithValue(ctx, boundValuesKey{}, values)
+}
+
+// boundValues returns values bound by bindValue, keyed by their names, and their lower case names
+func boundValues(req *http.Request) map[string]string {
+	bound, _ := req.Context().Value(boundValuesKey{}).(map[string]string)
+	values := make(map[string]string, 2*len(bound))
+	for k, v := range bound {
+		values[strings.ToLower(k)] = v
+	}
+	for k, v := range bound {
+		values[k] = v
+	}
+	return values
+}
+
+// FindValue finds the value of a field in values bound by turbo, form params, path params and context values,
+// names are the go name, the lower case name and the snake case name of the field,
+// bound values, such as claims of a verified JWT, are searched first, so clients can't override them.
+func FindValue(req *http.Request, fieldName, lowerCaseName, snakeCaseName string) (string, bool) {
+	if bound, ok := req.Context().Value(boundValuesKey{}).(map[string]string); ok {
+		for _, name := range []string{fieldName, lowerCaseName, snakeCaseName} {
+			if v, ok := bound[name]; ok {
+				return v, true
+			}
+		}
+	}
+	if v, ok := req.Form[lowerCaseName]; ok && len(v) > 0 {
+		return v[0], true
+	}
+	if v, ok := req.Form[snakeCaseName]; ok && len(v) > 0 {
+		return v[0], true
+	}
+	for _, name := range []string{fieldName, lowerCaseName, snakeCaseName} {
+		if ctxValue := req.Context().Value(name); ctxValue != nil {
+			return ctxValue.(string), true
+		}
+	}
+	return "", false
+}
+
+// Convert sets the value returned by the Convertor of type 'typeName' to v, a pointer to a struct,
+// or a pointer to a struct pointer, it returns false if there's no such Convertor.
+func Convert(req *http.Request, v interface{}, typeName string) bool {
+	convertor := components(req).Convertor(typeName)
+	if convertor == nil {
+		return false
+	}
+	target := reflect.ValueOf(v).Elem()
+	value := convertor(req)
+	if value.Type() != target.Type() && value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	target.Set(value)
+	return true
+}
+
+// SetFieldValue sets v to the field which 'ptr' points to with reflection,
+// it's used for fields generated Binders don't support, such as bytes and maps.
+func SetFieldValue(ptr interface{}, v string) {
+	fieldValue := reflect.ValueOf(ptr).Elem()
+	logErrorIf(setValue(fieldValue.Type(), fieldValue, v))
+}
+
+// BuildStructField sets values in req to a struct pointer field with reflection, 'ptr' points to the field,
+// it's the same as what BuildStruct does for nested structs, and is used for structs without generated Binders.
+func BuildStructField(s Servable, ptr interface{}, req *http.Request) {
+	fieldValue := reflect.ValueOf(ptr).Elem()
+	if Convert(req, ptr, fieldValue.Type().Elem().Name()) || fieldValue.IsNil() {
+		return
+	}
+	BuildStruct(s, fieldValue.Type().Elem(), fieldValue.Elem(), req)
+}
+
+// IntValue parses v as an int of 'bitSize' bits, errors are logged, and 0 is returned
+func IntValue(v string, bitSize int) int64 {
+	i, err := strconv.ParseInt(v, 10, bitSize)
+	if err != nil {
+		logErrorIf(err)
+		return 0
+	}
+	return i
+}
+
+// UintValue parses v as an uint of 'bitSize' bits, errors are logged, and 0 is returned
+func UintValue(v string, bitSize int) uint64 {
+	u, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		logErrorIf(err)
+		return 0
+	}
+	return u
+}
+
+// FloatValue parses v as a float of 'bitSize' bits, errors are logged, and 0 is returned
+func FloatValue(v string, bitSize int) float64 {
+	f, err := strconv.ParseFloat(v, bitSize)
+	if err != nil {
+		logErrorIf(err)
+		return 0
+	}
+	return f
+}
+
+// BoolValue parses v as a bool, errors are logged, and false is returned
+func BoolValue(v string) bool {
+	b, err := strconv.ParseBool(v)
+	logErrorIf(err)
+	return b
+}
+
+// StringList splits a comma separated list, an empty string is an empty list
+func StringList(v string) []string {
+	if len(v) == 0 {
+		return []string{}
+	}
+	return strings.Split(v, ",")
+}
+
+// IntList parses a comma separated list of ints, it returns false and logs the error if any element is invalid
+func IntList(v string, bitSize int) ([]int64, bool) {
+	items := StringList(v)
+	list := make([]int64, len(items))
+	for k, item := range items {
+		i, err := strconv.ParseInt(item, 10, bitSize)
+		if err != nil {
+			logErrorIf(err)
+			return nil, false
+		}
+		list[k] = i
+	}
+	return list, true
+}
+
+// UintList parses a comma separated list of uints, it returns false and logs the error if any element is invalid
+func UintList(v string, bitSize int) ([]uint64, bool) {
+	items := StringList(v)
+	list := make([]uint64, len(items))
+	for k, item := range items {
+		u, err := strconv.ParseUint(item, 10, bitSize)
+		if err != nil {
+			logErrorIf(err)
+			return nil, false
+		}
+		list[k] = u
+	}
+	return list, true
+}
+
+// FloatList parses a comma separated list of floats, it returns false and logs the error if any element is invalid
+func FloatList(v string, bitSize int) ([]float64, bool) {
+	items := StringList(v)
+	list := make([]float64, len(items))
+	for k, item := range items {
+		f, err := strconv.ParseFloat(item, bitSize)
+		if err != nil {
+			logErrorIf(err)
+			return nil, false
+		}
+		list[k] = f
+	}
+	return list, true
+}
+
+// BoolList parses a comma separated list of bools, it returns false and logs the error if any element is invalid
+func BoolList(v string) ([]bool, bool) {
+	items := StringList(v)
+	list := make([]bool, len(items))
+	for k, item := range items {
+		b, err := strconv.ParseBool(item)
+		if err != nil {
+			logErrorIf(err)
+			return nil, false
+		}
+		list[k] = b
+	}
+	return list, true
+}
+
+// binderMessage is a struct to generate a Binder for
+type binderMessage struct {
+	Name string
+	// GoType is the go type of the struct, e.g. "g.SayHelloRequest"
+	GoType string
+	// Args is true if it's a "[Service][Method]Args" struct of thrift,
+	// struct arguments are always created, while nested structs are only bound if they are created in "fieldmapping"
+	Args   bool
+	Fields []*binderField
+}
+
+// binderField is a field of a binderMessage,
+// Kind is one of: string, bool, int, uint, float, message, struct, fallback,
+// "struct" is a struct without a generated Binder, it's bound with BuildStructField,
+// "fallback" is a field set with SetFieldValue, such as bytes, maps and repeated messages.
+type binderField struct {
+	GoName string
+	Kind   string
+	// GoType is the go type of the field, or of its elements if it's repeated, e.g. "int32", "g.Color", "*g.CommonValues"
+	GoType string
+	// Bits is the bit size of int, uint and float values
+	Bits     int
+	Repeated bool
+	// Ref is the name of a message field's binderMessage
+	Ref string
+}
+
+// binderKinds are the go types of values returned by the parsing funcs of each Kind
+var binderKinds = map[string]string{"string": "string", "bool": "bool", "int": "int64", "uint": "uint64", "float": "float64"}
+
+// binderBuilder generates Binders of messages and the messages they refer to
+type binderBuilder struct {
+	messages map[string]*binderMessage
+	// alloc returns fields to initialize a struct argument with, see Generator.structFields
+	alloc func(name string) string
+	done  map[string]bool
+	queue []string
+	code  bytes.Buffer
+}
+
+func newBinderBuilder(messages map[string]*binderMessage, alloc func(name string) string) *binderBuilder {
+	return &binderBuilder{messages: messages, alloc: alloc, done: make(map[string]bool)}
+}
+
+// binder returns the name of the Binder of message 'name', or "" if it's unknown,
+// the Binder is generated by build().
+func (b *binderBuilder) binder(name string) string {
+	if _, ok := b.messages[name]; !ok {
+		return ""
+	}
+	if !b.done[name] {
+		b.done[name] = true
+		b.queue = append(b.queue, name)
+	}
+	return "bind" + name
+}
+
+// build returns codes of all Binders returned by binder(), and Binders of messages they refer to
+func (b *binderBuilder) build() string {
+	for len(b.queue) > 0 {
+		name := b.queue[0]
+		b.queue = b.queue[1:]
+		b.writeMessage(b.messages[name])
+	}
+	return b.code.String()
+}
+
+func (b *binderBuilder) writeMessage(m *binderMessage) {
+	w := &b.code
+	fmt.Fprintf(w, "\n// bind%s sets values in req to a %s\n", m.Name, m.Name)
+	fmt.Fprintf(w, "func bind%s(s turbo.Servable, v interface{}, req *http.Request) {\n", m.Name)
+	fmt.Fprintf(w, "request := v.(*%s)\n", m.GoType)
+	if !m.Args {
+		fmt.Fprintf(w, "if turbo.Convert(req, request, %q) {\nreturn\n}\n", m.Name)
+	}
+	for _, f := range m.Fields {
+		switch f.Kind {
+		case "message":
+			if m.Args {
+				fmt.Fprintf(w, "if !turbo.Convert(req, &request.%s, %q) {\n", f.GoName, f.Ref)
+				fmt.Fprintf(w, "request.%s = &%s{%s}\n", f.GoName, strings.TrimPrefix(f.GoType, "*"), b.alloc(f.Ref))
+			} else {
+				fmt.Fprintf(w, "if !turbo.Convert(req, &request.%s, %q) && request.%s != nil {\n", f.GoName, f.Ref, f.GoName)
+			}
+			fmt.Fprintf(w, "%s(s, request.%s, req)\n}\n", b.binder(f.Ref), f.GoName)
+		case "struct":
+			fmt.Fprintf(w, "turbo.BuildStructField(s, &request.%s, req)\n", f.GoName)
+		default:
+			fmt.Fprintf(w, "if value, ok := turbo.FindValue(req, %q, %q, %q); ok {\n",
+				f.GoName, strings.ToLower(f.GoName), ToSnakeCase(f.GoName))
+			writeFieldValue(w, f)
+			w.WriteString("}\n")
+		}
+	}
+	w.WriteString("}\n")
+}
+
+// writeFieldValue writes codes setting 'value' to a field
+func writeFieldValue(w *bytes.Buffer, f *binderField) {
+	if f.Kind == "fallback" {
+		fmt.Fprintf(w, "turbo.SetFieldValue(&request.%s, value)\n", f.GoName)
+		return
+	}
+	var parse string
+	switch f.Kind {
+	case "string":
+		parse = "value"
+	case "bool":
+		parse = "turbo.BoolValue(value)"
+	case "int":
+		parse = fmt.Sprintf("turbo.IntValue(value, %d)", f.Bits)
+	case "uint":
+		parse = fmt.Sprintf("turbo.UintValue(value, %d)", f.Bits)
+	case "float":
+		parse = fmt.Sprintf("turbo.FloatValue(value, %d)", f.Bits)
+	}
+	converted := f.GoType != binderKinds[f.Kind]
+	if !f.Repeated {
+		if converted {
+			parse = f.GoType + "(" + parse + ")"
+		}
+		fmt.Fprintf(w, "request.%s = %s\n", f.GoName, parse)
+		return
+	}
+	switch f.Kind {
+	case "string":
+		parse = "turbo.StringList(value)"
+	case "bool":
+		parse = "turbo.BoolList(value)"
+	case "int":
+		parse = fmt.Sprintf("turbo.IntList(value, %d)", f.Bits)
+	case "uint":
+		parse = fmt.Sprintf("turbo.UintList(value, %d)", f.Bits)
+	case "float":
+		parse = fmt.Sprintf("turbo.FloatList(value, %d)", f.Bits)
+	}
+	if f.Kind == "string" {
+		if !converted {
+			fmt.Fprintf(w, "request.%s = %s\n", f.GoName, parse)
+			return
+		}
+		fmt.Fprintf(w, "list := %s\n", parse)
+	} else {
+		fmt.Fprintf(w, "if list, ok := %s; ok {\n", parse)
+		if !converted {
+			fmt.Fprintf(w, "request.%s = list\n}\n", f.GoName)
+			return
+		}
+	}
+	fmt.Fprintf(w, "request.%s = make([]%s, len(list))\n", f.GoName, f.GoType)
+	fmt.Fprintf(w, "for i, item := range list {\nrequest.%s[i] = %s(item)\n}\n", f.GoName, f.GoType)
+	if f.Kind != "string" {
+		w.WriteString("}\n")
+	}
+}
+
+// grpcBinderMessages returns messages in proto package 'protoPackage' of a grpc schema,
+// 'pkg' is the alias of the generated go package, messages in other packages are bound with reflection.
+func grpcBinderMessages(schema *apiSchema, protoPackage, pkg string) map[string]*binderMessage {
+	messages := make(map[string]*binderMessage)
+	for name, m := range schema.Messages {
+		if schema.Packages[name] != protoPackage {
+			continue
+		}
+		message := &binderMessage{Name: name, GoType: pkg + "." + name}
+		for _, f := range m.Fields {
+			if f.Oneof {
+				// oneof members are not struct fields, proto3 optional fields are pointers
+				continue
+			}
+			field := &binderField{GoName: f.GoName, Kind: "fallback", GoType: f.Type, Repeated: f.Repeated}
+			switch f.Type {
+			case "string", "bool":
+				field.Kind = f.Type
+			case "int32", "int64":
+				field.Kind, field.Bits = "int", grpcBits(f.Type)
+			case "uint32", "uint64":
+				field.Kind, field.Bits = "uint", grpcBits(f.Type)
+			case "float":
+				field.Kind, field.GoType, field.Bits = "float", "float32", 32
+			case "double":
+				field.Kind, field.GoType, field.Bits = "float", "float64", 64
+			case "enum":
+				if !f.Repeated && schema.Packages[f.Ref] == protoPackage {
+					field.Kind, field.GoType, field.Bits = "int", pkg+"."+f.Ref, 32
+				}
+			case "message":
+				if f.Repeated {
+					break
+				}
+				field.Kind, field.GoType, field.Ref = "struct", "*"+pkg+"."+f.Ref, f.Ref
+				if schema.Packages[f.Ref] == protoPackage {
+					field.Kind = "message"
+				}
+			}
+			message.Fields = append(message.Fields, field)
+		}
+		messages[name] = message
+	}
+	return messages
+}
+
+func grpcBits(t string) int {
+	if strings.HasSuffix(t, "32") {
+		return 32
+	}
+	return 64
+}
@@ -0,0 +1,147 @@
+package turbo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bindTestRuleRequest is what a generated Binder of testRuleRequest looks like
+func bindTestRuleRequest(s Servable, v interface{}, req *http.Request) {
+	request := v.(*testRuleRequest)
+	if value, ok := FindValue(req, "Id", "id", "id"); ok {
+		request.Id = IntValue(value, 64)
+	}
+	if value, ok := FindValue(req, "Name", "name", "name"); ok {
+		request.Name = value
+	}
+	BuildStructField(s, &request.Child, req)
+}
+
+func TestBuildRequestWithBinder(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	var request *testRuleRequest
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		request = &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequestWithBinder(s, request, req, bindTestRuleRequest)
+		return request, err
+	}
+
+	serveTestRequest(s, "GET", "/v1/rules/12?name=query&title=child", `{"title":"body"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "query", request.Name)
+	assert.Equal(t, "child", request.Child.Title)
+
+	serveTestRequest(s, "POST", "/v1/rules/12?name=query", `{"title":"body"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "body", request.Child.Title)
+
+	serveTestRequest(s, "PUT", "/v1/rules/12?name=query", `{"name":"body","id":"1"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "body", request.Name)
+}
+
+// testThriftArgs is what a "[Service][Method]Args" struct generated by thrift looks like
+type testThriftArgs struct {
+	Child *testRuleChild
+	Name  string
+	Count int32
+	Color testThriftColor
+}
+
+func TestBuildThriftArgs(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	buildStructArg := func(s Servable, typeName string, req *http.Request) (reflect.Value, error) {
+		child := &testRuleChild{}
+		BuildStruct(s, reflect.TypeOf(child).Elem(), reflect.ValueOf(child).Elem(), req)
+		return reflect.ValueOf(child), nil
+	}
+	var args *testThriftArgs
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		args = new(testThriftArgs)
+		err := BuildThriftArgs(s, args, req, buildStructArg)
+		return &testRuleRequest{}, err
+	}
+
+	serveTestRequest(s, "GET", "/hello?title=t&name=turbo&count=3&color=2", "")
+	assert.Equal(t, &testThriftArgs{Child: &testRuleChild{Title: "t"}, Name: "turbo", Count: 3, Color: 2}, args)
+	serveTestRequest(s, "POST", "/hello", `{"title":"body"}`)
+	assert.Equal(t, &testThriftArgs{Child: &testRuleChild{Title: "body"}}, args)
+}
+
+func TestBinderValues(t *testing.T) {
+	req := httptest.NewRequest("GET", "/?your_name=turbo", nil)
+	req.ParseForm()
+	req = req.WithContext(context.WithValue(req.Context(), "SomeId", "3"))
+	v, ok := FindValue(req, "YourName", "yourname", "your_name")
+	assert.True(t, ok)
+	assert.Equal(t, "turbo", v)
+	v, ok = FindValue(req, "SomeId", "someid", "some_id")
+	assert.True(t, ok)
+	assert.Equal(t, "3", v)
+	_, ok = FindValue(req, "Other", "other", "other")
+	assert.False(t, ok)
+
+	assert.Equal(t, int64(-3), IntValue("-3", 16))
+	assert.Equal(t, int64(0), IntValue("70000", 16))
+	assert.Equal(t, uint64(0), UintValue("-1", 64))
+	assert.Equal(t, 1.5, FloatValue("1.5", 64))
+	assert.True(t, BoolValue("true"))
+	assert.Equal(t, []string{}, StringList(""))
+	assert.Equal(t, []string{"a", "b"}, StringList("a,b"))
+	ints, ok := IntList("1,2", 32)
+	assert.True(t, ok)
+	assert.Equal(t, []int64{1, 2}, ints)
+	_, ok = IntList("1,x", 32)
+	assert.False(t, ok)
+	bools, ok := BoolList("true,false")
+	assert.True(t, ok)
+	assert.Equal(t, []bool{true, false}, bools)
+
+	var data []byte
+	SetFieldValue(&data, "1,2")
+	assert.Equal(t, []byte{1, 2}, data)
+}
+
+func TestGrpcBinders(t *testing.T) {
+	schema := grpcSchema(testDescriptors(), "YourService")
+	b := newBinderBuilder(grpcBinderMessages(schema, schema.Packages["YourService"], "g"), nil)
+	assert.Equal(t, "bindSayHelloRequest", b.binder("SayHelloRequest"))
+	assert.Equal(t, "", b.binder("NoSuchRequest"))
+	code := b.build()
+	assert.Contains(t, code, "if !turbo.Convert(req, &request.Values, \"CommonValues\") && request.Values != nil {\n"+
+		"bindCommonValues(s, request.Values, req)\n}\n")
+	assert.Contains(t, code, "if value, ok := turbo.FindValue(req, \"Int64List\", \"int64list\", \"int64_list\"); ok {\n"+
+		"if list, ok := turbo.IntList(value, 64); ok {\nrequest.Int64List = list\n}\n}\n")
+	assert.Contains(t, code, "request.SomeId = turbo.IntValue(value, 64)\n")
+	assert.Equal(t, 2, strings.Count(code, "\nfunc "))
+}
+
+func TestThriftBinders(t *testing.T) {
+	f, _, err := parseThrift("user.thrift", []byte(testThriftIDL))
+	assert.Nil(t, err)
+	messages, methods, err := f.binderMessages("UserService")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"GetUser": "UserServiceGetUserArgs", "Notify": "UserServiceNotifyArgs", "Ping": "BasePingArgs"}, methods)
+	b := newBinderBuilder(messages, func(name string) string { return "" })
+	b.binder(methods["GetUser"])
+	code := b.build()
+	assert.Contains(t, code, "request.ID = api.UserId(turbo.IntValue(value, 64))\n")
+	assert.Contains(t, code, "if !turbo.Convert(req, &request.Template, \"User\") {\n"+
+		"request.Template = &api.User{}\nbindUser(s, request.Template, req)\n}\n")
+	// optional struct fields are bound only if they are created
+	assert.Contains(t, code, "if !turbo.Convert(req, &request.Address, \"Address\") && request.Address != nil {\n")
+	assert.Contains(t, code, "turbo.SetFieldValue(&request.Contacts, value)\n")
+	assert.Contains(t, code, "turbo.SetFieldValue(&request.History, value)\n")
+	assert.Contains(t, code, "request.Color = api.Color(turbo.IntValue(value, 64))\n")
+	assert.Contains(t, code, "turbo.SetFieldValue(&request.Avatar, value)\n")
+	assert.Contains(t, code, "request.Tags[i] = int16(item)\n")
+	assert.Contains(t, code, "turbo.SetFieldValue(&request.Street, value)\n")
+}
@@ -0,0 +1,311 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// breakerConfig is an item in "circuitbreaker" section in config file, e.g.
+//
+//	circuitbreaker:
+//	  - methods: [SayHello]
+//	    failure_rate: 0.5
+//	    slow_call: 2s
+//	    min_requests: 20
+//	    window: 10s
+//	    open_duration: 30s
+//	    half_open_probes: 3
+//	  - methods: ["*"]
+//	    scope: backend
+//
+// "methods" are rpc methods in "urlmapping", "*" matches all methods, the first matched item is used.
+// "scope" is "method"(default) for a circuit per method, or "backend" for one circuit shared by the methods.
+// A circuit opens if at least "min_requests"(default 10) calls are made in "window"(default 10s),
+// and the rate of failed calls is at least "failure_rate"(default 0.5). Calls slower than "slow_call", if it's set,
+// are failed calls, so are errors of the rpc itself, see outcomeOf, errors building requests are not counted,
+// so clients can't open a circuit by sending invalid requests.
+// Calls to an open circuit fail with CircuitOpenError, which gets 503 from the default error handler,
+// after "open_duration"(default 30s), "half_open_probes"(default 1) calls are let through,
+// the circuit closes if all of them succeed, or opens again if any of them fails.
+type breakerConfig struct {
+	Methods        []string `mapstructure:"methods"`
+	Scope          string   `mapstructure:"scope"`
+	FailureRate    float64  `mapstructure:"failure_rate"`
+	SlowCall       string   `mapstructure:"slow_call"`
+	MinRequests    int      `mapstructure:"min_requests"`
+	Window         string   `mapstructure:"window"`
+	OpenDuration   string   `mapstructure:"open_duration"`
+	HalfOpenProbes int      `mapstructure:"half_open_probes"`
+}
+
+type breakerPolicy struct {
+	methods      []string
+	perBackend   bool
+	failureRate  float64
+	slowCall     time.Duration
+	minRequests  int
+	window       time.Duration
+	openDuration time.Duration
+	probes       int
+	mutex        sync.Mutex
+	circuits     map[string]*circuit
+}
+
+// outcomes of calls, see outcomeOf
+const (
+	callSucceeded = iota
+	callFailed
+	// callNeutral is an error not caused by the backend, e.g. an invalid request body, it's not counted
+	callNeutral
+)
+
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuit struct {
+	mutex       sync.Mutex
+	state       int
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     int
+	probed      int
+}
+
+// CircuitOpenError is returned instead of calling a backend method, when its circuit is open
+type CircuitOpenError struct {
+	// Name is the rpc method, or "backend" if the circuit is shared by methods
+	Name string
+	// RetryAfter is the time left before the circuit lets calls through
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "turbo: circuit of " + e.Name + " is open"
+}
+
+// loadBreakers loads the "circuitbreaker" section
+func (c *Config) loadBreakers() {
+	c.breakers = nil
+	if !c.IsSet("circuitbreaker") {
+		return
+	}
+	items := make([]breakerConfig, 0)
+	panicIf(c.UnmarshalKey("circuitbreaker", &items))
+	for _, item := range items {
+		p, err := newBreakerPolicy(item)
+		panicIf(err)
+		c.breakers = append(c.breakers, p)
+	}
+}
+
+func newBreakerPolicy(item breakerConfig) (*breakerPolicy, error) {
+	p := &breakerPolicy{methods: item.Methods, failureRate: item.FailureRate, minRequests: item.MinRequests,
+		probes: item.HalfOpenProbes, circuits: make(map[string]*circuit)}
+	switch item.Scope {
+	case "", "method":
+	case "backend":
+		p.perBackend = true
+	default:
+		return nil, errors.New(fmt.Sprintf("turbo: invalid circuitbreaker scope: %s, should be 'method' or 'backend'", item.Scope))
+	}
+	if len(p.methods) == 0 {
+		return nil, errors.New("turbo: no methods in circuitbreaker")
+	}
+	if p.failureRate <= 0 || p.failureRate > 1 {
+		p.failureRate = 0.5
+	}
+	if p.minRequests <= 0 {
+		p.minRequests = 10
+	}
+	if p.probes <= 0 {
+		p.probes = 1
+	}
+	var err error
+	for _, d := range []struct {
+		value        string
+		target       *time.Duration
+		defaultValue time.Duration
+	}{
+		{item.SlowCall, &p.slowCall, 0},
+		{item.Window, &p.window, 10 * time.Second},
+		{item.OpenDuration, &p.openDuration, 30 * time.Second},
+	} {
+		*d.target = d.defaultValue
+		if len(d.value) > 0 {
+			if *d.target, err = time.ParseDuration(d.value); err != nil || *d.target < 0 {
+				return nil, errors.New(fmt.Sprintf("turbo: invalid duration in circuitbreaker: %s", d.value))
+			}
+		}
+	}
+	return p, nil
+}
+
+// id identifies a policy, circuits of the same policy are kept on reloading config
+func (p *breakerPolicy) id() string {
+	return strings.Join(p.methods, ",") + " " + strconv.FormatBool(p.perBackend)
+}
+
+// inheritBreakers keeps states of circuits on reloading config
+func (c *Config) inheritBreakers(old *Config) {
+	for _, p := range c.breakers {
+		for _, o := range old.breakers {
+			if p.id() == o.id() {
+				o.mutex.Lock()
+				p.circuits = o.circuits
+				o.mutex.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// circuitOf returns the circuit of an rpc method, or nil if it has no circuit breaker
+func (c *Config) circuitOf(methodName string) (*breakerPolicy, *circuit, string) {
+	for _, p := range c.breakers {
+		for _, m := range p.methods {
+			if m != "*" && m != methodName {
+				continue
+			}
+			name := methodName
+			if p.perBackend {
+				name = "backend"
+			}
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			cb, ok := p.circuits[name]
+			if !ok {
+				cb = &circuit{}
+				p.circuits[name] = cb
+			}
+			return p, cb, name
+		}
+	}
+	return nil, nil, ""
+}
+
+// allow returns true if a call can be made, and whether it's a probe of a half-open circuit,
+// it returns the time left before calls are let through if the call can not be made.
+func (cb *circuit) allow(p *breakerPolicy, now time.Time) (bool, bool, time.Duration) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == circuitOpen {
+		if left := cb.openedAt.Add(p.openDuration).Sub(now); left > 0 {
+			return false, false, left
+		}
+		cb.state, cb.probing, cb.probed = circuitHalfOpen, 0, 0
+	}
+	if cb.state == circuitHalfOpen {
+		if cb.probing+cb.probed >= p.probes {
+			return false, false, time.Second
+		}
+		cb.probing++
+		return true, true, 0
+	}
+	return true, false, 0
+}
+
+// done records the outcome of a call, a neutral probe only frees its slot for another probe
+func (cb *circuit) done(p *breakerPolicy, name string, probe bool, outcome int, now time.Time) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	failed := outcome == callFailed
+	if probe {
+		if cb.state != circuitHalfOpen {
+			return
+		}
+		cb.probing--
+		if outcome == callNeutral {
+			return
+		}
+		if failed {
+			cb.open(name, now)
+			return
+		}
+		if cb.probed++; cb.probed >= p.probes {
+			cb.state, cb.windowStart, cb.requests, cb.failures = circuitClosed, now, 0, 0
+			log.Info("turbo: circuit of ", name, " is closed")
+		}
+		return
+	}
+	if cb.state != circuitClosed || outcome == callNeutral {
+		return
+	}
+	if now.Sub(cb.windowStart) >= p.window {
+		cb.windowStart, cb.requests, cb.failures = now, 0, 0
+	}
+	cb.requests++
+	if failed {
+		cb.failures++
+	}
+	if cb.requests >= p.minRequests && float64(cb.failures)/float64(cb.requests) >= p.failureRate {
+		cb.open(name, now)
+	}
+}
+
+func (cb *circuit) open(name string, now time.Time) {
+	cb.state, cb.openedAt = circuitOpen, now
+	log.Warn("turbo: circuit of ", name, " is open")
+}
+
+// outcomeOf returns callFailed for errors of the rpc itself: grpc Unavailable, DeadlineExceeded,
+// ResourceExhausted and Internal, and thrift transport errors. Other grpc errors are answers of the backend,
+// they're callSucceeded, other errors come from switchers building requests, e.g. invalid json, they're callNeutral.
+func outcomeOf(err error) int {
+	if err == nil {
+		return callSucceeded
+	}
+	if _, ok := err.(thrift.TTransportException); ok {
+		return callFailed
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return callNeutral
+	}
+	switch s.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal:
+		return callFailed
+	}
+	return callSucceeded
+}
+
+// callBackend calls switcherFunc through the circuit breaker of the method, if any
+func callBackend(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	p, cb, name := s.ServerField().Config.circuitOf(methodName)
+	if cb == nil {
+		return switcherFunc(s, methodName, resp, req)
+	}
+	ok, probe, left := cb.allow(p, time.Now())
+	if !ok {
+		return nil, &CircuitOpenError{Name: name, RetryAfter: left}
+	}
+	start, outcome := time.Now(), callNeutral
+	defer func() {
+		end := time.Now()
+		if outcome == callSucceeded && p.slowCall > 0 && end.Sub(start) > p.slowCall {
+			outcome = callFailed
+		}
+		cb.done(p, name, probe, outcome, end)
+	}()
+	serviceResp, err := switcherFunc(s, methodName, resp, req)
+	outcome = outcomeOf(err)
+	return serviceResp, err
+}
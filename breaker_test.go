@@ -0,0 +1,127 @@
+package turbo
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuit(t *testing.T) {
+	p, err := newBreakerPolicy(breakerConfig{Methods: []string{"*"}, MinRequests: 4, FailureRate: 0.5,
+		Window: "1m", OpenDuration: "10s", HalfOpenProbes: 2})
+	assert.Nil(t, err)
+	cb := &circuit{}
+	now := time.Now()
+	for i, outcome := range []int{callSucceeded, callFailed, callNeutral, callSucceeded, callFailed} {
+		ok, probe, _ := cb.allow(p, now)
+		assert.True(t, ok, i)
+		assert.False(t, probe)
+		cb.done(p, "m", probe, outcome, now)
+	}
+	ok, _, left := cb.allow(p, now.Add(time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, 9*time.Second, left)
+
+	// half-open
+	later := now.Add(10 * time.Second)
+	ok, probe1, _ := cb.allow(p, later)
+	assert.True(t, ok && probe1)
+	ok, probe2, _ := cb.allow(p, later)
+	assert.True(t, ok && probe2)
+	ok, _, _ = cb.allow(p, later)
+	assert.False(t, ok)
+	// a neutral probe lets another probe through
+	cb.done(p, "m", true, callNeutral, later)
+	ok, _, _ = cb.allow(p, later)
+	assert.True(t, ok)
+	cb.done(p, "m", true, callSucceeded, later)
+	cb.done(p, "m", true, callFailed, later)
+	assert.Equal(t, circuitOpen, cb.state)
+
+	later = later.Add(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		_, probe, _ := cb.allow(p, later)
+		cb.done(p, "m", probe, callSucceeded, later)
+	}
+	assert.Equal(t, circuitClosed, cb.state)
+	// failures in an old window are not counted
+	for i := 0; i < 3; i++ {
+		cb.done(p, "m", false, callFailed, later)
+	}
+	cb.done(p, "m", false, callFailed, later.Add(time.Minute))
+	assert.Equal(t, circuitClosed, cb.state)
+
+	_, err = newBreakerPolicy(breakerConfig{Methods: []string{"*"}, Scope: "host"})
+	assert.NotNil(t, err)
+	_, err = newBreakerPolicy(breakerConfig{Methods: []string{"*"}, Window: "x"})
+	assert.NotNil(t, err)
+}
+
+func TestOutcomeOf(t *testing.T) {
+	assert.Equal(t, callSucceeded, outcomeOf(nil))
+	assert.Equal(t, callNeutral, outcomeOf(errors.New("turbo: failed to BuildRequest for json api")))
+	assert.Equal(t, callNeutral, outcomeOf(errBodyTooLarge))
+	assert.Equal(t, callFailed, outcomeOf(thrift.NewTTransportException(thrift.END_OF_FILE, "EOF")))
+	assert.Equal(t, callFailed, outcomeOf(status.Error(codes.Unavailable, "")))
+	assert.Equal(t, callFailed, outcomeOf(status.Error(codes.DeadlineExceeded, "")))
+	assert.Equal(t, callFailed, outcomeOf(status.Error(codes.ResourceExhausted, "")))
+	assert.Equal(t, callFailed, outcomeOf(status.Error(codes.Internal, "")))
+	assert.Equal(t, callSucceeded, outcomeOf(status.Error(codes.NotFound, "")))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"circuitbreaker": []map[string]interface{}{
+		{"methods": []string{"GetRule"}, "min_requests": 2, "open_duration": "1m", "slow_call": "20ms"},
+		{"methods": []string{"*"}, "scope": "backend"},
+	}})
+	calls := 0
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		calls++
+		if calls == 2 {
+			time.Sleep(30 * time.Millisecond)
+		}
+		return &testRuleRequest{}, nil
+	}
+	assert.Equal(t, http.StatusOK, serveTestRequest(s, "GET", "/v1/rules/1", "").Code)
+	assert.Equal(t, http.StatusOK, serveTestRequest(s, "GET", "/v1/rules/1", "").Code)
+	resp := serveTestRequest(s, "GET", "/v1/rules/1", "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+	assert.Equal(t, "turbo: circuit of GetRule is open\n", resp.Body.String())
+	assert.Equal(t, 2, calls)
+
+	_, _, name := s.Config.circuitOf("SayHello")
+	assert.Equal(t, "backend", name)
+	p, cb, _ := s.Config.circuitOf("GetRule")
+	assert.Equal(t, 20*time.Millisecond, p.slowCall)
+
+	// invalid requests don't open the circuit of a healthy backend
+	calls = 0
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		calls++
+		v := &testRuleRequest{}
+		err := BuildRequest(s, v, req)
+		return v, err
+	}
+	for i := 0; i < 20; i++ {
+		serveTestRequest(s, "POST", "/hello", `{"name":`)
+	}
+	assert.Equal(t, 20, calls)
+	_, backend, _ := s.Config.circuitOf("SayHello")
+	assert.Equal(t, circuitClosed, backend.state)
+	assert.Equal(t, 0, backend.failures)
+	assert.Equal(t, http.StatusOK, serveTestRequest(s, "POST", "/hello", `{"name":"x"}`).Code)
+
+	// circuits are kept on reload
+	c := newTestConfig(map[string]interface{}{"circuitbreaker": []map[string]interface{}{{"methods": []string{"GetRule"}}}})
+	c.inheritBreakers(s.Config)
+	_, cb2, _ := c.circuitOf("GetRule")
+	assert.True(t, cb == cb2)
+}
@@ -0,0 +1,546 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/mux"
+)
+
+// cacheConfig is the "cache" section in config file, e.g.
+//
+//	cache:
+//	  max_entries: 10000
+//	  routes:
+//	    - route: GET /v1/rules/
+//	      ttl: 30s
+//	      query: [page, size]
+//	      headers: [Accept-Language]
+//
+// Responses of backends are cached for GET requests matching "routes", the same way as component mappings do,
+// for "ttl". The cache key is the path, values of "query" params (all params if it's not set), values of "headers",
+// the authenticated principal, see PrincipalOf, and values bound to request fields by the "jwt" and "auth" sections,
+// so responses of a caller are never served to others, the client IP is not in the key.
+// Responses, proto messages or thrift structs, are deep copied when they're cached and served,
+// so changes to a response, e.g. by a postprocessor, don't leak into the cache.
+// Responses are encoded for each request, so "Accept", "format" and "fields" work as they do without cache.
+// The least recently used entries are removed if there are more than "max_entries" (default 10000).
+// "Cache-Control" request directives "no-cache", "no-store", "max-age" and "only-if-cached" are honored.
+// Responses of cached routes carry a weak "ETag", "If-None-Match" gets 304 if it's matched.
+// If "singleflight" of a route is true, concurrent requests with the same cache key share one backend call,
+// each of them still runs its own interceptors and postprocessor, "ttl" can be omitted to coalesce requests only.
+// The shared call is not canceled if the request starting it is, it times out after "singleflight_timeout"
+// (default 30s) instead, each request gets its own copy of the response, and the headers set by the call.
+type cacheConfig struct {
+	MaxEntries int          `mapstructure:"max_entries"`
+	Routes     []cacheRoute `mapstructure:"routes"`
+}
+
+// cacheRoute is an item in "routes" of the "cache" section
+type cacheRoute struct {
+	Route         string   `mapstructure:"route"`
+	TTL           string   `mapstructure:"ttl"`
+	Query         []string `mapstructure:"query"`
+	Headers       []string `mapstructure:"headers"`
+	Singleflight  bool     `mapstructure:"singleflight"`
+	FlightTimeout string   `mapstructure:"singleflight_timeout"`
+	ttl           time.Duration
+	flightTimeout time.Duration
+}
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (r *cacheRoute) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+type responseCache struct {
+	routes  *mux.Router
+	lru     *lruCache
+	flights *flightGroup
+}
+
+// loadCache loads the "cache" section, cache is nil if there is no such section
+func (c *Config) loadCache() {
+	c.cache = nil
+	if !c.IsSet("cache") {
+		return
+	}
+	conf := &cacheConfig{}
+	panicIf(c.UnmarshalKey("cache", conf))
+	if conf.MaxEntries <= 0 {
+		conf.MaxEntries = 10000
+	}
+	rc := &responseCache{lru: newLRUCache(conf.MaxEntries), flights: &flightGroup{calls: make(map[string]*flightCall)}}
+	for i := range conf.Routes {
+		route := &conf.Routes[i]
+		methods, path := parseRoute(route.Route)
+		if len(path) == 0 {
+			panic("turbo: invalid cache route: " + route.Route)
+		}
+		if len(route.TTL) > 0 || !route.Singleflight {
+			ttl, err := time.ParseDuration(route.TTL)
+			if err != nil || ttl < 0 || (ttl == 0 && !route.Singleflight) {
+				panic("turbo: invalid cache ttl: " + route.TTL + ", route: " + route.Route)
+			}
+			route.ttl = ttl
+		}
+		route.flightTimeout = 30 * time.Second
+		if len(route.FlightTimeout) > 0 {
+			timeout, err := time.ParseDuration(route.FlightTimeout)
+			if err != nil || timeout <= 0 {
+				panic("turbo: invalid singleflight_timeout: " + route.FlightTimeout + ", route: " + route.Route)
+			}
+			route.flightTimeout = timeout
+		}
+		if len(methods) == 0 {
+			methods = []string{"GET"}
+		}
+		rc.routes = setComponent(rc.routes, methods, path, route)
+	}
+	c.cache = rc
+}
+
+// inheritCache keeps cached responses on reloading config, the size limit is updated
+func (c *Config) inheritCache(old *Config) {
+	if c.cache != nil && old.cache != nil {
+		old.cache.lru.resize(c.cache.lru.maxEntries)
+		c.cache.lru, c.cache.flights = old.cache.lru, old.cache.flights
+	}
+}
+
+// cacheRouteOf returns the cache options of a request, if it's cacheable
+func cacheRouteOf(s Servable, req *http.Request) (*cacheRoute, *responseCache, bool) {
+	rc := s.ServerField().Config.cache
+	if rc == nil || req.Method != "GET" {
+		return nil, nil, false
+	}
+	cp := component(rc.routes, req)
+	if cp == nil {
+		return nil, nil, false
+	}
+	return cp.(*cacheRoute), rc, true
+}
+
+// keyOf returns the cache key of a request
+func (r *cacheRoute) keyOf(req *http.Request) string {
+	var b bytes.Buffer
+	b.WriteString(req.URL.Path)
+	query := req.URL.Query()
+	names := r.Query
+	if names == nil {
+		names = make([]string, 0, len(query))
+		for name := range query {
+			names = append(names, name)
+		}
+	}
+	names = append([]string{}, names...)
+	sort.Strings(names)
+	for _, name := range names {
+		if values, ok := query[name]; ok {
+			b.WriteString("\n?" + name + "=" + strings.Join(values, "\x00"))
+		}
+	}
+	for _, name := range r.Headers {
+		b.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(req.Header[http.CanonicalHeaderKey(name)], "\x00"))
+	}
+	if p, ok := PrincipalOf(req.Context()); ok {
+		b.WriteString("\n@" + p.Provider + ":" + p.Name)
+	}
+	bound, _ := req.Context().Value(boundValuesKey{}).(map[string]string)
+	names = make([]string, 0, len(bound))
+	for name := range bound {
+		if name != "client_ip" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("\n=" + name + "=" + bound[name])
+	}
+	return b.String()
+}
+
+// cacheControl parses "Cache-Control" directives of a request
+func cacheControl(req *http.Request) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range req.Header["Cache-Control"] {
+		for _, d := range strings.Split(value, ",") {
+			kv := strings.SplitN(strings.TrimSpace(d), "=", 2)
+			if len(kv[0]) == 0 {
+				continue
+			}
+			if len(kv) == 2 {
+				directives[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+			} else {
+				directives[strings.ToLower(kv[0])] = ""
+			}
+		}
+	}
+	return directives
+}
+
+// callService calls the backend, see callWithRetry, or returns the cached response if the route is cached,
+// it returns false if the response is written already.
+func callService(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, bool, error) {
+	route, rc, ok := cacheRouteOf(s, req)
+	if !ok {
+		serviceResp, err := callWithRetry(s, methodName, resp, req)
+		return serviceResp, true, err
+	}
+	directives := cacheControl(req)
+	_, noStore := directives["no-store"]
+	_, noCache := directives["no-cache"]
+	key := route.Route + "\n" + route.keyOf(req)
+	now := time.Now()
+	cached := route.ttl > 0
+	if cached && !noStore && !noCache {
+		maxAge := time.Duration(-1)
+		if v, ok := directives["max-age"]; ok {
+			if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+		if e, ok := rc.lru.get(key, now); ok && (maxAge < 0 || now.Sub(e.stored) <= maxAge) {
+			resp.Header().Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+			resp.Header().Set("X-Cache", "HIT")
+			return cloneValue(e.value), true, nil
+		}
+	}
+	if _, ok := directives["only-if-cached"]; ok {
+		http.Error(resp, "turbo: response is not cached", http.StatusGatewayTimeout)
+		return nil, false, nil
+	}
+	var serviceResp interface{}
+	var err error
+	if route.Singleflight {
+		serviceResp, err = route.share(rc.flights, key, s, methodName, resp, req)
+	} else {
+		serviceResp, err = callWithRetry(s, methodName, resp, req)
+	}
+	if !cached {
+		return serviceResp, true, err
+	}
+	resp.Header().Set("X-Cache", "MISS")
+	if err == nil && !noStore {
+		rc.lru.add(key, cloneValue(serviceResp), now, now.Add(route.ttl))
+	}
+	return serviceResp, true, err
+}
+
+// cloneValue returns a deep copy of v, proto messages are copied by proto.Clone, other values,
+// such as thrift structs, by copyValue
+func cloneValue(v interface{}) interface{} {
+	if m, ok := v.(proto.Message); ok && m != nil {
+		return proto.Clone(m)
+	}
+	if v == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(v)).Interface()
+}
+
+// copyValue returns a deep copy of v with reflection, unexported fields of structs are copied shallowly
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMap(v.Type())
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(copyValue(key), copyValue(v.MapIndex(key)))
+		}
+		return c
+	}
+	return v
+}
+
+// flightResult is the result of a call shared by requests, see cacheRoute.share
+type flightResult struct {
+	serviceResp interface{}
+	// header is set by the switcher
+	header http.Header
+	// ctx is the context of the request after the call, with values put by the switcher, e.g. grpc headers
+	ctx context.Context
+}
+
+// share calls the backend once for concurrent requests with the same key, see flightGroup.
+// The call runs on a copy of req, detached from the cancellation of req, with a buffered ResponseWriter,
+// so it's neither canceled by, nor writes to, the request starting it, which may leave before it's done.
+// Each caller gets its own deep copy of the response, see cloneValue, so their postprocessors don't race on it.
+func (r *cacheRoute) share(g *flightGroup, key string, s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	v, err := g.do(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(detachedContext{req.Context()}, r.flightTimeout)
+		defer cancel()
+		buf := newResponseBuffer()
+		shared := req.WithContext(ctx)
+		serviceResp, err := callWithRetry(s, methodName, buf, shared)
+		return &flightResult{serviceResp: serviceResp, header: buf.Header(), ctx: shared.Context()}, err
+	})
+	result, ok := v.(*flightResult)
+	if !ok {
+		return nil, err
+	}
+	for name, values := range result.header {
+		resp.Header()[name] = append(resp.Header()[name], values...)
+	}
+	copyCallOptions(req, result.ctx)
+	return cloneValue(result.serviceResp), err
+}
+
+// detachedContext keeps values of its parent, but not its deadline and cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// responseBuffer is a ResponseWriter keeping the header, status and body in memory
+type responseBuffer struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+// copyTo writes the buffered header, status and body to resp
+func (b *responseBuffer) copyTo(resp http.ResponseWriter) {
+	for name, values := range b.header {
+		resp.Header()[name] = append(resp.Header()[name], values...)
+	}
+	if b.code != 0 {
+		resp.WriteHeader(b.code)
+		resp.Write(b.body.Bytes())
+	}
+}
+
+// flightGroup runs one call at a time for a key, callers with the same key wait for, and share, its result
+type flightGroup struct {
+	mutex sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg    sync.WaitGroup
+	value interface{}
+	err   error
+}
+
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mutex.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mutex.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+	// waiters get this error if fn panics
+	c := &flightCall{err: errors.New("turbo: the shared backend call panicked")}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mutex.Unlock()
+	defer func() {
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+		c.wg.Done()
+	}()
+	c.value, c.err = fn()
+	return c.value, c.err
+}
+
+// notModified sets a weak "ETag" of data, it returns true if the "If-None-Match" header is matched
+func notModified(resp http.ResponseWriter, req *http.Request, data []byte) bool {
+	sum := sha256.Sum256(data)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	resp.Header().Set("ETag", etag)
+	for _, value := range req.Header["If-None-Match"] {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// purge removes entries of paths with 'prefix', it returns the number of entries removed
+func (rc *responseCache) purge(prefix string) int {
+	return rc.lru.removeIf(func(key string) bool {
+		// keys are "[route]\n[path]..."
+		lines := strings.SplitN(key, "\n", 3)
+		return len(lines) > 1 && strings.HasPrefix(lines[1], prefix)
+	})
+}
+
+// cachePurgeHandler removes cached responses at "DELETE /_turbo/cache", for admin principals, see adminHandler,
+// only those of paths with the "prefix" query param are removed, if it's set.
+func cachePurgeHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		rc := s.ServerField().Config.cache
+		if rc == nil {
+			http.Error(resp, "turbo: cache is not enabled", http.StatusNotFound)
+			return
+		}
+		data, _ := json.Marshal(map[string]int{"purged": rc.purge(req.URL.Query().Get("prefix"))})
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write(data)
+	}
+}
+
+// lruCache is a cache with a size limit, the least recently used entries are removed first
+type lruCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	stored  time.Time
+	expires time.Time
+}
+
+func newLRUCache(maxEntries int) *lruCache {
+	return &lruCache{maxEntries: maxEntries, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (l *lruCache) get(key string, now time.Time) (*lruEntry, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if !now.Before(entry.expires) {
+		l.order.Remove(e)
+		delete(l.entries, key)
+		return nil, false
+	}
+	l.order.MoveToFront(e)
+	return entry, true
+}
+
+func (l *lruCache) add(key string, value interface{}, now, expires time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	entry := &lruEntry{key: key, value: value, stored: now, expires: expires}
+	if e, ok := l.entries[key]; ok {
+		e.Value = entry
+		l.order.MoveToFront(e)
+		return
+	}
+	l.entries[key] = l.order.PushFront(entry)
+	l.evict()
+}
+
+func (l *lruCache) resize(maxEntries int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.maxEntries = maxEntries
+	l.evict()
+}
+
+func (l *lruCache) evict() {
+	for l.order.Len() > l.maxEntries {
+		e := l.order.Back()
+		l.order.Remove(e)
+		delete(l.entries, e.Value.(*lruEntry).key)
+	}
+}
+
+func (l *lruCache) removeIf(f func(key string) bool) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	removed := 0
+	for key, e := range l.entries {
+		if f(key) {
+			l.order.Remove(e)
+			delete(l.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,279 @@
+package turbo
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	l := newLRUCache(2)
+	now := time.Now()
+	l.add("a", 1, now, now.Add(time.Minute))
+	l.add("b", 2, now, now.Add(time.Minute))
+	_, ok := l.get("a", now)
+	assert.True(t, ok)
+	l.add("c", 3, now, now.Add(time.Minute))
+	_, ok = l.get("b", now)
+	assert.False(t, ok)
+	e, ok := l.get("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, 1, e.value)
+	_, ok = l.get("a", now.Add(time.Minute))
+	assert.False(t, ok)
+	l.resize(0)
+	assert.Equal(t, 0, l.order.Len())
+}
+
+func TestCacheKey(t *testing.T) {
+	r := &cacheRoute{Headers: []string{"accept-language"}}
+	req := httptest.NewRequest("GET", "/v1/rules/1?b=2&a=1", nil)
+	req.Header.Set("Accept-Language", "en")
+	assert.Equal(t, "/v1/rules/1\n?a=1\n?b=2\nAccept-Language: en", r.keyOf(req))
+	r = &cacheRoute{Query: []string{"b", "c"}}
+	assert.Equal(t, "/v1/rules/1\n?b=2", r.keyOf(req))
+
+	// callers and values bound to their requests are in the key, the client IP is not
+	ctx := context.WithValue(req.Context(), principalKey{}, Principal{Name: "alice", Provider: "jwt"})
+	ctx = bindValue(bindValue(bindValue(ctx, "user_id", "1"), "tenant", "t1"), "client_ip", "1.2.3.4")
+	assert.Equal(t, "/v1/rules/1\n?b=2\n@jwt:alice\n=tenant=t1\n=user_id=1", r.keyOf(req.WithContext(ctx)))
+
+	req.Header.Set("Cache-Control", `no-cache, max-age="10"`)
+	assert.Equal(t, map[string]string{"no-cache": "", "max-age": "10"}, cacheControl(req))
+}
+
+// testThriftResult is like a struct generated by thrift
+type testThriftResult struct {
+	Name   *string          `thrift:"name,1" json:"name,omitempty"`
+	Tags   []string         `thrift:"tags,2" json:"tags"`
+	Counts map[string]int32 `thrift:"counts,3" json:"counts"`
+	Child  *testRuleChild   `thrift:"child,4" json:"child,omitempty"`
+}
+
+func TestCloneValue(t *testing.T) {
+	name := "a"
+	v := &testThriftResult{Name: &name, Tags: []string{"x"}, Counts: map[string]int32{"x": 1}, Child: &testRuleChild{Title: "t"}}
+	c := cloneValue(v).(*testThriftResult)
+	assert.Equal(t, v, c)
+	*c.Name, c.Tags[0], c.Counts["x"], c.Child.Title = "b", "y", 2, "u"
+	assert.Equal(t, "a", *v.Name)
+	assert.Equal(t, []string{"x"}, v.Tags)
+	assert.Equal(t, map[string]int32{"x": 1}, v.Counts)
+	assert.Equal(t, "t", v.Child.Title)
+	assert.Nil(t, cloneValue(nil))
+	assert.Equal(t, 1, cloneValue(1))
+	var empty *testThriftResult
+	assert.Equal(t, empty, cloneValue(empty))
+}
+
+func TestResponseCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_cache")
+	defer os.RemoveAll(dir)
+	auth := testAuthConfig(t, dir)
+	auth["routes"] = []string{"DELETE /_turbo/ apikey"}
+	s := newTestServerWith(map[string]interface{}{"auth": auth, "cache": map[string]interface{}{
+		"max_entries": 10,
+		"routes":      []map[string]interface{}{{"route": "/v1/rules/", "ttl": "1m", "query": []string{"page"}}},
+	}})
+	s.Config.configs[adminEnabled] = "true"
+	s.Config.configs[adminPrincipals] = "Partner-A"
+	calls := 0
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		calls++
+		return &testRuleRequest{Id: int64(calls)}, nil
+	}
+	get := func(url string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp := httptest.NewRecorder()
+		router(s).ServeHTTP(resp, req)
+		return resp
+	}
+	resp := get("/v1/rules/12?page=1&t=1")
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	etag := resp.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	resp = get("/v1/rules/12?page=1&t=2", "Accept", "application/xml")
+	assert.Equal(t, "HIT", resp.Header().Get("X-Cache"))
+	assert.Contains(t, resp.Body.String(), "<id>1</id>")
+	assert.Equal(t, 1, calls)
+
+	// cached responses are copies
+	v, _, _ := callService(s, "", httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/rules/12?page=1", nil))
+	v.(*testRuleRequest).Id = 100
+	assert.Contains(t, get("/v1/rules/12?page=1").Body.String(), `"id":1`)
+
+	resp = get("/v1/rules/12?page=1", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	assert.Equal(t, "", resp.Body.String())
+
+	resp = get("/v1/rules/12?page=2", "Cache-Control", "only-if-cached")
+	assert.Equal(t, http.StatusGatewayTimeout, resp.Code)
+	get("/v1/rules/12?page=1", "Cache-Control", "no-cache")
+	assert.Equal(t, 2, calls)
+	resp = get("/v1/rules/12?page=1", "Cache-Control", "no-store")
+	assert.Equal(t, "MISS", resp.Header().Get("X-Cache"))
+	assert.Contains(t, get("/v1/rules/12?page=1").Body.String(), `"id":2`)
+	assert.Contains(t, get("/v1/rules/12?page=1", "Cache-Control", "max-age=0").Body.String(), `"id":4`)
+	assert.Contains(t, get("/v1/rules/12?page=1", "Cache-Control", "max-age=60").Body.String(), `"id":4`)
+
+	// purge
+	resp = serveTestRequest(s, "DELETE", "/_turbo/cache", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "HIT", get("/v1/rules/12?page=1").Header().Get("X-Cache"))
+	resp = serveAdminRequest(s, "DELETE", "/_turbo/cache?prefix=/v1/rules/13", "key-a")
+	assert.Equal(t, `{"purged":0}`, resp.Body.String())
+	resp = serveAdminRequest(s, "DELETE", "/_turbo/cache", "key-a")
+	assert.Equal(t, `{"purged":1}`, resp.Body.String())
+	assert.Equal(t, "MISS", get("/v1/rules/12?page=1").Header().Get("X-Cache"))
+
+	// cached responses are kept on reload
+	c := newTestConfig(map[string]interface{}{"cache": map[string]interface{}{"routes": []map[string]interface{}{{"route": "/v1/rules/", "ttl": "1m"}}}})
+	c.inheritCache(s.Config)
+	assert.Equal(t, 1, c.cache.lru.order.Len())
+	assert.Equal(t, 10000, c.cache.lru.maxEntries)
+}
+
+func TestSingleflight(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"cache": map[string]interface{}{
+		"routes": []map[string]interface{}{{"route": "GET /hello", "query": []string{"your_name"}, "singleflight": true}},
+	}})
+	s.Components.SetPostprocessor([]string{"GET"}, "/hello", func(resp http.ResponseWriter, req *http.Request, v interface{}, err error) {
+		resp.Write([]byte(v.(*testRuleRequest).Name + req.Header.Get("X-N")))
+	})
+	var calls int32
+	release := make(chan bool)
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &testRuleRequest{Name: req.URL.Query().Get("your_name")}, nil
+	}
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/hello?your_name=x", nil)
+			req.Header.Set("X-N", strconv.Itoa(i))
+			router(s).ServeHTTP(resp, req)
+			bodies[i] = resp.Body.String()
+		}(i)
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for i, body := range bodies {
+		assert.Equal(t, "x"+strconv.Itoa(i), body)
+	}
+	// not cached
+	resp := httptest.NewRecorder()
+	router(s).ServeHTTP(resp, httptest.NewRequest("GET", "/hello?your_name=y", nil))
+	router(s).ServeHTTP(resp, httptest.NewRequest("GET", "/hello?your_name=y", nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, "", resp.Header().Get("ETag"))
+
+	// the shared call is not canceled with the request starting it, each request gets its own copy
+	var ctxErr error
+	release = make(chan bool)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		ctxErr = req.Context().Err()
+		resp.Header().Set("X-Shared", "1")
+		return &testRuleRequest{Name: "z"}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan interface{}, 2)
+	go func() {
+		v, _, _ := callService(s, "", httptest.NewRecorder(), httptest.NewRequest("GET", "/hello?your_name=z", nil).WithContext(ctx))
+		results <- v
+	}()
+	for atomic.LoadInt32(&calls) == 3 {
+		time.Sleep(time.Millisecond)
+	}
+	resp = httptest.NewRecorder()
+	go func() {
+		v, _, _ := callService(s, "", resp, httptest.NewRequest("GET", "/hello?your_name=z", nil))
+		results <- v
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(release)
+	v1, v2 := <-results, <-results
+	assert.Nil(t, ctxErr)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	assert.Equal(t, "z", v1.(*testRuleRequest).Name)
+	assert.Equal(t, "z", v2.(*testRuleRequest).Name)
+	assert.True(t, v1 != v2)
+	assert.Equal(t, "1", resp.Header().Get("X-Shared"))
+
+	g := &flightGroup{calls: make(map[string]*flightCall)}
+	assert.Panics(t, func() { g.do("k", func() (interface{}, error) { panic("x") }) })
+	assert.Equal(t, 0, len(g.calls))
+}
+
+func TestSingleflightCopies(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"cache": map[string]interface{}{
+		"routes": []map[string]interface{}{{"route": "GET /hello", "singleflight": true}},
+	}})
+	// postprocessors of callers sharing a call change their own copies of the response
+	s.Components.SetPostprocessor([]string{"GET"}, "/hello", func(resp http.ResponseWriter, req *http.Request, v interface{}, err error) {
+		r := v.(*testThriftResult)
+		n := req.Header.Get("X-N")
+		*r.Name += n
+		r.Tags = append(r.Tags, n)
+		r.Counts[n]++
+		r.Child.Title += n
+		resp.Write([]byte(*r.Name + "," + strings.Join(r.Tags, ",") + "," + strconv.Itoa(len(r.Counts)) + "," + r.Child.Title))
+	})
+	var calls int32
+	release := make(chan bool)
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		name := "x"
+		return &testThriftResult{Name: &name, Tags: []string{}, Counts: map[string]int32{}, Child: &testRuleChild{}}, nil
+	}
+	var wg sync.WaitGroup
+	bodies := make([]string, 2)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/hello", nil)
+			req.Header.Set("X-N", strconv.Itoa(i))
+			router(s).ServeHTTP(resp, req)
+			bodies[i] = resp.Body.String()
+		}(i)
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, []string{"x0,0,1,0", "x1,1,1,1"}, bodies)
+}
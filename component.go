@@ -6,8 +6,10 @@
 package turbo
 
 import (
+	"math"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -20,6 +22,8 @@ type Components struct {
 	convertorMap         map[string]Convertor
 	errorHandler         ErrorHandlerFunc
 	registeredComponents map[string]interface{}
+	encoders             map[string]Encoder
+	encoderFormats       map[string]string
 }
 
 // Reset resets all component mappings
@@ -28,6 +32,8 @@ func (c *Components) Reset() {
 	c.routers = make(map[int]*mux.Router)
 	c.convertorMap = make(map[string]Convertor)
 	c.errorHandler = nil
+	c.encoders = make(map[string]Encoder)
+	c.encoderFormats = make(map[string]string)
 }
 
 const (
@@ -181,6 +187,15 @@ func (c *Components) convertor(theType string) Convertor {
 type ErrorHandlerFunc func(http.ResponseWriter, *http.Request, error)
 
 func defaultErrorHandler(resp http.ResponseWriter, req *http.Request, err error) {
+	if e, ok := err.(*CircuitOpenError); ok {
+		resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds()))))
+		http.Error(resp, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err == errBodyTooLarge {
+		http.Error(resp, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 	http.Error(resp, err.Error(), http.StatusInternalServerError)
 }
 
@@ -256,3 +271,16 @@ func (c *Components) SetConvertor(field string, convertorFunc Convertor) {
 func (c *Components) Convertor(theType string) Convertor {
 	return c.convertor(theType)
 }
+
+// SetEncoder registers an Encoder of responses for a media type, which replaces the built-in one, if any,
+// the Encoder is chosen if the media type is in "Accept" header, or if any of 'formats' is the "format" query param,
+// usage: SetEncoder("application/x-protobuf", protobufEncoder, "protobuf")
+func (c *Components) SetEncoder(mediaType string, e Encoder, formats ...string) {
+	c.setEncoder(mediaType, e, formats)
+}
+
+// Encoder returns the Encoder for a media type, built-in encoders are
+// "application/json", "application/xml", "application/yaml", "application/msgpack" and "text/csv"
+func (c *Components) Encoder(mediaType string) Encoder {
+	return c.encoder(mediaType)
+}
@@ -0,0 +1,365 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Compressor returns a writer which compresses data written to it into w, in a content coding,
+// e.g. "gzip", data are flushed to w on Close().
+type Compressor func(w io.Writer) io.WriteCloser
+
+var compressors = struct {
+	sync.RWMutex
+	m map[string]Compressor
+}{m: map[string]Compressor{
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	// "deflate" in HTTP is the zlib format
+	"deflate": func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	},
+	"br": func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	},
+}}
+
+// RegisterCompressor registers a Compressor for a content coding, which replaces the built-in one, if any,
+// "gzip", "deflate" and "br" are built in, other codings such as "zstd" can be registered with a third-party library,
+// usage: RegisterCompressor("zstd", func(w io.Writer) io.WriteCloser { return zstd.NewWriter(w) })
+func RegisterCompressor(encoding string, c Compressor) {
+	compressors.Lock()
+	defer compressors.Unlock()
+	compressors.m[strings.ToLower(encoding)] = c
+}
+
+func compressorOf(encoding string) Compressor {
+	compressors.RLock()
+	defer compressors.RUnlock()
+	return compressors.m[encoding]
+}
+
+// CompressionEnabled returns true if "compression_enabled" in config file is "true"
+func (c *Config) CompressionEnabled() bool {
+	return c.configs[compressionEnabled] == "true"
+}
+
+// CompressionEncodings returns "compression_encodings" in config file, a comma separated list of content codings,
+// in the order of preference, default is "br,gzip,deflate", codings without a Compressor are skipped,
+// e.g. "zstd" is skipped unless it's registered, see RegisterCompressor.
+func (c *Config) CompressionEncodings() []string {
+	return configList(c.configs[compressionEncodings], "br,gzip,deflate")
+}
+
+// logUnknownEncodings warns about codings in "compression_encodings" without a Compressor
+func logUnknownEncodings(c *Config) {
+	if !c.CompressionEnabled() {
+		return
+	}
+	for _, encoding := range c.CompressionEncodings() {
+		if compressorOf(encoding) == nil {
+			log.Warn("compression: no Compressor is registered for '", encoding, "' in compression_encodings, it's skipped")
+		}
+	}
+}
+
+// CompressionMinSize returns "compression_min_size" in config file, smaller responses are not compressed,
+// default is 1024 bytes.
+func (c *Config) CompressionMinSize() int {
+	size, err := strconv.Atoi(strings.TrimSpace(c.configs[compressionMinSize]))
+	if err != nil || size < 0 {
+		return 1024
+	}
+	return size
+}
+
+// CompressionContentTypes returns "compression_content_types" in config file, a comma separated list of
+// content types to compress, "text/*" matches all text types, default is all types written by built-in Encoders.
+func (c *Config) CompressionContentTypes() []string {
+	return configList(c.configs[compressionContentTypes],
+		"application/json,application/xml,application/yaml,application/msgpack,text/*")
+}
+
+func configList(value, defaultValue string) []string {
+	if len(strings.TrimSpace(value)) == 0 {
+		value = defaultValue
+	}
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// compressionHandler decompresses request bodies in "gzip", "deflate" or "br", and compresses responses,
+// if "compression_enabled" is true, in a coding negotiated by "Accept-Encoding".
+func compressionHandler(s Servable, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		c := s.ServerField().Config
+		if err := decompressRequest(req, c.MaxRequestBodySize()); err == errBodyTooLarge {
+			http.Error(resp, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(resp, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !c.CompressionEnabled() || req.Method == "HEAD" {
+			h.ServeHTTP(resp, req)
+			return
+		}
+		encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"), c.CompressionEncodings())
+		if len(encoding) == 0 {
+			h.ServeHTTP(resp, req)
+			return
+		}
+		w := &compressWriter{
+			ResponseWriter: resp,
+			encoding:       encoding,
+			compressor:     compressorOf(encoding),
+			minSize:        c.CompressionMinSize(),
+			contentTypes:   c.CompressionContentTypes(),
+		}
+		defer w.Close()
+		h.ServeHTTP(w, req)
+	})
+}
+
+var errBodyTooLarge = errors.New("turbo: request body is too large")
+
+// readBody reads a request body into memory through http.MaxBytesReader,
+// it returns errBodyTooLarge if the body is larger than maxSize.
+func readBody(resp http.ResponseWriter, body io.ReadCloser, maxSize int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(resp, body, maxSize))
+	if err != nil && int64(len(data)) == maxSize {
+		// MaxBytesReader fails once maxSize is read
+		return nil, errBodyTooLarge
+	}
+	return data, err
+}
+
+// decompressRequest replaces the body of a request in "gzip", "deflate" or "br" with the decompressed data,
+// so BuildRequest and BuildThriftRequest read plain data, it returns errBodyTooLarge if the decompressed data
+// is larger than maxSize.
+func decompressRequest(req *http.Request, maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if req.Body == nil || len(encoding) == 0 || encoding == "identity" {
+		return nil
+	}
+	var body io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(req.Body)
+	case "deflate":
+		body, err = zlib.NewReader(req.Body)
+	case "br":
+		body = ioutil.NopCloser(brotli.NewReader(req.Body))
+	default:
+		return nil
+	}
+	if err != nil {
+		return errors.New("turbo: failed to decompress request body in " + encoding + ", error: " + err.Error())
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxSize+1))
+	req.Body.Close()
+	if err != nil {
+		return errors.New("turbo: failed to decompress request body in " + encoding + ", error: " + err.Error())
+	}
+	if int64(len(data)) > maxSize {
+		return errBodyTooLarge
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.Header.Del("Content-Encoding")
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
+
+// negotiateEncoding returns the content coding in 'accept' with the highest quality, those with a Compressor only,
+// ties are broken by the order in 'preferred', "" means no compression.
+func negotiateEncoding(accept string, preferred []string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(encoding) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[encoding] = q
+	}
+	candidates := make([]string, 0)
+	for _, encoding := range preferred {
+		if compressorOf(encoding) == nil {
+			continue
+		}
+		if _, ok := qualities[encoding]; !ok {
+			if q, ok := qualities["*"]; ok {
+				qualities[encoding] = q
+			}
+		}
+		if qualities[encoding] > 0 {
+			candidates = append(candidates, encoding)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return qualities[candidates[i]] > qualities[candidates[j]] })
+	return candidates[0]
+}
+
+// compressWriter buffers the response until it's at least minSize bytes, or it's closed,
+// then compresses it if the content type is in contentTypes.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding     string
+	compressor   Compressor
+	minSize      int
+	contentTypes []string
+	status       int
+	buf          []byte
+	decided      bool
+	w            io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.w != nil {
+		return w.w.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide writes the header and the buffered data, compressed or not
+func (w *compressWriter) decide() error {
+	w.decided = true
+	h := w.Header()
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if len(h.Get("Content-Type")) == 0 && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	compressible := w.compressible(h.Get("Content-Type"))
+	if compressible {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compressible && len(w.buf) >= w.minSize && len(h.Get("Content-Encoding")) == 0 &&
+		len(h.Get("Content-Range")) == 0 && status != http.StatusNoContent && status != http.StatusNotModified {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.w = w.compressor(w.ResponseWriter)
+	}
+	if w.status != 0 || len(w.buf) > 0 || w.w != nil {
+		w.ResponseWriter.WriteHeader(status)
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	var err error
+	if w.w != nil {
+		_, err = w.w.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (w *compressWriter) compressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, t := range w.contentTypes {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush implements http.Flusher
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if f, ok := w.w.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.decided = true
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("turbo: http.Hijacker is not implemented")
+}
+
+// Close writes buffered data, and flushes compressed data
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+	if w.w != nil {
+		return w.w.Close()
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package turbo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestNegotiateEncoding(t *testing.T) {
+	preferred := []string{"zstd", "gzip", "deflate"}
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, deflate, zstd", preferred))
+	assert.Equal(t, "deflate", negotiateEncoding("gzip;q=0.5, deflate", preferred))
+	assert.Equal(t, "gzip", negotiateEncoding("*", preferred))
+	assert.Equal(t, "deflate", negotiateEncoding("*, gzip;q=0", preferred))
+	assert.Equal(t, "", negotiateEncoding("identity", preferred))
+	assert.Equal(t, "", negotiateEncoding("", preferred))
+	assert.Equal(t, "br", negotiateEncoding("gzip, deflate, br", []string{"br", "gzip", "deflate"}))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, br;q=0.5", []string{"br", "gzip", "deflate"}))
+
+	RegisterCompressor("zstd", func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} })
+	defer func() {
+		compressors.Lock()
+		delete(compressors.m, "zstd")
+		compressors.Unlock()
+	}()
+	assert.Equal(t, "zstd", negotiateEncoding("gzip, zstd", preferred))
+}
+
+func TestDecompressRequest(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`{"name":"turbo"}`))
+	w.Close()
+	req := httptest.NewRequest("POST", "/", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	assert.Nil(t, decompressRequest(req, 1024))
+	data, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, `{"name":"turbo"}`, string(data))
+	assert.Equal(t, int64(16), req.ContentLength)
+	assert.Equal(t, "", req.Header.Get("Content-Encoding"))
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader("plain"))
+	req.Header.Set("Content-Encoding", "deflate")
+	assert.NotNil(t, decompressRequest(req, 1024))
+
+	buf.Reset()
+	bw := brotli.NewWriter(&buf)
+	bw.Write([]byte(`{"name":"turbo"}`))
+	bw.Close()
+	req = httptest.NewRequest("POST", "/", &buf)
+	req.Header.Set("Content-Encoding", "br")
+	assert.Nil(t, decompressRequest(req, 1024))
+	data, _ = ioutil.ReadAll(req.Body)
+	assert.Equal(t, `{"name":"turbo"}`, string(data))
+	req = httptest.NewRequest("POST", "/", strings.NewReader("plain"))
+	req.Header.Set("Content-Encoding", "br")
+	assert.NotNil(t, decompressRequest(req, 1024))
+
+	// a small body which decompresses to more than the limit is rejected
+	buf.Reset()
+	w = gzip.NewWriter(&buf)
+	w.Write(bytes.Repeat([]byte("0"), 1<<20))
+	w.Close()
+	req = httptest.NewRequest("POST", "/", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	assert.Equal(t, errBodyTooLarge, decompressRequest(req, 1024))
+
+	s := newTestServer("test/service_test.yaml")
+	assert.Equal(t, []string{"br", "gzip", "deflate"}, s.Config.CompressionEncodings())
+	s.Config.configs[maxRequestBodySize] = "1024"
+	buf.Reset()
+	w = gzip.NewWriter(&buf)
+	w.Write(bytes.Repeat([]byte("0"), 1025))
+	w.Close()
+	req = httptest.NewRequest("POST", "/hello", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	compressionHandler(s, router(s)).ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
+
+func TestCompressionHandler(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	var request *testRuleRequest
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		request = &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequest(s, request, req)
+		return request, err
+	}
+	h := compressionHandler(s, router(s))
+	serve := func(body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/v1/rules/12", body)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		return resp
+	}
+	name := strings.Repeat("turbo", 300)
+
+	// request bodies are decompressed even if compression of responses is disabled
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(`{"name":"` + name + `"}`))
+	w.Close()
+	resp := serve(&buf, map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip", "Accept-Encoding": "gzip"})
+	assert.Equal(t, name, request.Name)
+	assert.Equal(t, "", resp.Header().Get("Content-Encoding"))
+
+	s.Config.configs[compressionEnabled] = "true"
+	resp = serve(strings.NewReader(`{"name":"`+name+`"}`), map[string]string{"Content-Type": "application/json", "Accept-Encoding": "deflate"})
+	assert.Equal(t, "deflate", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header()["Vary"], "Accept-Encoding")
+	r, err := zlib.NewReader(resp.Body)
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadAll(r)
+	assert.Contains(t, string(data), `"name":"`+name+`"`)
+
+	resp = serve(strings.NewReader(`{"name":"`+name+`"}`), map[string]string{"Content-Type": "application/json", "Accept-Encoding": "gzip, br"})
+	assert.Equal(t, "br", resp.Header().Get("Content-Encoding"))
+	assert.True(t, resp.Body.Len() < len(name))
+	data, _ = ioutil.ReadAll(brotli.NewReader(resp.Body))
+	assert.Contains(t, string(data), `"name":"`+name+`"`)
+
+	// small responses are not compressed
+	resp = serve(strings.NewReader(`{"name":"a"}`), map[string]string{"Content-Type": "application/json", "Accept-Encoding": "gzip"})
+	assert.Equal(t, "", resp.Header().Get("Content-Encoding"))
+	assert.Contains(t, resp.Body.String(), `"name":"a"`)
+
+	s.Config.configs[compressionMinSize] = "0"
+	s.Config.configs[compressionContentTypes] = "text/*"
+	resp = serve(strings.NewReader(`{"name":"a"}`), map[string]string{"Content-Type": "application/json", "Accept-Encoding": "gzip"})
+	assert.Equal(t, "", resp.Header().Get("Content-Encoding"))
+	s.Config.configs[compressionContentTypes] = ""
+	resp = serve(strings.NewReader(`{"name":"a"}`), map[string]string{"Content-Type": "application/json", "Accept-Encoding": "gzip"})
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	r, err = gzip.NewReader(resp.Body)
+	assert.Nil(t, err)
+	data, _ = ioutil.ReadAll(r)
+	assert.Contains(t, string(data), `"name":"a"`)
+}
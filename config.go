@@ -6,12 +6,15 @@
 package turbo
 
 import (
+	"net"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
 )
 
@@ -26,9 +29,25 @@ const (
 	filterProtoJson               = "filter_proto_json"
 	filterProtoJsonEmitZeroValues = "filter_proto_json_emit_zerovalues"
 	filterProtoJsonInt64AsNumber  = "filter_proto_json_int64_as_number"
+	jsonFieldNaming               = "json_field_naming"
+	jsonEnumStyle                 = "json_enum_style"
+	jsonInt64Style                = "json_int64_style"
+	jsonEmitZeroValues            = "json_emit_zerovalues"
+	forwardFieldMask              = "forward_field_mask"
+	compressionEnabled            = "compression_enabled"
+	compressionEncodings          = "compression_encodings"
+	compressionMinSize            = "compression_min_size"
+	compressionContentTypes       = "compression_content_types"
+	maxRequestBodySize            = "max_request_body_size"
+	trustedProxies                = "trusted_proxies"
 	turboLogPath                  = "turbo_log_path"
 	environment                   = "environment"
 	serviceRootPath               = "service_root_path"
+	openAPIPathKey                = "openapi_path"
+	adminEnabled                  = "admin_enabled"
+	adminPrincipals               = "admin_principals"
+	routeCheck                    = "route_check"
+	grpcDescriptorSource          = "grpc_descriptor_source"
 
 	urlServiceMaps = "urlServiceMaps"
 	interceptors   = "interceptors"
@@ -56,6 +75,37 @@ type Config struct {
 	configs       map[string]string
 	fieldMappings map[string][]string
 	mappings      map[string][][3]string
+	// genDir is where files written by protoc-gen-buildfields are read from, "[service_root_path]/gen" if it's empty
+	genDir string
+	// httpRules holds options of routes generated from google.api.http annotations,
+	// the key is "[HTTP method] [url]", e.g. "GET /v1/users/{id}"
+	httpRules map[string]httpRule
+	// routeIssues holds problems found in routes and component mappings, see "route_check"
+	routeIssues []string
+	// cors is the "cors" section, see corsPolicy
+	cors *corsConfig
+	// rateLimits matches requests to items in "ratelimit" section, see rateLimitConfig
+	rateLimits *mux.Router
+	// jwtRoutes matches requests to routes in "jwt" section, see jwtConfig
+	jwtRoutes *mux.Router
+	// auth holds the "auth" section and keys, see authConfig
+	auth *authenticator
+	// trustedProxies are "trusted_proxies" in config file, see ClientIP
+	trustedProxies []*net.IPNet
+	// acl matches requests to items in "acl" section, see aclConfig
+	acl *mux.Router
+	// cache holds the "cache" section and cached responses, see cacheConfig
+	cache *responseCache
+	// breakers are items in "circuitbreaker" section, see breakerConfig
+	breakers []*breakerPolicy
+	// retries matches requests to items in "retry" section, see retryConfig
+	retries *mux.Router
+}
+
+// httpRule holds the "body" and "response_body" options of a google.api.http annotation
+type httpRule struct {
+	body         string
+	responseBody string
 }
 
 // NewConfig loads the config file at 'configFilePath', and returns a Config struct ptr
@@ -77,9 +127,18 @@ func (c *Config) loadServiceConfig() {
 	c.SetConfigFile(c.File)
 	err := c.ReadInConfig()
 	panicIf(err)
-	c.loadUrlMap()
 	c.loadConfigs()
+	c.loadACL()
+	c.loadUrlMap()
+	c.loadCORS()
+	c.loadRateLimits()
+	c.loadJWT()
+	c.loadAuth()
+	c.loadCache()
+	c.loadBreakers()
+	c.loadRetries()
 	c.loadComponents()
+	c.checkRoutes()
 }
 
 func (c *Config) loadComponents() {
@@ -92,6 +151,50 @@ func (c *Config) loadComponents() {
 
 func (c *Config) loadUrlMap() {
 	c.mappings[urlServiceMaps] = c.loadMappings("urlmapping")
+	c.loadHTTPRules()
+}
+
+// loadHTTPRules loads routes generated from google.api.http annotations by protoc-gen-buildfields,
+// routes already defined in "urlmapping" are not overwritten.
+func (c *Config) loadHTTPRules() {
+	c.httpRules = make(map[string]httpRule)
+	if RpcType != "grpc" || len(strings.TrimSpace(c.ServiceRootPath())) == 0 {
+		return
+	}
+	routesFile := c.generatedDir() + "/grpcroutes.yaml"
+	if _, err := os.Stat(routesFile); err != nil {
+		return
+	}
+	v := viper.New()
+	v.SetConfigFile(routesFile)
+	panicIf(v.ReadInConfig())
+	existing := make(map[string]bool)
+	for _, m := range c.mappings[urlServiceMaps] {
+		for _, method := range strings.Split(m[0], ",") {
+			existing[method+" "+m[1]] = true
+		}
+	}
+	for _, line := range v.GetStringSlice("grpc-routes") {
+		values := strings.Fields(line)
+		if len(values) < 3 || existing[values[0]+" "+values[1]] {
+			continue
+		}
+		c.mappings[urlServiceMaps] = append(c.mappings[urlServiceMaps], [3]string{values[0], values[1], values[2]})
+		rule := httpRule{}
+		for _, option := range values[3:] {
+			pair := strings.SplitN(option, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			switch pair[0] {
+			case "body":
+				rule.body = pair[1]
+			case "response_body":
+				rule.responseBody = pair[1]
+			}
+		}
+		c.httpRules[values[0]+" "+values[1]] = rule
+	}
 }
 
 func (c *Config) loadMappings(key string) [][3]string {
@@ -130,13 +233,25 @@ func (c *Config) loadConfigs() {
 var matchKey = regexp.MustCompile("^(.*)\\[")
 var matchSlice = regexp.MustCompile("\\[(.+)\\]")
 
+// generatedDir returns the directory of files written by protoc-gen-buildfields, see genDir
+func (c *Config) generatedDir() string {
+	if len(c.genDir) > 0 {
+		return c.genDir
+	}
+	return c.ServiceRootPathAbsolute() + "/gen"
+}
+
 func (c *Config) loadFieldMapping() {
 	c.SetConfigName(RpcType + "fields")
-	c.AddConfigPath(c.ServiceRootPathAbsolute() + "/gen")
+	c.AddConfigPath(c.generatedDir())
 	err := c.ReadInConfig()
 	panicIf(err)
+	c.setFieldMappings(c.GetStringSlice(RpcType + "-fieldmapping"))
+}
+
+// setFieldMappings parses items like "CommonValues[HelloValues values,]"
+func (c *Config) setFieldMappings(mappings []string) {
 	c.fieldMappings = make(map[string][]string)
-	mappings := c.GetStringSlice(RpcType + "-fieldmapping")
 	for _, m := range mappings {
 		keyStr := matchKey.FindStringSubmatch(m)
 		key := m
@@ -174,7 +289,8 @@ func (c *Config) ServiceRootPath() string {
 
 // ServiceRootPathAbsolute returns the absolute path to service's root,
 // if "service_root_path" in config file is an absolute path, it's returned directly,
-// if "service_root_path" is a relative path, $GOPATH+"/src/"+[service_root_path] is returned.
+// if "service_root_path" is a package path, it's found in the go module containing the config file,
+// or $GOPATH+"/src/"+[service_root_path] is returned if it's not in that module.
 func (c *Config) ServiceRootPathAbsolute() string {
 	p := c.configs[serviceRootPath]
 	if len(strings.TrimSpace(p)) == 0 {
@@ -182,9 +298,8 @@ func (c *Config) ServiceRootPathAbsolute() string {
 	}
 	if path.IsAbs(p) {
 		return p
-	} else {
-		return GOPATH() + "/src/" + p
 	}
+	return packageDir(p, filepath.Dir(c.File))
 }
 
 func (c *Config) GrpcServiceName() string {
@@ -221,6 +336,16 @@ func (c *Config) HTTPPort() int64 {
 	return i
 }
 
+// MaxRequestBodySize returns "max_request_body_size" in config file, the max size in bytes of a request body
+// turbo reads into memory by itself, e.g. a decompressed body, default is 10MB.
+func (c *Config) MaxRequestBodySize() int64 {
+	size, err := strconv.ParseInt(strings.TrimSpace(c.configs[maxRequestBodySize]), 10, 64)
+	if err != nil || size <= 0 {
+		return 10 << 20
+	}
+	return size
+}
+
 func (c *Config) FilterProtoJson() bool {
 	option, ok := c.configs[filterProtoJson]
 	if !ok || option != "true" {
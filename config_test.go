@@ -11,7 +11,7 @@ func TestConfig(t *testing.T) {
 	c := NewConfig("grpc", "test/service_test.yaml")
 	assert.Equal(t, "production", c.Env())
 	assert.Equal(t, "grpc", RpcType)
-	assert.Equal(t, GOPATH()+"/src/"+"github.com/vaporz/turbo/test", c.ServiceRootPathAbsolute())
+	assert.Equal(t, PackageDir("github.com/vaporz/turbo/test"), c.ServiceRootPathAbsolute())
 
 	assert.Equal(t, int64(8081), c.HTTPPort())
 
@@ -50,6 +50,15 @@ func TestConfig(t *testing.T) {
 	c.configs[filterProtoJsonEmitZeroValues] = strconv.FormatBool(false)
 	assert.Equal(t, false, c.FilterProtoJsonEmitZeroValues())
 
+	assert.Equal(t, Marshaler{FilterProtoJson: true}, c.Marshaler())
+	c.configs[jsonEnumStyle] = "Names"
+	c.configs[jsonInt64Style] = "number"
+	assert.Equal(t, Marshaler{FilterProtoJson: true, Int64AsNumber: true, FieldNaming: "original", EnumStyle: "names"}, c.Marshaler())
+	c.configs[jsonFieldNaming] = "lowerCamel"
+	c.configs[jsonEmitZeroValues] = "true"
+	assert.Equal(t, "lower_camel", c.Marshaler().FieldNaming)
+	assert.True(t, c.Marshaler().EmitZeroValues)
+
 	assert.Equal(t, "GET,POST", c.mappings[urlServiceMaps][0][0])
 	assert.Equal(t, "/hello", c.mappings[urlServiceMaps][0][1])
 	assert.Equal(t, "SayHello", c.mappings[urlServiceMaps][0][2])
@@ -63,6 +72,13 @@ func TestConfig(t *testing.T) {
 	assert.Equal(t, "convertor", c.mappings[convertors][0][1])
 	assert.Equal(t, "error_handler", c.ErrorHandler())
 
+	assert.Equal(t, 5, len(c.mappings[urlServiceMaps]))
+	assert.Equal(t, [3]string{"POST", "/v1/rules/{id}", "UpdateRule"}, c.mappings[urlServiceMaps][3])
+	assert.Equal(t, httpRule{body: "child", responseBody: "child"}, c.httpRules["POST /v1/rules/{id}"])
+	assert.Equal(t, httpRule{body: "*"}, c.httpRules["PUT /v1/rules/{id}"])
+	_, ok := c.httpRules["GET /hello"]
+	assert.False(t, ok)
+
 	c.loadFieldMapping()
 	assert.Equal(t, "CommonValues values", c.fieldMappings["SayHelloRequest"][0])
 }
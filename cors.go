@@ -0,0 +1,286 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// corsPolicy is a "cors" section in config file, or a route in it, unset fields of a route follow the section, e.g.
+//
+//	cors:
+//	  allowed_origins: [https://example.com, "https://*.example.com"]
+//	  allowed_methods: [GET, POST]
+//	  allowed_headers: [Content-Type, X-Fields]
+//	  exposed_headers: [X-Request-Id]
+//	  allow_credentials: true
+//	  max_age: 600
+//	  routes:
+//	    - path: /v1/rules/{id}
+//	      allowed_origins: ["*"]
+//
+// allowed_methods are methods in "urlmapping" of the path by default,
+// allowed_headers are "Accept", "Content-Type" and "X-Requested-With" by default, "*" allows any header.
+type corsPolicy struct {
+	Path             string   `mapstructure:"path"`
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`
+	ExposedHeaders   []string `mapstructure:"exposed_headers"`
+	AllowCredentials *bool    `mapstructure:"allow_credentials"`
+	MaxAge           *int     `mapstructure:"max_age"`
+	// origins are patterns of AllowedOrigins, "*" in a pattern matches any characters except "/"
+	origins   []*regexp.Regexp
+	anyOrigin bool
+}
+
+type corsConfig struct {
+	corsPolicy `mapstructure:",squash"`
+	Routes     []corsPolicy `mapstructure:"routes"`
+}
+
+var defaultCORSHeaders = []string{"Accept", "Content-Type", "X-Requested-With"}
+
+// loadCORS loads the "cors" section, it's nil if there is no such section
+func (c *Config) loadCORS() {
+	c.cors = nil
+	if !c.IsSet("cors") {
+		return
+	}
+	cors := &corsConfig{}
+	panicIf(c.UnmarshalKey("cors", cors))
+	cors.compile()
+	for i := range cors.Routes {
+		cors.Routes[i].compile()
+	}
+	c.cors = cors
+}
+
+func (p *corsPolicy) compile() {
+	p.origins = p.origins[:0]
+	for _, origin := range p.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		pattern := strings.Replace(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[^/]*`, -1)
+		p.origins = append(p.origins, regexp.MustCompile("^"+pattern+"$"))
+	}
+}
+
+// corsPolicyOf returns the policy of a path in "urlmapping", the route policy is merged into the section
+func (c *Config) corsPolicyOf(path string) (*corsPolicy, bool) {
+	if c.cors == nil {
+		return nil, false
+	}
+	p := c.cors.corsPolicy
+	for _, route := range c.cors.Routes {
+		if route.Path != path {
+			continue
+		}
+		if route.AllowedOrigins != nil {
+			p.AllowedOrigins, p.origins, p.anyOrigin = route.AllowedOrigins, route.origins, route.anyOrigin
+		}
+		if route.AllowedMethods != nil {
+			p.AllowedMethods = route.AllowedMethods
+		}
+		if route.AllowedHeaders != nil {
+			p.AllowedHeaders = route.AllowedHeaders
+		}
+		if route.ExposedHeaders != nil {
+			p.ExposedHeaders = route.ExposedHeaders
+		}
+		if route.AllowCredentials != nil {
+			p.AllowCredentials = route.AllowCredentials
+		}
+		if route.MaxAge != nil {
+			p.MaxAge = route.MaxAge
+		}
+		break
+	}
+	if len(p.AllowedOrigins) == 0 {
+		return nil, false
+	}
+	p.Path = path
+	if p.AllowedMethods == nil {
+		p.AllowedMethods = c.methodsOf(path)
+	}
+	if p.AllowedHeaders == nil {
+		p.AllowedHeaders = defaultCORSHeaders
+	}
+	return &p, true
+}
+
+// methodsOf returns HTTP methods of a path in "urlmapping"
+func (c *Config) methodsOf(path string) []string {
+	methods := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range c.mappings[urlServiceMaps] {
+		if m[1] != path {
+			continue
+		}
+		for _, method := range strings.Split(m[0], ",") {
+			if method = strings.ToUpper(strings.TrimSpace(method)); !seen[method] {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range p.origins {
+		if o.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *corsPolicy) credentials() bool {
+	return p.AllowCredentials != nil && *p.AllowCredentials
+}
+
+// setOriginHeaders sets "Access-Control-Allow-Origin" and "Access-Control-Allow-Credentials" if the origin is allowed,
+// "*" is not used with credentials, the origin is echoed instead.
+func (p *corsPolicy) setOriginHeaders(resp http.ResponseWriter, origin string) bool {
+	resp.Header().Add("Vary", "Origin")
+	if len(origin) == 0 || !p.allowOrigin(origin) {
+		return false
+	}
+	if p.anyOrigin && !p.credentials() {
+		resp.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		resp.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials() {
+		resp.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// corsPolicyOfRequest returns the policy of the route matched by req
+func corsPolicyOfRequest(s Servable, req *http.Request) (*corsPolicy, bool) {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return nil, false
+	}
+	path, err := route.GetPathTemplate()
+	if err != nil {
+		return nil, false
+	}
+	return s.ServerField().Config.corsPolicyOf(path)
+}
+
+// setCORSHeaders sets CORS headers of an actual (not preflight) request
+func setCORSHeaders(s Servable, resp http.ResponseWriter, req *http.Request) {
+	p, ok := corsPolicyOfRequest(s, req)
+	if !ok {
+		return
+	}
+	if p.setOriginHeaders(resp, req.Header.Get("Origin")) && len(p.ExposedHeaders) > 0 {
+		resp.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+	}
+}
+
+// preflightHandler answers OPTIONS requests of paths with a CORS policy,
+// CORS headers are set only if the origin, the method and the headers requested are all allowed.
+func preflightHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		p, ok := corsPolicyOfRequest(s, req)
+		if !ok {
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+		resp.Header().Set("Allow", strings.Join(append(append([]string{}, p.AllowedMethods...), "OPTIONS"), ", "))
+		resp.Header().Add("Vary", "Access-Control-Request-Method")
+		resp.Header().Add("Vary", "Access-Control-Request-Headers")
+		method := strings.ToUpper(strings.TrimSpace(req.Header.Get("Access-Control-Request-Method")))
+		headers := requestedHeaders(req)
+		if len(method) == 0 || !containsFold(p.AllowedMethods, method) || !p.allowHeaders(headers) {
+			resp.Header().Add("Vary", "Origin")
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !p.setOriginHeaders(resp, req.Header.Get("Origin")) {
+			resp.WriteHeader(http.StatusNoContent)
+			return
+		}
+		resp.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		if len(headers) > 0 {
+			if containsFold(p.AllowedHeaders, "*") {
+				resp.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			} else {
+				resp.Header().Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+			}
+		}
+		if p.MaxAge != nil && *p.MaxAge > 0 {
+			resp.Header().Set("Access-Control-Max-Age", strconv.Itoa(*p.MaxAge))
+		}
+		resp.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func requestedHeaders(req *http.Request) []string {
+	headers := make([]string, 0)
+	for _, h := range strings.Split(req.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.TrimSpace(h); len(h) > 0 {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+func (p *corsPolicy) allowHeaders(headers []string) bool {
+	if containsFold(p.AllowedHeaders, "*") {
+		return true
+	}
+	for _, h := range headers {
+		if !containsFold(p.AllowedHeaders, h) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsPaths returns paths in "urlmapping" which have a CORS policy, and no OPTIONS method mapped
+func (c *Config) corsPaths() []string {
+	paths := make([]string, 0)
+	if c.cors == nil {
+		return paths
+	}
+	seen := make(map[string]bool)
+	for _, m := range c.mappings[urlServiceMaps] {
+		if seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		if _, ok := c.corsPolicyOf(m[1]); ok && !containsFold(c.methodsOf(m[1]), "OPTIONS") {
+			paths = append(paths, m[1])
+		}
+	}
+	return paths
+}
@@ -0,0 +1,88 @@
+package turbo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testCORSConfig = map[string]interface{}{
+	"allowed_origins":   []string{"https://*.example.com"},
+	"exposed_headers":   []string{"X-Request-Id"},
+	"allow_credentials": true,
+	"max_age":           600,
+	"routes": []map[string]interface{}{
+		{"path": "/v1/rules/{id}", "allowed_origins": []string{"*"}, "allow_credentials": false, "allowed_headers": []string{"*"}},
+	},
+}
+
+func TestCORSPolicy(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"cors": testCORSConfig})
+	p, ok := s.Config.corsPolicyOf("/hello")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"GET", "POST"}, p.AllowedMethods)
+	assert.True(t, p.allowOrigin("https://a.b.example.com"))
+	assert.False(t, p.allowOrigin("https://example.com"))
+	assert.False(t, p.allowOrigin("https://a.example.com.evil.com"))
+	assert.True(t, p.credentials())
+	p, _ = s.Config.corsPolicyOf("/v1/rules/{id}")
+	assert.True(t, p.allowOrigin("http://any"))
+	assert.False(t, p.credentials())
+	assert.Equal(t, 600, *p.MaxAge)
+	assert.Contains(t, s.Config.corsPaths(), "/eat_apple/{num:[0-9]+}")
+
+	s.Config.Set("cors", map[string]interface{}{})
+	s.Config.loadCORS()
+	_, ok = s.Config.corsPolicyOf("/hello")
+	assert.False(t, ok)
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"cors": testCORSConfig})
+	preflight := func(url, origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", url, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		resp := httptest.NewRecorder()
+		router(s).ServeHTTP(resp, req)
+		return resp
+	}
+	resp := preflight("/hello", "https://a.example.com", "POST", "content-type")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "https://a.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST", resp.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Accept, Content-Type, X-Requested-With", resp.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", resp.Header().Get("Access-Control-Max-Age"))
+
+	resp = preflight("/hello", "https://evil.com", "POST", "")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	assert.Equal(t, "", resp.Header().Get("Access-Control-Allow-Origin"))
+	resp = preflight("/hello", "https://a.example.com", "DELETE", "")
+	assert.Equal(t, "", resp.Header().Get("Access-Control-Allow-Origin"))
+	resp = preflight("/hello", "https://a.example.com", "GET", "X-Secret")
+	assert.Equal(t, "", resp.Header().Get("Access-Control-Allow-Origin"))
+
+	resp = preflight("/v1/rules/12", "http://any", "PUT", "X-Secret")
+	assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Secret", resp.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "", resp.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCORSHeaders(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"cors": testCORSConfig})
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		return &testRuleRequest{Id: 12}, nil
+	}
+	req := httptest.NewRequest("GET", "/v1/rules/12", nil)
+	req.Header.Set("Origin", "https://a.example.com")
+	resp := httptest.NewRecorder()
+	router(s).ServeHTTP(resp, req)
+	assert.Equal(t, "*", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", resp.Header().Get("Access-Control-Expose-Headers"))
+	assert.Contains(t, resp.Header()["Vary"], "Origin")
+}
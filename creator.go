@@ -8,7 +8,10 @@ package turbo
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -26,9 +29,13 @@ func (c *Creator) CreateProject(serviceName string, force bool) {
 	if !force {
 		c.validateServiceRootPath(nil)
 	}
-	c.createRootFolder(GOPATH() + "/src/" + c.PkgPath)
-	c.createServiceYaml(GOPATH()+"/src/"+c.PkgPath, serviceName, "service")
-	c.c = NewConfig(c.RpcType, GOPATH()+"/src/"+c.PkgPath+"/service.yaml")
+	dir, newModule := c.projectDir()
+	c.createRootFolder(dir)
+	if newModule {
+		c.createGoMod(dir)
+	}
+	c.createServiceYaml(dir, serviceName, "service")
+	c.c = NewConfig(c.RpcType, dir+"/service.yaml")
 	if c.RpcType == "grpc" {
 		c.createGrpcProject(serviceName)
 	} else if c.RpcType == "thrift" {
@@ -43,7 +50,7 @@ func (c *Creator) validateServiceRootPath(in io.Reader) {
 	if len(strings.TrimSpace(c.PkgPath)) == 0 {
 		panic("pkgPath is blank")
 	}
-	p := GOPATH() + "/src/" + c.PkgPath
+	p, _ := c.projectDir()
 	_, err := os.Stat(p)
 	if os.IsNotExist(err) {
 		return
@@ -101,12 +108,34 @@ func (c *Creator) createThriftProject(serviceName string) {
 	g.c = NewConfig(g.RpcType, c.c.ServiceRootPathAbsolute()+"/"+g.ConfigFileName+".yaml")
 	g.Options = " -I " + c.c.ServiceRootPathAbsolute() + " "
 	g.GenerateThriftStub()
-	g.GenerateBuildThriftParameters()
+	g.GenerateThriftFields()
 	g.c.loadFieldMapping()
 	g.GenerateThriftSwitcher()
 
 }
 
+// projectDir returns the directory to create the project in,
+// it's in the module containing the working directory if [PkgPath] belongs to that module,
+// or in $GOPATH/src when working in GOPATH, otherwise a new module is created in the working directory.
+func (c *Creator) projectDir() (dir string, newModule bool) {
+	wd, err := os.Getwd()
+	panicIf(err)
+	if d, ok := findModule(wd).dir(c.PkgPath); ok {
+		return d, false
+	}
+	if inGOPATH() {
+		return GOPATH() + "/src/" + c.PkgPath, false
+	}
+	return filepath.Join(wd, path.Base(c.PkgPath)), true
+}
+
+func (c *Creator) createGoMod(dir string) {
+	if _, err := os.Stat(dir + "/go.mod"); err == nil {
+		return
+	}
+	panicIf(ioutil.WriteFile(dir+"/go.mod", []byte("module "+c.PkgPath+"\n"), 0644))
+}
+
 func (c *Creator) createRootFolder(serviceRootPath string) {
 	os.MkdirAll(serviceRootPath+"/gen", 0755)
 }
@@ -133,7 +162,7 @@ func (c *Creator) createServiceYaml(serviceRootPath, serviceName, configFileName
 	}
 	writeFileWithTemplate(
 		serviceRootPath+"/"+configFileName+".yaml",
-		serviceYamlValues{ServiceRoot: serviceRootPath, ServiceName: serviceName},
+		serviceYamlValues{ServiceRoot: c.PkgPath, ServiceName: serviceName},
 		`config:
   environment: development
   service_root_path: {{.ServiceRoot}}
@@ -0,0 +1,990 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/gorilla/mux"
+)
+
+// descriptorRegistry indexes messages, enums and services in a set of .proto file descriptors
+type descriptorRegistry struct {
+	// messages is keyed by the full name, e.g. ".proto.SayHelloRequest"
+	messages map[string]*messageType
+	enums    map[string]*descriptor.EnumDescriptorProto
+	// services is keyed by the full name without the leading dot, e.g. "proto.YourService"
+	services map[string]*descriptor.ServiceDescriptorProto
+}
+
+// messageType is a message descriptor with its full name
+type messageType struct {
+	name   string
+	desc   *descriptor.DescriptorProto
+	proto3 bool
+	fields map[int32]*descriptor.FieldDescriptorProto
+}
+
+func newDescriptorRegistry(files []*descriptor.FileDescriptorProto) *descriptorRegistry {
+	r := &descriptorRegistry{
+		messages: make(map[string]*messageType),
+		enums:    make(map[string]*descriptor.EnumDescriptorProto),
+		services: make(map[string]*descriptor.ServiceDescriptorProto),
+	}
+	for _, f := range files {
+		prefix := ""
+		if len(f.GetPackage()) > 0 {
+			prefix = f.GetPackage() + "."
+		}
+		r.addMessages("."+prefix, f.GetSyntax() == "proto3", f.MessageType, f.EnumType)
+		for _, service := range f.Service {
+			r.services[prefix+service.GetName()] = service
+		}
+	}
+	return r
+}
+
+func (r *descriptorRegistry) addMessages(prefix string, proto3 bool, messages []*descriptor.DescriptorProto,
+	enums []*descriptor.EnumDescriptorProto) {
+	for _, e := range enums {
+		r.enums[prefix+e.GetName()] = e
+	}
+	for _, m := range messages {
+		t := &messageType{name: prefix + m.GetName(), desc: m, proto3: proto3,
+			fields: make(map[int32]*descriptor.FieldDescriptorProto)}
+		for _, f := range m.Field {
+			t.fields[f.GetNumber()] = f
+		}
+		r.messages[t.name] = t
+		r.addMessages(t.name+".", proto3, m.NestedType, m.EnumType)
+	}
+}
+
+// method finds a rpc method by the name in "urlmapping",
+// 'serviceName' is either a full name like "proto.YourService", or a short name like "YourService".
+func (r *descriptorRegistry) method(serviceName, methodName string) (string, *messageType, *messageType, error) {
+	for fullName, service := range r.services {
+		if fullName != serviceName && !strings.HasSuffix(fullName, "."+serviceName) && fullName != "."+serviceName {
+			continue
+		}
+		for _, m := range service.Method {
+			if m.GetName() != methodName && camelCase(m.GetName()) != methodName {
+				continue
+			}
+			in, out := r.messages[m.GetInputType()], r.messages[m.GetOutputType()]
+			if in == nil || out == nil {
+				return "", nil, nil, errors.New("turbo: missing descriptors of method[" + methodName + "]")
+			}
+			return "/" + fullName + "/" + m.GetName(), in, out, nil
+		}
+	}
+	return "", nil, nil, errors.New("No such method[" + methodName + "]")
+}
+
+// dynamicMessage is a proto message built from descriptors,
+// it implements proto.Marshaler and proto.Unmarshaler, so it can be sent by grpc like generated messages.
+type dynamicMessage struct {
+	registry *descriptorRegistry
+	t        *messageType
+	// values is keyed by field number, repeated fields and maps hold []interface{}, map entries are *dynamicMessage
+	values map[int32]interface{}
+}
+
+func newDynamicMessage(registry *descriptorRegistry, t *messageType) *dynamicMessage {
+	return &dynamicMessage{registry: registry, t: t, values: make(map[int32]interface{})}
+}
+
+func (m *dynamicMessage) Reset()         { m.values = make(map[int32]interface{}) }
+func (m *dynamicMessage) String() string { return m.t.name }
+func (m *dynamicMessage) ProtoMessage()  {}
+
+func (m *dynamicMessage) set(f *descriptor.FieldDescriptorProto, v interface{}) {
+	if f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		list, _ := m.values[f.GetNumber()].([]interface{})
+		m.values[f.GetNumber()] = append(list, v)
+		return
+	}
+	m.values[f.GetNumber()] = v
+}
+
+// Get returns the value of a field by its name in .proto
+func (m *dynamicMessage) Get(name string) (interface{}, bool) {
+	for _, f := range m.t.desc.Field {
+		if f.GetName() == name || f.GetJsonName() == name || camelCase(f.GetName()) == name {
+			v, ok := m.values[f.GetNumber()]
+			return v, ok
+		}
+	}
+	return nil, false
+}
+
+func (m *dynamicMessage) fieldByName(name string) *descriptor.FieldDescriptorProto {
+	for _, f := range m.t.desc.Field {
+		if f.GetName() == name || f.GetJsonName() == name || lowerCamelCase(f.GetName()) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (m *dynamicMessage) child(f *descriptor.FieldDescriptorProto) (*dynamicMessage, error) {
+	t, ok := m.registry.messages[f.GetTypeName()]
+	if !ok {
+		return nil, errors.New("turbo: unknown message type[" + f.GetTypeName() + "]")
+	}
+	return newDynamicMessage(m.registry, t), nil
+}
+
+func (m *dynamicMessage) isMapField(f *descriptor.FieldDescriptorProto) bool {
+	if f.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return false
+	}
+	t, ok := m.registry.messages[f.GetTypeName()]
+	return ok && t.desc.Options.GetMapEntry()
+}
+
+// Marshal encodes the message in protobuf wire format
+func (m *dynamicMessage) Marshal() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	numbers := make([]int, 0, len(m.values))
+	for n := range m.values {
+		numbers = append(numbers, int(n))
+	}
+	sort.Ints(numbers)
+	for _, n := range numbers {
+		f := m.t.fields[int32(n)]
+		v := m.values[int32(n)]
+		if f.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			if err := m.encodeField(buf, f, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		list, _ := v.([]interface{})
+		if m.packed(f) && len(list) > 0 {
+			packed := &bytes.Buffer{}
+			for _, item := range list {
+				if err := encodeScalar(packed, f.GetType(), item); err != nil {
+					return nil, err
+				}
+			}
+			buf.Write(proto.EncodeVarint(uint64(f.GetNumber())<<3 | proto.WireBytes))
+			buf.Write(proto.EncodeVarint(uint64(packed.Len())))
+			buf.Write(packed.Bytes())
+			continue
+		}
+		for _, item := range list {
+			if err := m.encodeField(buf, f, item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *dynamicMessage) packed(f *descriptor.FieldDescriptorProto) bool {
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	if f.Options != nil && f.Options.Packed != nil {
+		return f.Options.GetPacked()
+	}
+	return m.t.proto3
+}
+
+func (m *dynamicMessage) encodeField(buf *bytes.Buffer, f *descriptor.FieldDescriptorProto, v interface{}) error {
+	wireType := wireTypeOf(f.GetType())
+	buf.Write(proto.EncodeVarint(uint64(f.GetNumber())<<3 | wireType))
+	if f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		child, ok := v.(*dynamicMessage)
+		if !ok {
+			return errors.New("turbo: invalid value for message field[" + f.GetName() + "]")
+		}
+		data, err := child.Marshal()
+		if err != nil {
+			return err
+		}
+		buf.Write(proto.EncodeVarint(uint64(len(data))))
+		buf.Write(data)
+		return nil
+	}
+	return encodeScalar(buf, f.GetType(), v)
+}
+
+func wireTypeOf(t descriptor.FieldDescriptorProto_Type) uint64 {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE, descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return proto.WireFixed64
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT, descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return proto.WireFixed32
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		return proto.WireBytes
+	}
+	return proto.WireVarint
+}
+
+func encodeScalar(buf *bytes.Buffer, t descriptor.FieldDescriptorProto_Type, v interface{}) error {
+	var b [8]byte
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.(float64)))
+		buf.Write(b[:8])
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(v.(float32)))
+		buf.Write(b[:4])
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		binary.LittleEndian.PutUint64(b[:], v.(uint64))
+		buf.Write(b[:8])
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		binary.LittleEndian.PutUint64(b[:], uint64(v.(int64)))
+		buf.Write(b[:8])
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		binary.LittleEndian.PutUint32(b[:], v.(uint32))
+		buf.Write(b[:4])
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		binary.LittleEndian.PutUint32(b[:], uint32(v.(int32)))
+		buf.Write(b[:4])
+	case descriptor.FieldDescriptorProto_TYPE_INT64:
+		buf.Write(proto.EncodeVarint(uint64(v.(int64))))
+	case descriptor.FieldDescriptorProto_TYPE_UINT64:
+		buf.Write(proto.EncodeVarint(v.(uint64)))
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_ENUM:
+		buf.Write(proto.EncodeVarint(uint64(int64(v.(int32)))))
+	case descriptor.FieldDescriptorProto_TYPE_UINT32:
+		buf.Write(proto.EncodeVarint(uint64(v.(uint32))))
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		n := v.(int32)
+		buf.Write(proto.EncodeVarint(uint64(uint32((n << 1) ^ (n >> 31)))))
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		n := v.(int64)
+		buf.Write(proto.EncodeVarint(uint64((n << 1) ^ (n >> 63))))
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		if v.(bool) {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		s := v.(string)
+		buf.Write(proto.EncodeVarint(uint64(len(s))))
+		buf.WriteString(s)
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		data := v.([]byte)
+		buf.Write(proto.EncodeVarint(uint64(len(data))))
+		buf.Write(data)
+	default:
+		return errors.New("turbo: not supported field type[" + t.String() + "]")
+	}
+	return nil
+}
+
+var errTruncated = errors.New("turbo: truncated protobuf message")
+
+// Unmarshal decodes protobuf wire format into the message, unknown fields are skipped
+func (m *dynamicMessage) Unmarshal(data []byte) error {
+	if m.values == nil {
+		m.Reset()
+	}
+	for len(data) > 0 {
+		key, n := proto.DecodeVarint(data)
+		if n == 0 {
+			return errTruncated
+		}
+		data = data[n:]
+		number, wireType := int32(key>>3), key&7
+		var raw []byte
+		var x uint64
+		switch wireType {
+		case proto.WireVarint:
+			if x, n = proto.DecodeVarint(data); n == 0 {
+				return errTruncated
+			}
+		case proto.WireFixed64:
+			if n = 8; len(data) < n {
+				return errTruncated
+			}
+			x = binary.LittleEndian.Uint64(data)
+		case proto.WireFixed32:
+			if n = 4; len(data) < n {
+				return errTruncated
+			}
+			x = uint64(binary.LittleEndian.Uint32(data))
+		case proto.WireBytes:
+			length, l := proto.DecodeVarint(data)
+			if l == 0 || uint64(len(data)-l) < length {
+				return errTruncated
+			}
+			raw = data[l : l+int(length)]
+			n = l + int(length)
+		default:
+			return errors.New("turbo: not supported wire type " + strconv.FormatUint(wireType, 10))
+		}
+		data = data[n:]
+		f, ok := m.t.fields[number]
+		if !ok {
+			continue
+		}
+		if err := m.decodeField(f, wireType, x, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *dynamicMessage) decodeField(f *descriptor.FieldDescriptorProto, wireType, x uint64, raw []byte) error {
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		child, err := m.child(f)
+		if err != nil {
+			return err
+		}
+		if old, ok := m.values[f.GetNumber()].(*dynamicMessage); ok && f.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			child = old
+		}
+		if err = child.Unmarshal(raw); err != nil {
+			return err
+		}
+		m.set(f, child)
+		return nil
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		m.set(f, string(raw))
+		return nil
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		m.set(f, append([]byte{}, raw...))
+		return nil
+	}
+	if wireType != proto.WireBytes {
+		m.set(f, decodeScalar(f.GetType(), x))
+		return nil
+	}
+	// packed repeated scalars
+	fixedSize := map[uint64]int{proto.WireFixed64: 8, proto.WireFixed32: 4}[wireTypeOf(f.GetType())]
+	for len(raw) > 0 {
+		var v uint64
+		n := fixedSize
+		switch n {
+		case 8:
+			if len(raw) < 8 {
+				return errTruncated
+			}
+			v = binary.LittleEndian.Uint64(raw)
+		case 4:
+			if len(raw) < 4 {
+				return errTruncated
+			}
+			v = uint64(binary.LittleEndian.Uint32(raw))
+		default:
+			if v, n = proto.DecodeVarint(raw); n == 0 {
+				return errTruncated
+			}
+		}
+		raw = raw[n:]
+		m.set(f, decodeScalar(f.GetType(), v))
+	}
+	return nil
+}
+
+func decodeScalar(t descriptor.FieldDescriptorProto_Type, x uint64) interface{} {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return math.Float64frombits(x)
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return math.Float32frombits(uint32(x))
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return int64(x)
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return x
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return int32(x)
+	case descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return uint32(x)
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return int32(uint32(x)>>1) ^ -int32(x&1)
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return int64(x>>1) ^ -int64(x&1)
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return x != 0
+	}
+	return x
+}
+
+// parseScalar parses a string from form values or json into the go type of a field
+func (m *dynamicMessage) parseScalar(f *descriptor.FieldDescriptorProto, v string) (interface{}, error) {
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return v, nil
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return []byte(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return strconv.ParseBool(v)
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return strconv.ParseFloat(v, 64)
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		x, err := strconv.ParseFloat(v, 32)
+		return float32(x), err
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return strconv.ParseInt(v, 10, 64)
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return strconv.ParseUint(v, 10, 64)
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SINT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		x, err := strconv.ParseInt(v, 10, 32)
+		return int32(x), err
+	case descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		x, err := strconv.ParseUint(v, 10, 32)
+		return uint32(x), err
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if e, ok := m.registry.enums[f.GetTypeName()]; ok {
+			for _, value := range e.Value {
+				if value.GetName() == v {
+					return value.GetNumber(), nil
+				}
+			}
+		}
+		x, err := strconv.ParseInt(v, 10, 32)
+		return int32(x), err
+	}
+	return nil, errors.New("turbo: not supported field type[" + f.GetType().String() + "]")
+}
+
+// buildDynamicRequest builds a request message the same way BuildRequest does for generated messages
+func buildDynamicRequest(s Servable, m *dynamicMessage, req *http.Request) error {
+	if rule, ok := httpRuleOf(s, req); ok {
+		if err := m.build(req, rule.body); err != nil {
+			return err
+		}
+		return m.setFieldPaths(req)
+	}
+	if contentTypes, ok := req.Header["Content-Type"]; ok && contentTypes[0] == "application/json" {
+		return m.build(req, "*")
+	}
+	return m.build(req, "")
+}
+
+// build builds a message from form values and the request body, which is mapped to the field named 'body',
+// or to the whole message if it's "*", there is no request body if 'body' is empty.
+func (m *dynamicMessage) build(req *http.Request, body string) error {
+	if body != "*" {
+		if err := m.buildFromForm(req, 0); err != nil {
+			return err
+		}
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) > 0 {
+		target := m
+		if body != "*" {
+			f := m.fieldByName(body)
+			if f == nil || f.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+				return errors.New("turbo: invalid body field[" + body + "]")
+			}
+			if target, err = m.child(f); err != nil {
+				return err
+			}
+			m.values[f.GetNumber()] = target
+		}
+		if err = target.UnmarshalJSON(data); err != nil {
+			return errors.New(fmt.Sprintf("turbo: failed to BuildRequest for json api, "+
+				"request body: %s, error: %s", data, err))
+		}
+	}
+	if body == "*" {
+		return m.setPathParams(pathParams(req), 0)
+	}
+	if target, ok := m.values[m.fieldByName(body).GetNumber()].(*dynamicMessage); ok {
+		return target.setPathParams(boundValues(req), 0)
+	}
+	return nil
+}
+
+// buildFromForm finds values from request with findValue(), nested messages are flattened like BuildStruct does
+func (m *dynamicMessage) buildFromForm(req *http.Request, depth int) error {
+	for _, f := range m.t.desc.Field {
+		if f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			if f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED || depth > 8 {
+				continue
+			}
+			child, err := m.child(f)
+			if err != nil {
+				return err
+			}
+			if err = child.buildFromForm(req, depth+1); err != nil {
+				return err
+			}
+			if len(child.values) > 0 {
+				m.values[f.GetNumber()] = child
+			}
+			continue
+		}
+		v, ok := findValue(camelCase(f.GetName()), req)
+		if !ok {
+			continue
+		}
+		if err := m.setString(f, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFieldPaths sets path params named by field paths, like "{user.id}" of google.api.http path templates,
+// to the nested fields they name, the same way setFieldPaths does for generated messages.
+func (m *dynamicMessage) setFieldPaths(req *http.Request) error {
+	bound := boundValues(req)
+	for name, v := range mux.Vars(req) {
+		fieldPath := strings.Split(name, ".")
+		if _, ok := bound[strings.ToLower(fieldPath[len(fieldPath)-1])]; ok || len(fieldPath) == 1 {
+			continue
+		}
+		target := m
+		for i, fieldName := range fieldPath {
+			f := target.fieldByName(fieldName)
+			if f == nil || f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+				break
+			}
+			if i == len(fieldPath)-1 {
+				delete(target.values, f.GetNumber())
+				if err := target.setString(f, v); err != nil {
+					return err
+				}
+				break
+			}
+			if f.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+				break
+			}
+			child, ok := target.values[f.GetNumber()].(*dynamicMessage)
+			if !ok {
+				var err error
+				if child, err = target.child(f); err != nil {
+					return err
+				}
+				target.values[f.GetNumber()] = child
+			}
+			target = child
+		}
+	}
+	return nil
+}
+
+func (m *dynamicMessage) setPathParams(pathParams map[string]string, depth int) error {
+	for _, f := range m.t.desc.Field {
+		if f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			child, ok := m.values[f.GetNumber()].(*dynamicMessage)
+			if ok && depth < 8 {
+				if err := child.setPathParams(pathParams, depth+1); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		v, ok := findPathParamValue(camelCase(f.GetName()), pathParams)
+		if !ok {
+			continue
+		}
+		delete(m.values, f.GetNumber())
+		if err := m.setString(f, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setString sets a value from a form value, values of repeated fields are comma separated
+func (m *dynamicMessage) setString(f *descriptor.FieldDescriptorProto, v string) error {
+	if f.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		x, err := m.parseScalar(f, v)
+		if err != nil {
+			return err
+		}
+		m.values[f.GetNumber()] = x
+		return nil
+	}
+	list := make([]interface{}, 0)
+	if len(v) > 0 {
+		for _, item := range strings.Split(v, ",") {
+			x, err := m.parseScalar(f, item)
+			if err != nil {
+				return err
+			}
+			list = append(list, x)
+		}
+	}
+	m.values[f.GetNumber()] = list
+	return nil
+}
+
+// UnmarshalJSON parses json like jsonpb.Unmarshaler with AllowUnknownFields
+func (m *dynamicMessage) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil {
+		return err
+	}
+	return m.fromJSON(obj)
+}
+
+func (m *dynamicMessage) fromJSON(obj map[string]interface{}) error {
+	for key, value := range obj {
+		f := m.fieldByName(key)
+		if f == nil || value == nil {
+			continue
+		}
+		if m.isMapField(f) {
+			entries, ok := value.(map[string]interface{})
+			if !ok {
+				return errors.New("turbo: invalid value for map field[" + key + "]")
+			}
+			list := make([]interface{}, 0, len(entries))
+			for k, v := range entries {
+				entry, err := m.child(f)
+				if err != nil {
+					return err
+				}
+				keyField, valueField := entry.t.fields[1], entry.t.fields[2]
+				if keyField == nil || valueField == nil {
+					continue
+				}
+				if err = entry.setJSONValue(keyField, k); err != nil {
+					return err
+				}
+				if err = entry.setJSONValue(valueField, v); err != nil {
+					return err
+				}
+				list = append(list, entry)
+			}
+			m.values[f.GetNumber()] = list
+			continue
+		}
+		if f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			items, ok := value.([]interface{})
+			if !ok {
+				return errors.New("turbo: invalid value for repeated field[" + key + "]")
+			}
+			delete(m.values, f.GetNumber())
+			m.values[f.GetNumber()] = make([]interface{}, 0, len(items))
+			for _, item := range items {
+				if err := m.setJSONValue(f, item); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := m.setJSONValue(f, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *dynamicMessage) setJSONValue(f *descriptor.FieldDescriptorProto, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if f.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			return errors.New("turbo: invalid value for field[" + f.GetName() + "]")
+		}
+		child, err := m.child(f)
+		if err != nil {
+			return err
+		}
+		if err = child.fromJSON(v); err != nil {
+			return err
+		}
+		m.set(f, child)
+		return nil
+	case json.Number:
+		x, err := m.parseScalar(f, v.String())
+		if err != nil {
+			return err
+		}
+		m.set(f, x)
+		return nil
+	case bool:
+		m.set(f, v)
+		return nil
+	case string:
+		if f.GetType() == descriptor.FieldDescriptorProto_TYPE_BYTES {
+			data, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return err
+			}
+			m.set(f, data)
+			return nil
+		}
+		x, err := m.parseScalar(f, v)
+		if err != nil {
+			return err
+		}
+		m.set(f, x)
+		return nil
+	}
+	return errors.New("turbo: invalid value for field[" + f.GetName() + "]")
+}
+
+// dynamicJSON is the response of DynamicGrpcSwitcher,
+// it's marshaled with the same rules as Marshaler marshals generated messages.
+type dynamicJSON struct {
+	message   *dynamicMessage
+	marshaler Marshaler
+	// field is the "response_body" field, the whole message is marshaled if it's nil
+	field *descriptor.FieldDescriptorProto
+}
+
+// Message returns the response message
+func (d *dynamicJSON) Message() proto.Message {
+	return d.message
+}
+
+// responseBody returns a dynamicJSON which marshals only the field 'name'
+func (d *dynamicJSON) responseBody(name string) interface{} {
+	f := d.message.fieldByName(name)
+	if f == nil {
+		return d
+	}
+	return &dynamicJSON{message: d.message, marshaler: d.marshaler, field: f}
+}
+
+// MarshalJSON implements json.Marshaler
+func (d *dynamicJSON) MarshalJSON() ([]byte, error) {
+	if d.field == nil {
+		return json.Marshal(d.object(d.message))
+	}
+	v, ok := d.message.values[d.field.GetNumber()]
+	if !ok {
+		if d.field.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE && !d.message.isMapField(d.field) &&
+			d.field.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			return []byte("{}"), nil
+		}
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.value(d.message, d.field, v))
+}
+
+// jsonObject keeps the field order of jsonpb, keys are sorted if FilterProtoJson or FieldNaming is set,
+// like Marshaler.JSON does
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	buf := bytes.NewBufferString("{")
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(o.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// filter returns true if Marshaler.JSON writes generated messages by encodeProto
+func (d *dynamicJSON) filter() bool {
+	return d.marshaler.FilterProtoJson || d.marshaler.styled()
+}
+
+// key returns the name of a field in json, see Marshaler.FieldNaming
+func (d *dynamicJSON) key(f *descriptor.FieldDescriptorProto) string {
+	switch d.marshaler.naming() {
+	case namingLowerCamel:
+		if len(f.GetJsonName()) > 0 {
+			return f.GetJsonName()
+		}
+		return lowerCamelCase(f.GetName())
+	case namingGoName:
+		return camelCase(f.GetName())
+	}
+	return f.GetName()
+}
+
+func (d *dynamicJSON) object(m *dynamicMessage) *jsonObject {
+	o := &jsonObject{values: make(map[string]interface{})}
+	filter := d.filter()
+	for _, f := range m.t.desc.Field {
+		key := d.key(f)
+		v, ok := m.values[f.GetNumber()]
+		if ok && m.t.proto3 && isZeroProto3(f, v) {
+			ok = false
+		}
+		switch {
+		case ok:
+			o.values[key] = d.value(m, f, v)
+		case !filter || m.isMapField(f):
+			continue
+		case f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED:
+			if !d.marshaler.EmitZeroValues {
+				continue
+			}
+			o.values[key] = []interface{}{}
+		case f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+			if d.marshaler.EmitZeroValues {
+				o.values[key] = nil
+			} else {
+				o.values[key] = &jsonObject{values: map[string]interface{}{}}
+			}
+		default:
+			if !d.marshaler.EmitZeroValues {
+				continue
+			}
+			o.values[key] = d.scalar(m, f, zeroValue(f.GetType()), false)
+		}
+		o.keys = append(o.keys, key)
+	}
+	if filter {
+		sort.Strings(o.keys)
+	}
+	return o
+}
+
+func (d *dynamicJSON) value(m *dynamicMessage, f *descriptor.FieldDescriptorProto, v interface{}) interface{} {
+	if m.isMapField(f) {
+		entries := &jsonObject{values: make(map[string]interface{})}
+		list, _ := v.([]interface{})
+		for _, item := range list {
+			entry, ok := item.(*dynamicMessage)
+			if !ok {
+				continue
+			}
+			key := fmt.Sprint(entry.values[1])
+			if _, exists := entries.values[key]; !exists {
+				entries.keys = append(entries.keys, key)
+			}
+			if value, ok := entry.values[2]; ok {
+				entries.values[key] = d.value(entry, entry.t.fields[2], value)
+			} else {
+				entries.values[key] = d.scalar(entry, entry.t.fields[2], zeroValue(entry.t.fields[2].GetType()), false)
+			}
+		}
+		sort.Strings(entries.keys)
+		return entries
+	}
+	if f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		list, _ := v.([]interface{})
+		values := make([]interface{}, 0, len(list))
+		for _, item := range list {
+			values = append(values, d.single(m, f, item, true))
+		}
+		return values
+	}
+	return d.single(m, f, v, false)
+}
+
+func (d *dynamicJSON) single(m *dynamicMessage, f *descriptor.FieldDescriptorProto, v interface{}, inList bool) interface{} {
+	if child, ok := v.(*dynamicMessage); ok {
+		return d.object(child)
+	}
+	return d.scalar(m, f, v, inList)
+}
+
+// scalar returns the json value of a scalar field,
+// jsonpb writes 64-bit integers as strings and enums as names,
+// Marshaler.JSON changes single (not repeated) integer and enum fields into numbers, and int64 lists if Int64AsNumber,
+// see Marshaler.enumAsName() and Marshaler.uint64AsNumber().
+func (d *dynamicJSON) scalar(m *dynamicMessage, f *descriptor.FieldDescriptorProto, v interface{}, inList bool) interface{} {
+	filter := d.filter()
+	switch x := v.(type) {
+	case int64:
+		if filter && d.marshaler.Int64AsNumber {
+			return x
+		}
+		return strconv.FormatInt(x, 10)
+	case uint64:
+		if filter && d.marshaler.uint64AsNumber(inList) {
+			return x
+		}
+		return strconv.FormatUint(x, 10)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case float32:
+		return jsonFloat(float64(x))
+	case float64:
+		return jsonFloat(x)
+	case int32:
+		if f.GetType() != descriptor.FieldDescriptorProto_TYPE_ENUM || (filter && !d.marshaler.enumAsName(inList)) {
+			return x
+		}
+		if e, ok := m.registry.enums[f.GetTypeName()]; ok {
+			for _, value := range e.Value {
+				if value.GetNumber() == x {
+					return value.GetName()
+				}
+			}
+		}
+		return x
+	}
+	return v
+}
+
+// jsonFloat writes NaN and Infinity as strings, like jsonpb does
+func jsonFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+func zeroValue(t descriptor.FieldDescriptorProto_Type) interface{} {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return ""
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return []byte{}
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return false
+	}
+	return decodeScalar(t, 0)
+}
+
+// isZeroProto3 returns true if jsonpb omits the value of a proto3 field
+func isZeroProto3(f *descriptor.FieldDescriptorProto, v interface{}) bool {
+	switch x := v.(type) {
+	case []interface{}:
+		return len(x) == 0
+	case *dynamicMessage:
+		return false
+	case []byte:
+		return len(x) == 0
+	}
+	return v == zeroValue(f.GetType())
+}
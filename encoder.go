@@ -0,0 +1,616 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// Encoder encodes a response into a media type,
+// 'data' is the json encoding of 'response' written by Marshaler.JSON,
+// so encoders built on it follow the same "json_*" options as json responses do.
+type Encoder func(response interface{}, data []byte) ([]byte, error)
+
+const defaultMediaType = "application/json"
+
+// builtinEncoders are encoders keyed by media type, which can be replaced by Components.SetEncoder
+var builtinEncoders = map[string]Encoder{
+	"application/json":    jsonEncoder,
+	"application/xml":     xmlEncoder,
+	"application/yaml":    yamlEncoder,
+	"application/msgpack": msgpackEncoder,
+	"text/csv":            csvEncoder,
+}
+
+// builtinFormats map values of the "format" query param to media types
+var builtinFormats = map[string]string{
+	"json":    "application/json",
+	"xml":     "application/xml",
+	"yaml":    "application/yaml",
+	"yml":     "application/yaml",
+	"msgpack": "application/msgpack",
+	"csv":     "text/csv",
+}
+
+// mediaTypeAliases are other names of built-in media types
+var mediaTypeAliases = map[string]string{
+	"text/json":                "application/json",
+	"text/xml":                 "application/xml",
+	"application/x-yaml":       "application/yaml",
+	"text/yaml":                "application/yaml",
+	"text/x-yaml":              "application/yaml",
+	"application/x-msgpack":    "application/msgpack",
+	"application/vnd.msgpack":  "application/msgpack",
+	"application/vnd.ms-excel": "text/csv",
+}
+
+func (c *Components) setEncoder(mediaType string, e Encoder, formats []string) {
+	if c.encoders == nil {
+		c.encoders = make(map[string]Encoder)
+	}
+	if c.encoderFormats == nil {
+		c.encoderFormats = make(map[string]string)
+	}
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	c.encoders[mediaType] = e
+	for _, format := range formats {
+		c.encoderFormats[strings.ToLower(format)] = mediaType
+	}
+}
+
+func (c *Components) encoder(mediaType string) Encoder {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if e, ok := c.encoders[mediaType]; ok {
+		return e
+	}
+	if alias, ok := mediaTypeAliases[mediaType]; ok {
+		mediaType = alias
+		if e, ok := c.encoders[mediaType]; ok {
+			return e
+		}
+	}
+	return builtinEncoders[mediaType]
+}
+
+// encoderOf returns the media type and the Encoder of the response to a request,
+// it's chosen by the "format" query param if it's a known format, or by the "Accept" header,
+// it's json by default, unknown formats are ignored, because they might be values of request fields named "format".
+func (c *Components) encoderOf(req *http.Request) (string, Encoder) {
+	if format := strings.ToLower(req.URL.Query().Get("format")); len(format) > 0 {
+		mediaType, ok := c.encoderFormats[format]
+		if !ok {
+			mediaType, ok = builtinFormats[format]
+		}
+		if ok {
+			if e := c.encoder(mediaType); e != nil {
+				return mediaType, e
+			}
+		}
+	}
+	for _, mediaType := range acceptedMediaTypes(strings.Join(req.Header["Accept"], ",")) {
+		switch mediaType {
+		case "*/*", "application/*":
+			mediaType = defaultMediaType
+		}
+		if e := c.encoder(mediaType); e != nil {
+			return mediaType, e
+		}
+	}
+	return defaultMediaType, c.encoder(defaultMediaType)
+}
+
+// acceptedMediaTypes returns media types in an "Accept" header, sorted by quality, those with q=0 are skipped
+func acceptedMediaTypes(accept string) []string {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	ranges := make([]mediaRange, 0)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if len(r.mediaType) == 0 {
+			continue
+		}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		if r.q > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	mediaTypes := make([]string, len(ranges))
+	for i, r := range ranges {
+		mediaTypes[i] = r.mediaType
+	}
+	return mediaTypes
+}
+
+func jsonEncoder(response interface{}, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// encoderObject is a json object decoded with keys in order
+type encoderObject []encoderPair
+
+type encoderPair struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON implements json.Marshaler
+func (o encoderObject) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, p := range o {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, p.key)
+		b = append(b, ':')
+		value, err := json.Marshal(p.value)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, value...)
+	}
+	return append(b, '}'), nil
+}
+
+// decodeJSONTree decodes json into encoderObject, []interface{}, string, json.Number, bool or nil
+func decodeJSONTree(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return decodeJSONValue(d)
+}
+
+func decodeJSONValue(d *json.Decoder) (interface{}, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		o := encoderObject{}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONValue(d)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, encoderPair{key: key.(string), value: value})
+		}
+		_, err = d.Token()
+		return o, err
+	case json.Delim('['):
+		list := make([]interface{}, 0)
+		for d.More() {
+			value, err := decodeJSONValue(d)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = d.Token()
+		return list, err
+	}
+	return t, nil
+}
+
+// scalarText returns the text of a scalar in json tree, null is ""
+func scalarText(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	case nil:
+		return ""
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// xmlEncoder writes a response as <response>, fields are child elements, lists are repeated elements,
+// elements of a list in a list are <item>, keys which are not valid names are written as <entry key="...">.
+func xmlEncoder(response interface{}, data []byte) ([]byte, error) {
+	tree, err := decodeJSONTree(data)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBufferString(xml.Header)
+	writeXMLElement(buf, "response", tree)
+	return buf.Bytes(), nil
+}
+
+func writeXMLValue(buf *bytes.Buffer, name string, v interface{}) {
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			writeXMLElement(buf, name, item)
+		}
+		return
+	}
+	writeXMLElement(buf, name, v)
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, v interface{}) {
+	tag := name
+	buf.WriteByte('<')
+	if isXMLName(name) {
+		buf.WriteString(name)
+	} else {
+		tag = "entry"
+		buf.WriteString(`entry key="`)
+		xml.EscapeText(buf, []byte(name))
+		buf.WriteByte('"')
+	}
+	switch x := v.(type) {
+	case nil:
+		buf.WriteString("/>")
+		return
+	case encoderObject:
+		buf.WriteByte('>')
+		for _, p := range x {
+			writeXMLValue(buf, p.key, p.value)
+		}
+	case []interface{}:
+		buf.WriteByte('>')
+		writeXMLValue(buf, "item", x)
+	default:
+		buf.WriteByte('>')
+		xml.EscapeText(buf, []byte(scalarText(x)))
+	}
+	buf.WriteString("</" + tag + ">")
+}
+
+func isXMLName(name string) bool {
+	if len(name) == 0 || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// yamlEncoder writes a response in block style, strings are double-quoted
+func yamlEncoder(response interface{}, data []byte) ([]byte, error) {
+	tree, err := decodeJSONTree(data)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	switch x := tree.(type) {
+	case encoderObject:
+		if len(x) == 0 {
+			buf.WriteString("{}\n")
+		}
+		writeYAMLObject(buf, x, "")
+	case []interface{}:
+		if len(x) == 0 {
+			buf.WriteString("[]\n")
+		}
+		writeYAMLList(buf, x, "")
+	default:
+		buf.WriteString(yamlScalar(x) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func writeYAMLObject(buf *bytes.Buffer, o encoderObject, indent string) {
+	for _, p := range o {
+		buf.WriteString(indent + yamlKey(p.key) + ":")
+		writeYAMLEntry(buf, p.value, indent)
+	}
+}
+
+func writeYAMLList(buf *bytes.Buffer, list []interface{}, indent string) {
+	for _, item := range list {
+		buf.WriteString(indent + "-")
+		writeYAMLEntry(buf, item, indent)
+	}
+}
+
+// writeYAMLEntry writes the value after "key:" or "-"
+func writeYAMLEntry(buf *bytes.Buffer, v interface{}, indent string) {
+	switch x := v.(type) {
+	case encoderObject:
+		if len(x) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAMLObject(buf, x, indent+"  ")
+	case []interface{}:
+		if len(x) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteByte('\n')
+		writeYAMLList(buf, x, indent+"  ")
+	default:
+		buf.WriteString(" " + yamlScalar(x) + "\n")
+	}
+}
+
+var matchYAMLPlainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// yamlReserved are plain scalars which are not strings in yaml
+var yamlReserved = map[string]bool{"true": true, "false": true, "null": true, "yes": true, "no": true,
+	"on": true, "off": true, "y": true, "n": true}
+
+func yamlKey(key string) string {
+	if matchYAMLPlainKey.MatchString(key) && !yamlReserved[strings.ToLower(key)] {
+		return key
+	}
+	return string(appendJSONString(nil, key))
+}
+
+func yamlScalar(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return string(appendJSONString(nil, x))
+	}
+	return scalarText(v)
+}
+
+// msgpackEncoder writes a response in MessagePack, json numbers are integers if they are, or float64
+func msgpackEncoder(response interface{}, data []byte) ([]byte, error) {
+	tree, err := decodeJSONTree(data)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(make([]byte, 0, len(data)), tree), nil
+}
+
+func appendMsgpack(b []byte, v interface{}) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if x {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if i, err := strconv.ParseInt(x.String(), 10, 64); err == nil {
+			return appendMsgpackInt(b, i)
+		}
+		if u, err := strconv.ParseUint(x.String(), 10, 64); err == nil {
+			return appendBigEndian(append(b, 0xcf), u, 8)
+		}
+		f, _ := x.Float64()
+		return appendBigEndian(append(b, 0xcb), math.Float64bits(f), 8)
+	case string:
+		n := len(x)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n < 1<<8:
+			b = append(b, 0xd9, byte(n))
+		case n < 1<<16:
+			b = appendBigEndian(append(b, 0xda), uint64(n), 2)
+		default:
+			b = appendBigEndian(append(b, 0xdb), uint64(n), 4)
+		}
+		return append(b, x...)
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(x), 0x90, 0xdc)
+		for _, item := range x {
+			b = appendMsgpack(b, item)
+		}
+		return b
+	case encoderObject:
+		b = appendMsgpackHeader(b, len(x), 0x80, 0xde)
+		for _, p := range x {
+			b = appendMsgpack(b, p.key)
+			b = appendMsgpack(b, p.value)
+		}
+		return b
+	}
+	return append(b, 0xc0)
+}
+
+// appendMsgpackHeader writes the header of an array or a map, 'code16' is followed by the code of 32-bit length
+func appendMsgpackHeader(b []byte, n int, fix byte, code16 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n < 1<<16:
+		return appendBigEndian(append(b, code16), uint64(n), 2)
+	}
+	return appendBigEndian(append(b, code16+1), uint64(n), 4)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i >= 0 && i < 1<<8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i < 1<<16:
+		return appendBigEndian(append(b, 0xcd), uint64(i), 2)
+	case i >= 0 && i < 1<<32:
+		return appendBigEndian(append(b, 0xce), uint64(i), 4)
+	case i >= 0:
+		return appendBigEndian(append(b, 0xcf), uint64(i), 8)
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= -1<<7:
+		return append(b, 0xd0, byte(i))
+	case i >= -1<<15:
+		return appendBigEndian(append(b, 0xd1), uint64(i), 2)
+	case i >= -1<<31:
+		return appendBigEndian(append(b, 0xd2), uint64(i), 4)
+	}
+	return appendBigEndian(append(b, 0xd3), uint64(i), 8)
+}
+
+func appendBigEndian(b []byte, v uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(uint(i)*8)))
+	}
+	return b
+}
+
+// csvEncoder writes the first non-empty list of messages in a response as a table, with a header row,
+// nested messages are flattened into columns like "child.title", lists in rows are written as json.
+// Responses which are lists of messages are written as they are.
+func csvEncoder(response interface{}, data []byte) ([]byte, error) {
+	tree, err := decodeJSONTree(data)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := csvRows(tree)
+	if !ok {
+		if isListResponse(response) {
+			return []byte{}, nil
+		}
+		return nil, errors.New("turbo: csv is supported by responses with a repeated message field only")
+	}
+	columns := make([]string, 0)
+	seen := make(map[string]bool)
+	records := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		records[i] = make(map[string]string)
+		flattenCSVRow(records[i], "", row, func(column string) {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		})
+	}
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write(columns)
+	for _, record := range records {
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			values[i] = record[column]
+		}
+		w.Write(values)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvRows returns the first non-empty list of objects in a json tree
+func csvRows(tree interface{}) ([]encoderObject, bool) {
+	if list, ok := tree.([]interface{}); ok {
+		return objectList(list)
+	}
+	if o, ok := tree.(encoderObject); ok {
+		for _, p := range o {
+			if list, ok := p.value.([]interface{}); ok {
+				if rows, ok := objectList(list); ok {
+					return rows, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+func objectList(list []interface{}) ([]encoderObject, bool) {
+	if len(list) == 0 {
+		return nil, false
+	}
+	rows := make([]encoderObject, 0, len(list))
+	for _, item := range list {
+		switch x := item.(type) {
+		case encoderObject:
+			rows = append(rows, x)
+		case nil:
+			rows = append(rows, encoderObject{})
+		default:
+			return nil, false
+		}
+	}
+	return rows, true
+}
+
+func flattenCSVRow(record map[string]string, prefix string, o encoderObject, addColumn func(string)) {
+	for _, p := range o {
+		column := prefix + p.key
+		if child, ok := p.value.(encoderObject); ok {
+			flattenCSVRow(record, column+".", child, addColumn)
+			continue
+		}
+		addColumn(column)
+		record[column] = scalarText(p.value)
+	}
+}
+
+// isListResponse returns true if a response is a list of messages, or has a repeated message field
+func isListResponse(response interface{}) bool {
+	if d, ok := response.(*dynamicJSON); ok {
+		fields := d.message.t.desc.Field
+		if d.field != nil {
+			fields = []*descriptor.FieldDescriptorProto{d.field}
+		}
+		for _, f := range fields {
+			if f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED &&
+				f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE && !d.message.isMapField(f) {
+				return true
+			}
+		}
+		return false
+	}
+	v := reflect.ValueOf(response)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		return isMessageType(v.Type().Elem())
+	}
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if t := v.Type().Field(i).Type; t.Kind() == reflect.Slice && isMessageType(t.Elem()) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMessageType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
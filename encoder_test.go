@@ -0,0 +1,114 @@
+package turbo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncoders(t *testing.T) {
+	v := &testEncoderMessage{Id: 1, Children: []*testRuleChild{{Title: "a"}, {Title: "<b>"}}}
+	m := Marshaler{FilterProtoJson: true}
+	data, _ := m.JSON(v)
+
+	b, err := xmlEncoder(v, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<response><child></child>"+
+		"<children><title>a</title></children><children><title>&lt;b&gt;</title></children><id>1</id></response>", string(b))
+	b, _ = xmlEncoder(nil, []byte(`[[1],{"a b":null}]`))
+	assert.Contains(t, string(b), `<response><item><item>1</item></item><item><entry key="a b"/></item></response>`)
+
+	b, err = yamlEncoder(v, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "child: {}\nchildren:\n  -\n    title: \"a\"\n  -\n    title: \"\\u003cb\\u003e\"\nid: \"1\"\n", string(b))
+	b, _ = yamlEncoder(nil, []byte(`{"true":[],"n":[1.5,false,null]}`))
+	assert.Equal(t, "\"true\": []\n\"n\":\n  - 1.5\n  - false\n  - null\n", string(b))
+
+	b, err = msgpackEncoder(nil, []byte(`{"a":1,"b":[true,null,-1,300,-200],"c":"x"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x83, 0xa1, 'a', 0x01, 0xa1, 'b', 0x95, 0xc3, 0xc0, 0xff, 0xcd, 0x01, 0x2c, 0xd1, 0xff, 0x38,
+		0xa1, 'c', 0xa1, 'x'}, b)
+	b, _ = msgpackEncoder(nil, []byte(`[1.5]`))
+	assert.Equal(t, []byte{0x91, 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}, b)
+
+	b, err = csvEncoder(v, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "title\na\n<b>\n", string(b))
+	b, err = csvEncoder(nil, []byte(`[{"id":1,"child":{"title":"x"}},{"id":2,"tags":["a","b"]}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, "id,child.title,tags\n1,x,\n2,,\"[\"\"a\"\",\"\"b\"\"]\"\n", string(b))
+	b, err = csvEncoder(&testEncoderMessage{}, []byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "", string(b))
+	_, err = csvEncoder(&testRuleChild{}, []byte(`{"title":"a"}`))
+	assert.NotNil(t, err)
+}
+
+func TestEncoderOf(t *testing.T) {
+	c := &Components{}
+	request := func(url, accept string) *http.Request {
+		req := httptest.NewRequest("GET", url, nil)
+		if len(accept) > 0 {
+			req.Header.Set("Accept", accept)
+		}
+		return req
+	}
+	mediaType, _ := c.encoderOf(request("/", ""))
+	assert.Equal(t, "application/json", mediaType)
+	mediaType, _ = c.encoderOf(request("/", "text/html, application/xml;q=0.9, */*;q=0.8"))
+	assert.Equal(t, "application/xml", mediaType)
+	mediaType, _ = c.encoderOf(request("/", "application/yaml;q=0, text/csv;q=0.5, application/x-msgpack"))
+	assert.Equal(t, "application/x-msgpack", mediaType)
+	mediaType, _ = c.encoderOf(request("/", "image/png"))
+	assert.Equal(t, "application/json", mediaType)
+	mediaType, _ = c.encoderOf(request("/?format=YML", "application/xml"))
+	assert.Equal(t, "application/yaml", mediaType)
+	// unknown formats may be values of request fields
+	mediaType, _ = c.encoderOf(request("/?format=pdf", "application/xml"))
+	assert.Equal(t, "application/xml", mediaType)
+
+	c.SetEncoder("application/pdf", func(response interface{}, data []byte) ([]byte, error) {
+		return nil, errors.New("pdf")
+	}, "pdf")
+	c.SetEncoder("application/xml", jsonEncoder)
+	mediaType, e := c.encoderOf(request("/?format=pdf", ""))
+	assert.Equal(t, "application/pdf", mediaType)
+	_, err := e(nil, nil)
+	assert.Equal(t, "pdf", err.Error())
+	b, _ := c.Encoder("text/xml")(nil, []byte(`{}`))
+	assert.Equal(t, "{}", string(b))
+	assert.Nil(t, c.Encoder("image/png"))
+}
+
+func TestEncodeResponse(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		return &testRuleRequest{Id: 12, Name: "turbo"}, nil
+	}
+	req := httptest.NewRequest("GET", "/v1/rules/12", nil)
+	req.Header.Set("Accept", "text/xml")
+	resp := httptest.NewRecorder()
+	router(s).ServeHTTP(resp, req)
+	assert.Equal(t, "text/xml", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", resp.Header().Get("Vary"))
+	assert.Contains(t, resp.Body.String(), "<response><child/><id>12</id><name>turbo</name></response>")
+
+	resp = serveTestRequest(s, "GET", "/v1/rules/12", "")
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `{"child":null,"id":12,"name":"turbo"}`, resp.Body.String())
+}
+
+func TestEncodersReloaded(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	s.Config.mappings = make(map[string][][3]string)
+	s.Config.Set("errorhandler", "")
+	s.Components.SetEncoder("application/pdf", jsonEncoder, "pdf")
+	c := s.loadComponents()
+	assert.NotNil(t, c.Encoder("application/pdf"))
+	mediaType, _ := c.encoderOf(httptest.NewRequest("GET", "/?format=pdf", nil))
+	assert.Equal(t, "application/pdf", mediaType)
+}
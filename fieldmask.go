@@ -0,0 +1,185 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+)
+
+// fieldMask is a tree of selected fields, a nil child selects the whole field
+type fieldMask map[string]fieldMask
+
+// parseFieldMask parses a comma separated list of field paths, e.g. "message,user.name,items.id",
+// a path selects a field in nested messages, or in each element of repeated messages.
+func parseFieldMask(s string) fieldMask {
+	mask := make(fieldMask)
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if len(path) == 0 {
+			continue
+		}
+		node := mask
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			name = strings.TrimSpace(name)
+			child, ok := node[name]
+			if ok && child == nil {
+				// the whole field is selected already
+				break
+			}
+			if i == len(names)-1 {
+				node[name] = nil
+				break
+			}
+			if !ok {
+				child = make(fieldMask)
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	return mask
+}
+
+// fieldMaskOf returns the field mask in the "fields" query param, or in "X-Fields" header
+func fieldMaskOf(req *http.Request) (fieldMask, bool) {
+	fields := req.URL.Query().Get("fields")
+	if len(fields) == 0 {
+		fields = req.Header.Get("X-Fields")
+	}
+	mask := parseFieldMask(fields)
+	return mask, len(mask) > 0
+}
+
+// pruneJSON removes fields not selected from json, field names are names in json, see Marshaler.FieldNaming
+func (fm fieldMask) pruneJSON(data []byte) ([]byte, error) {
+	tree, err := decodeJSONTree(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fm.prune(tree))
+}
+
+func (fm fieldMask) prune(v interface{}) interface{} {
+	switch x := v.(type) {
+	case encoderObject:
+		o := make(encoderObject, 0, len(fm))
+		for _, p := range x {
+			child, ok := fm[p.key]
+			if !ok {
+				continue
+			}
+			if child != nil {
+				p.value = child.prune(p.value)
+			}
+			o = append(o, p)
+		}
+		return o
+	case []interface{}:
+		list := make([]interface{}, len(x))
+		for i, item := range x {
+			list[i] = fm.prune(item)
+		}
+		return list
+	}
+	return v
+}
+
+// paths returns field paths in a google.protobuf.FieldMask, names are converted into names in .proto
+func (fm fieldMask) paths(naming string) []string {
+	paths := make([]string, 0, len(fm))
+	for name, child := range fm {
+		if naming != fieldNamingOriginal && len(naming) > 0 {
+			name = ToSnakeCase(name)
+		}
+		if child == nil {
+			paths = append(paths, name)
+			continue
+		}
+		for _, p := range child.paths(naming) {
+			paths = append(paths, name+"."+p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ForwardFieldMask returns true if "forward_field_mask" in config file is "true",
+// then the field mask in "fields" query param is set to the google.protobuf.FieldMask field of requests, if any.
+func (c *Config) ForwardFieldMask() bool {
+	return c.configs[forwardFieldMask] == "true"
+}
+
+// setFieldMask sets the field mask of a request to the first google.protobuf.FieldMask field in v, if it's not set
+func setFieldMask(s Servable, v proto.Message, req *http.Request) {
+	c := s.ServerField().Config
+	if !c.ForwardFieldMask() {
+		return
+	}
+	mask, ok := fieldMaskOf(req)
+	if !ok {
+		return
+	}
+	value := reflect.ValueOf(v).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() != reflect.Ptr || field.Type().Elem().Kind() != reflect.Struct || !field.CanSet() {
+			continue
+		}
+		fm, ok := reflect.New(field.Type().Elem()).Interface().(proto.Message)
+		if !ok || proto.MessageName(fm) != "google.protobuf.FieldMask" {
+			continue
+		}
+		if field.IsNil() {
+			paths := reflect.ValueOf(fm).Elem().FieldByName("Paths")
+			if paths.IsValid() && paths.Type() == reflect.TypeOf([]string{}) {
+				paths.Set(reflect.ValueOf(mask.paths(c.Marshaler().FieldNaming)))
+				field.Set(reflect.ValueOf(fm))
+			}
+		}
+		return
+	}
+}
+
+// setFieldMask sets the field mask of a request to the first google.protobuf.FieldMask field, see setFieldMask()
+func (m *dynamicMessage) setFieldMask(s Servable, req *http.Request) error {
+	c := s.ServerField().Config
+	if !c.ForwardFieldMask() {
+		return nil
+	}
+	mask, ok := fieldMaskOf(req)
+	if !ok {
+		return nil
+	}
+	for _, f := range m.t.desc.Field {
+		if f.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE || f.GetTypeName() != ".google.protobuf.FieldMask" ||
+			f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			continue
+		}
+		if _, ok := m.values[f.GetNumber()]; ok {
+			return nil
+		}
+		child, err := m.child(f)
+		if err != nil {
+			return err
+		}
+		paths := make([]interface{}, 0)
+		for _, p := range mask.paths(c.Marshaler().FieldNaming) {
+			paths = append(paths, p)
+		}
+		child.values[1] = paths
+		m.values[f.GetNumber()] = child
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package turbo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFieldMask looks like google.protobuf.FieldMask
+type testFieldMask struct {
+	Paths []string `protobuf:"bytes,1,rep,name=paths,proto3" json:"paths,omitempty"`
+}
+
+func (t *testFieldMask) Reset()                  { *t = testFieldMask{} }
+func (t *testFieldMask) String() string          { return "" }
+func (t *testFieldMask) ProtoMessage()           {}
+func (t *testFieldMask) XXX_MessageName() string { return "google.protobuf.FieldMask" }
+
+type testMaskRequest struct {
+	Name string         `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Mask *testFieldMask `protobuf:"bytes,2,opt,name=mask,proto3" json:"mask,omitempty"`
+}
+
+func (t *testMaskRequest) Reset()         { *t = testMaskRequest{} }
+func (t *testMaskRequest) String() string { return "" }
+func (t *testMaskRequest) ProtoMessage()  {}
+
+func TestFieldMask(t *testing.T) {
+	mask := parseFieldMask(" message, user.name,items.id,items,user.address.city,")
+	assert.Equal(t, fieldMask{"message": nil, "items": nil, "user": fieldMask{"name": nil, "address": fieldMask{"city": nil}}}, mask)
+	assert.Equal(t, []string{"items", "message", "user.address.city", "user.name"}, mask.paths("original"))
+	assert.Equal(t, []string{"user_name"}, parseFieldMask("userName").paths("lower_camel"))
+
+	b, err := parseFieldMask("message,user.name,items.id").pruneJSON(
+		[]byte(`{"code":1,"items":[{"id":"1","title":"a"},{"title":"b"}],"message":"<ok>","user":{"age":3,"name":"x"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"items":[{"id":"1"},{}],"message":"\u003cok\u003e","user":{"name":"x"}}`, string(b))
+	b, _ = parseFieldMask("id").pruneJSON([]byte(`[{"id":1,"name":"a"}]`))
+	assert.Equal(t, `[{"id":1}]`, string(b))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Fields", "a")
+	_, ok := fieldMaskOf(req)
+	assert.True(t, ok)
+	_, ok = fieldMaskOf(httptest.NewRequest("GET", "/?fields=", nil))
+	assert.False(t, ok)
+}
+
+func TestSetFieldMask(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	request := &testMaskRequest{}
+	setFieldMask(s, request, httptest.NewRequest("GET", "/?fields=name,child.title", nil))
+	assert.Nil(t, request.Mask)
+
+	s.Config.configs[forwardFieldMask] = "true"
+	setFieldMask(s, request, httptest.NewRequest("GET", "/?fields=name,child.title", nil))
+	assert.Equal(t, []string{"child.title", "name"}, request.Mask.Paths)
+	// a mask in request is not overwritten
+	setFieldMask(s, request, httptest.NewRequest("GET", "/?fields=name", nil))
+	assert.Equal(t, []string{"child.title", "name"}, request.Mask.Paths)
+}
+
+func TestPruneResponse(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		return &testRuleRequest{Id: 12, Name: "turbo", Child: &testRuleChild{Title: "a"}}, nil
+	}
+	resp := serveTestRequest(s, "GET", "/v1/rules/12?fields=id,child.title", "")
+	assert.Equal(t, `{"child":{"title":"a"},"id":12}`, resp.Body.String())
+}
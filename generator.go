@@ -7,8 +7,14 @@ package turbo
 
 import (
 	"bytes"
+	"encoding/json"
+	"go/format"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -19,7 +25,20 @@ type Generator struct {
 	PkgPath        string
 	ConfigFileName string
 	Options        string
-	c              *Config
+	// OpenAPI is true if an OpenAPI document should be generated into "gen/openapi.json"
+	OpenAPI bool
+	// HTTPClient is true if a go client package of the HTTP server should be generated into "gen/httpclient"
+	HTTPClient bool
+	// TypeScript is true if TypeScript definitions and a fetch client should be generated into "gen/ts/client.ts"
+	TypeScript bool
+	// Check is true if generated files are kept in memory to be compared with files on disk, see StaleFiles,
+	// grpc|thrift stubs are not generated in this mode, files of protoc-gen-buildfields are generated into a temp dir.
+	Check bool
+	c     *Config
+	// thrift is the parsed .thrift file of the thrift service
+	thrift *thriftFile
+	// output holds generated files in Check mode, keyed by absolute path
+	output map[string][]byte
 }
 
 // Generate proto/thrift code
@@ -27,17 +46,80 @@ func (g *Generator) Generate() {
 	if g.RpcType != "grpc" && g.RpcType != "thrift" {
 		panic("Invalid server type, should be (grpc|thrift)")
 	}
-	g.c = NewConfig(g.RpcType, GOPATH()+"/src/"+g.PkgPath+"/"+g.ConfigFileName+".yaml")
+	g.c = NewConfig(g.RpcType, PackageDir(g.PkgPath)+"/"+g.ConfigFileName+".yaml")
 	if g.RpcType == "grpc" {
-		g.GenerateProtobufStub()
+		if g.Check {
+			dir := g.generateBuildFields()
+			defer os.RemoveAll(dir)
+			g.useBuildFields(dir)
+		} else {
+			g.GenerateProtobufStub()
+		}
 		g.c.loadFieldMapping()
 		g.GenerateGrpcSwitcher()
 	} else if g.RpcType == "thrift" {
-		g.GenerateThriftStub()
-		g.GenerateBuildThriftParameters()
-		g.c.loadFieldMapping()
+		if !g.Check {
+			g.GenerateThriftStub()
+		}
+		g.GenerateThriftFields()
 		g.GenerateThriftSwitcher()
 	}
+	if g.OpenAPI {
+		g.GenerateOpenAPI()
+	}
+	if g.HTTPClient {
+		g.GenerateHTTPClient()
+	}
+	if g.TypeScript {
+		g.GenerateTypeScript()
+	}
+}
+
+// StaleFiles returns paths, relative to [service_root_path], of files generated in Check mode
+// which are missing or different on disk.
+func (g *Generator) StaleFiles() []string {
+	paths := make([]string, 0, len(g.output))
+	for p := range g.output {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	stale := make([]string, 0)
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil || !bytes.Equal(data, g.output[p]) {
+			stale = append(stale, strings.TrimPrefix(p, g.c.ServiceRootPathAbsolute()+"/"))
+		}
+	}
+	return stale
+}
+
+// writeFile saves a generated file, go codes are formatted with gofmt,
+// in Check mode the file is kept in memory instead.
+func (g *Generator) writeFile(filePath string, data []byte) {
+	if strings.HasSuffix(filePath, ".go") {
+		formatted, err := format.Source(data)
+		if err != nil {
+			panic("fail to format " + filePath + ": " + err.Error())
+		}
+		data = formatted
+	}
+	if g.Check {
+		if g.output == nil {
+			g.output = make(map[string][]byte)
+		}
+		g.output[filePath] = data
+		return
+	}
+	panicIf(os.MkdirAll(filepath.Dir(filePath), 0755))
+	panicIf(ioutil.WriteFile(filePath, data, 0644))
+}
+
+func (g *Generator) writeTemplate(filePath string, data interface{}, text string) {
+	tmpl, err := template.New("").Parse(text)
+	panicIf(err)
+	buf := &bytes.Buffer{}
+	panicIf(tmpl.Execute(buf, data))
+	g.writeFile(filePath, buf.Bytes())
 }
 
 func writeFileWithTemplate(filePath string, data interface{}, text string) {
@@ -51,29 +133,44 @@ func writeFileWithTemplate(filePath string, data interface{}, text string) {
 	panicIf(err)
 }
 
-// GenerateGrpcSwitcher generates "grpcswither.go"
+// GenerateGrpcSwitcher generates "grpcswither.go",
+// request messages are built by generated Binders if the descriptor set written by protoc-gen-buildfields is found,
+// or by BuildStruct with reflection if it's not.
 func (g *Generator) GenerateGrpcSwitcher() {
 	type handlerContent struct {
 		MethodNames  []string
 		PkgPath      string
 		ServiceName  string
 		StructFields []string
-	}
-	if _, err := os.Stat(g.c.ServiceRootPathAbsolute() + "/gen"); os.IsNotExist(err) {
-		os.Mkdir(g.c.ServiceRootPathAbsolute()+"/gen", 0755)
+		Binders      []string
+		BinderFuncs  string
 	}
 	methodNames := methodNames(g.c.mappings[urlServiceMaps])
 	structFields := make([]string, len(methodNames))
 	for i, v := range methodNames {
-		structFields[i] = g.structFields(v + "Request")
+		structFields[i] = g.structFields("g", v+"Request")
 	}
-	writeFileWithTemplate(
+	binders := make([]string, len(methodNames))
+	var binderFuncs string
+	if schema, err := loadGrpcSchema(g.c.generatedDir()+"/grpcdescriptors.pb", g.c.GrpcServiceName()); err == nil {
+		messages := grpcBinderMessages(schema, schema.Packages[g.c.GrpcServiceName()], "g")
+		b := newBinderBuilder(messages, func(name string) string { return g.structFields("g", name) })
+		for i, v := range methodNames {
+			if m, ok := schema.Methods[v]; ok && m.Request == v+"Request" {
+				binders[i] = b.binder(m.Request)
+			}
+		}
+		binderFuncs = b.build()
+	}
+	g.writeTemplate(
 		g.c.ServiceRootPathAbsolute()+"/gen/grpcswitcher.go",
 		handlerContent{
 			MethodNames:  methodNames,
 			PkgPath:      g.PkgPath,
 			ServiceName:  g.c.GrpcServiceName(),
 			StructFields: structFields,
+			Binders:      binders,
+			BinderFuncs:  binderFuncs,
 		},
 		`// Code generated by turbo. DO NOT EDIT.
 package gen
@@ -91,7 +188,7 @@ var GrpcSwitcher = func(s turbo.Servable, methodName string, resp http.ResponseW
 	switch methodName { {{range $i, $MethodName := .MethodNames}}
 	case "{{$MethodName}}":
 		request := &g.{{$MethodName}}Request{ {{index $.StructFields $i}} }
-		err = turbo.BuildRequest(s, request, req)
+		{{with index $.Binders $i}}err = turbo.BuildRequestWithBinder(s, request, req, {{.}}){{else}}err = turbo.BuildRequest(s, request, req){{end}}
 		if err != nil {
 			return nil, err
 		}
@@ -102,10 +199,12 @@ var GrpcSwitcher = func(s turbo.Servable, methodName string, resp http.ResponseW
 	turbo.WithCallOptions(req, header, trailer, peer)
 	return
 }
-`)
+{{.BinderFuncs}}`)
 }
 
-func (g *Generator) structFields(structName string) string {
+// structFields returns fields to initialize a struct with, which are struct pointers listed in "fieldmapping",
+// 'pkg' is the name of the generated go package in switchers.
+func (g *Generator) structFields(pkg, structName string) string {
 	fields, ok := g.c.fieldMappings[structName]
 	if !ok {
 		return ""
@@ -119,7 +218,7 @@ func (g *Generator) structFields(structName string) string {
 		nameSlice := []rune(pair[1])
 		name := strings.ToUpper(string(nameSlice[0])) + string(nameSlice[1:])
 		typeName := pair[0]
-		fieldStr = fieldStr + name + ": &g." + typeName + "{" + g.structFields(typeName) + "},"
+		fieldStr = fieldStr + name + ": &" + pkg + "." + typeName + "{" + g.structFields(pkg, typeName) + "},"
 	}
 	return fieldStr
 }
@@ -135,209 +234,138 @@ func (g *Generator) GenerateProtobufStub() {
 	executeCmd("bash", "-c", cmd)
 }
 
-// GenerateBuildThriftParameters generates "build.go"
-func (g *Generator) GenerateBuildThriftParameters() {
-	type buildThriftParametersValues struct {
-		PkgPath         string
-		ServiceName     string
-		ServiceRootPath string
-		MethodNames     []string
-	}
-	writeFileWithTemplate(
-		g.c.ServiceRootPathAbsolute()+"/gen/thrift/build.go",
-		buildThriftParametersValues{
-			PkgPath:         g.PkgPath,
-			ServiceName:     g.c.GrpcServiceName(),
-			ServiceRootPath: g.c.ServiceRootPathAbsolute(),
-			MethodNames:     methodNames(g.c.mappings[urlServiceMaps])},
-		buildThriftParameters,
-	)
-	g.runBuildThriftFields()
-}
-
-func (g *Generator) runBuildThriftFields() {
-	cmd := "go run " + g.c.ServiceRootPathAbsolute() + "/gen/thrift/build.go"
-	c := exec.Command("bash", "-c", cmd)
-	c.Stdin = os.Stdin
-	c.Stderr = os.Stderr
-	c.Stdout = os.Stdout
-	panicIf(c.Run())
+// generateBuildFields runs protoc-gen-buildfields only, with a temp dir as [service_root_path], returns the dir
+func (g *Generator) generateBuildFields() string {
+	dir, err := ioutil.TempDir("", "turbo_check")
+	panicIf(err)
+	panicIf(os.MkdirAll(dir+"/gen", 0755))
+	executeCmd("bash", "-c", "protoc "+g.Options+" --buildfields_out=service_root_path="+dir+":"+dir)
+	return dir
 }
 
-var buildThriftParameters string = `package main
-
-import (
-	"flag"
-	"fmt"
-	g "{{.PkgPath}}/gen/thrift/gen-go/gen"
-	"io"
-	"os"
-	"reflect"
-	"strings"
-	"text/template"
-)
-
-var methodName = flag.String("n", "", "")
-
-func main() {
-	flag.Parse()
-	if len(strings.TrimSpace(*methodName)) > 0 {
-		str := buildParameterStr(*methodName)
-		fmt.Print(str)
-	} else {
-		buildFields()
-	}
-}
-
-func buildFields() {
-	i := new(g.{{.ServiceName}})
-	t := reflect.TypeOf(i).Elem()
-	numMethod := t.NumMethod()
-	items := make([]string, 0)
-	for i := 0; i < numMethod; i++ {
-		method := t.Method(i)
-		numIn := method.Type.NumIn()
-		for j := 0; j < numIn; j++ {
-			argType := method.Type.In(j)
-			argStr := argType.String()
-			if argType.Kind() == reflect.Ptr && argType.Elem().Kind() == reflect.Struct {
-				arr := strings.Split(argStr, ".")
-				name := arr[len(arr)-1:][0]
-				items = findItem(items, name, argType)
-			}
-		}
+// useBuildFields makes the generator read files written by protoc-gen-buildfields into "[dir]/gen"
+// instead of those in [service_root_path]/gen, they are kept as generated files to be compared by StaleFiles.
+func (g *Generator) useBuildFields(dir string) {
+	g.c.genDir = dir + "/gen"
+	g.c.loadUrlMap()
+	for _, name := range []string{"grpcdescriptors.pb", "grpcfields.yaml", "grpcroutes.yaml"} {
+		data, err := ioutil.ReadFile(g.c.genDir + "/" + name)
+		panicIf(err)
+		g.writeFile(g.c.ServiceRootPathAbsolute()+"/gen/"+name, data)
 	}
-	var list string
-	for _, s := range items {
-		list += s + "\n"
-	}
-	writeFileWithTemplate(
-		"{{.ServiceRootPath}}/gen/thriftfields.yaml",
-		fieldsYaml,
-		fieldsYamlValues{List: list},
-	)
 }
 
-func findItem(items []string, name string, structType reflect.Type) []string {
-	numField := structType.Elem().NumField()
-	item := "  - " + name + "["
-	for i := 0; i < numField; i++ {
-		fieldType := structType.Elem().Field(i)
-		if fieldType.Type.Kind() == reflect.Ptr && fieldType.Type.Elem().Kind() == reflect.Struct {
-			arr := strings.Split(fieldType.Type.String(), ".")
-			typeName := arr[len(arr)-1:][0]
-			argName := fieldType.Name
-			item += fmt.Sprintf("%s %s,", typeName, argName)
-			items = findItem(items, typeName, fieldType.Type)
-		}
+// GenerateThriftFields generates "thriftfields.yaml" from .thrift files,
+// it lists struct arguments of all methods, and struct fields of them.
+func (g *Generator) GenerateThriftFields() {
+	items, err := g.thriftIDL().fieldMappings(g.c.ThriftServiceName())
+	panicIf(err)
+	var list string
+	for _, item := range items {
+		list += "  - " + item + "\n"
 	}
-	item += "]"
-	return append(items, item)
+	g.writeFile(g.c.ServiceRootPathAbsolute()+"/gen/thriftfields.yaml", []byte("thrift-fieldmapping:\n"+list))
+	g.c.setFieldMappings(items)
 }
 
-func writeWithTemplate(wr io.Writer, text string, data interface{}) {
-	tmpl, err := template.New("").Parse(text)
-	if err != nil {
-		panic(err)
+// thriftIDL parses "[service_root_path]/[thrift_service_name].thrift" and the files it includes,
+// included files are searched in paths given by "-I" in Options.
+func (g *Generator) thriftIDL() *thriftFile {
+	if g.thrift != nil {
+		return g.thrift
 	}
-	err = tmpl.Execute(wr, data)
-	if err != nil {
-		panic(err)
+	includePaths := make([]string, 0)
+	options := strings.Fields(g.Options)
+	for i := 0; i < len(options)-1; i++ {
+		if options[i] == "-I" {
+			includePaths = append(includePaths, options[i+1])
+		}
 	}
+	f, err := parseThriftFile(g.c.ServiceRootPathAbsolute()+"/"+strings.ToLower(g.c.ThriftServiceName())+".thrift", includePaths)
+	panicIf(err)
+	g.thrift = f
+	return f
 }
 
-func writeFileWithTemplate(filePath, text string, data interface{}) {
-	f, err := os.Create(filePath)
-	if err != nil {
-		panic("fail to create file:" + filePath)
-	}
-	writeWithTemplate(f, text, data)
+// thriftHandlerContent is the data of thriftSwitcherFunc
+type thriftHandlerContent struct {
+	PkgPath            string
+	ServiceName        string
+	MethodNames        []string
+	Parameters         []string
+	NotEmptyParameters []bool
+	ArgsTypes          []string
+	Binders            []string
+	BinderFuncs        string
+	// Reflection is true if any method is built with reflection, then buildStructArg is generated,
+	// which builds structs in "thrift-fieldmapping", StructNames, with StructFields.
+	Reflection   bool
+	StructNames  []string
+	StructFields []string
 }
 
-type fieldsYamlValues struct {
-	List string
+// GenerateThriftSwitcher generates "thriftswitcher.go", arguments of methods are built by generated Binders
+// if their structs are found in .thrift files, or by BuildThriftArgs with reflection if they are not.
+func (g *Generator) GenerateThriftSwitcher() {
+	g.writeThriftSwitcher(g.thriftSwitcherContent())
 }
 
-var fieldsYaml string = ` + "`" + `thrift-fieldmapping:
-{{printf "%s" "{{.List}}"}}
-` + "`" + `
-
-func buildParameterStr(methodName string) string {
-	switch methodName {
-{{range $i, $MethodName := .MethodNames}}
-	case "{{$MethodName}}":
-		var result string
-		args := g.{{$.ServiceName}}{{$MethodName}}Args{}
-		at := reflect.TypeOf(args)
-		num := at.NumField()
-		for i := 0; i < num; i++ {
-			result += fmt.Sprintf(
-				"\n\t\t\tparams[%d].Interface().(%s),",
-				i, at.Field(i).Type.String())
+func (g *Generator) thriftSwitcherContent() thriftHandlerContent {
+	messages, methods, err := g.thriftIDL().binderMessages(g.c.ThriftServiceName())
+	if err != nil {
+		log.Warn("thrift switcher: ", err, ", arguments are built with reflection")
+	}
+	b := newBinderBuilder(messages, func(name string) string { return g.structFields("gen", name) })
+	methodNames := methodNames(g.c.mappings[urlServiceMaps])
+	content := thriftHandlerContent{
+		PkgPath:     g.PkgPath,
+		ServiceName: g.c.ThriftServiceName(),
+		MethodNames: methodNames,
+	}
+	for _, v := range methodNames {
+		p := g.thriftParameters(v)
+		content.Parameters = append(content.Parameters, p)
+		content.NotEmptyParameters = append(content.NotEmptyParameters, len(strings.TrimSpace(p)) > 0)
+		argsType := "gen." + g.c.ThriftServiceName() + v + "Args"
+		binder := ""
+		if args, ok := messages[methods[v]]; ok {
+			argsType = args.GoType
+			if len(strings.TrimSpace(p)) > 0 {
+				binder = b.binder(args.Name)
+			}
 		}
-		return result
-{{end}}
-	default:
-		return "error"
+		content.ArgsTypes = append(content.ArgsTypes, argsType)
+		content.Binders = append(content.Binders, binder)
 	}
+	content.BinderFuncs = b.build()
+	return content
 }
-`
 
-// GenerateThriftSwitcher generates "thriftswitcher.go"
-func (g *Generator) GenerateThriftSwitcher() {
-	type thriftHandlerContent struct {
-		PkgPath            string
-		BuildArgsCases     string
-		ServiceName        string
-		MethodNames        []string
-		Parameters         []string
-		NotEmptyParameters []bool
-		StructNames        []string
-		StructFields       []string
-	}
-	if _, err := os.Stat(g.c.ServiceRootPathAbsolute() + "/gen"); os.IsNotExist(err) {
-		os.Mkdir(g.c.ServiceRootPathAbsolute()+"/gen", 0755)
+// writeThriftSwitcher writes "thriftswitcher.go", with buildStructArg if any method has parameters but no Binder
+func (g *Generator) writeThriftSwitcher(content thriftHandlerContent) {
+	for i, binder := range content.Binders {
+		if len(binder) > 0 || !content.NotEmptyParameters[i] {
+			continue
+		}
+		content.Reflection = true
+		structNames := make([]string, 0, len(g.c.fieldMappings))
+		for name := range g.c.fieldMappings {
+			structNames = append(structNames, name)
+		}
+		sort.Strings(structNames)
+		for _, name := range structNames {
+			content.StructNames = append(content.StructNames, name)
+			content.StructFields = append(content.StructFields, g.structFields("gen", name))
+		}
+		break
 	}
-	methodNames := methodNames(g.c.mappings[urlServiceMaps])
-	parameters := make([]string, 0, len(methodNames))
-	notEmptyParameters := make([]bool, 0, len(methodNames))
-	for _, v := range methodNames {
-		p := g.thriftParameters(v)
-		parameters = append(parameters, p)
-		notEmptyParameters = append(notEmptyParameters, len(strings.TrimSpace(p)) > 0)
-	}
-
-	var argCasesStr string
-	fields := make([]string, 0, len(g.c.fieldMappings))
-	structNames := make([]string, 0, len(g.c.fieldMappings))
-	for k := range g.c.fieldMappings {
-		structNames = append(structNames, k)
-		fields = append(fields, g.structFields(k))
-	}
-	writeFileWithTemplate(
-		g.c.ServiceRootPathAbsolute()+"/gen/thriftswitcher.go",
-		thriftHandlerContent{
-			PkgPath:            g.PkgPath,
-			BuildArgsCases:     argCasesStr,
-			ServiceName:        g.c.ThriftServiceName(),
-			MethodNames:        methodNames,
-			Parameters:         parameters,
-			NotEmptyParameters: notEmptyParameters,
-			StructNames:        structNames,
-			StructFields:       fields},
-		thriftSwitcherFunc,
-	)
+	g.writeTemplate(g.c.ServiceRootPathAbsolute()+"/gen/thriftswitcher.go", content, thriftSwitcherFunc)
 }
 
 func (g *Generator) thriftParameters(methodName string) string {
-	cmd := "go run " + g.c.ServiceRootPathAbsolute() + "/gen/thrift/build.go -n " + methodName
-	buf := &bytes.Buffer{}
-	c := exec.Command("bash", "-c", cmd)
-	c.Stdin = os.Stdin
-	c.Stderr = os.Stderr
-	c.Stdout = buf
-	panicIf(c.Run())
-	return buf.String() + " "
+	parameters, err := g.thriftIDL().parameters(g.c.ThriftServiceName(), methodName)
+	panicIf(err)
+	return parameters + " "
 }
 
 func methodNames(urlServiceMaps [][3]string) []string {
@@ -349,6 +377,7 @@ func methodNames(urlServiceMaps [][3]string) []string {
 	for k := range methodNamesMap {
 		methodNames = append(methodNames, k)
 	}
+	sort.Strings(methodNames)
 	return methodNames
 }
 
@@ -358,9 +387,9 @@ package gen
 import (
 	"{{.PkgPath}}/gen/thrift/gen-go/gen"
 	"github.com/vaporz/turbo"
-	"reflect"
 	"net/http"
-	"errors"
+	"errors"{{if .Reflection}}
+	"reflect"{{end}}
 )
 
 // ThriftSwitcher is a runtime func with which a server starts.
@@ -368,8 +397,8 @@ var ThriftSwitcher = func(s turbo.Servable, methodName string, resp http.Respons
 	switch methodName {
 {{range $i, $MethodName := .MethodNames}}
 	case "{{$MethodName}}":{{if index $.NotEmptyParameters $i }}
-		params, err := turbo.BuildThriftRequest(s, gen.{{$.ServiceName}}{{$MethodName}}Args{}, req, buildStructArg)
-		if err != nil {
+		args := new({{index $.ArgsTypes $i}})
+		{{with index $.Binders $i}}if err := turbo.BuildThriftRequestWithBinder(s, args, req, {{.}}); err != nil {{"{"}}{{else}}if err := turbo.BuildThriftArgs(s, args, req, buildStructArg); err != nil {{"{"}}{{end}}
 			return nil, err
 		}{{end}}
 		return s.Service().(*gen.{{$.ServiceName}}Client).{{$MethodName}}({{index $.Parameters $i}})
@@ -378,7 +407,7 @@ var ThriftSwitcher = func(s turbo.Servable, methodName string, resp http.Respons
 		return nil, errors.New("No such method[" + methodName + "]")
 	}
 }
-
+{{if .Reflection}}
 func buildStructArg(s turbo.Servable, typeName string, req *http.Request) (v reflect.Value, err error) {
 	switch typeName {
 {{range $i, $StructName := .StructNames}}
@@ -391,7 +420,124 @@ func buildStructArg(s turbo.Servable, typeName string, req *http.Request) (v ref
 		return v, errors.New("unknown typeName[" + typeName + "]")
 	}
 }
-`
+{{end}}{{.BinderFuncs}}`
+
+// loadSchema loads request and response types of rpc methods,
+// from the descriptor set written by protoc-gen-buildfields, or from .thrift files.
+func (g *Generator) loadSchema() *apiSchema {
+	if g.RpcType == "grpc" {
+		schema, err := loadGrpcSchema(g.c.generatedDir()+"/grpcdescriptors.pb", g.c.GrpcServiceName())
+		panicIf(err)
+		return schema
+	}
+	schema, err := g.thriftIDL().schema(g.c.ThriftServiceName())
+	panicIf(err)
+	return schema
+}
+
+// GenerateOpenAPI generates "openapi.json", an OpenAPI 3 document of all urls in "urlmapping"
+func (g *Generator) GenerateOpenAPI() {
+	doc := newOpenAPIBuilder(g.c, g.loadSchema()).build()
+	data, err := json.MarshalIndent(doc, "", "  ")
+	panicIf(err)
+	g.writeFile(g.c.ServiceRootPathAbsolute()+"/gen/openapi.json", append(data, '\n'))
+}
+
+// GenerateTypeScript generates "ts/client.ts", TypeScript interfaces of all messages and a client with one method per route
+func (g *Generator) GenerateTypeScript() {
+	code := newTSBuilder(g.c, g.loadSchema()).build()
+	g.writeFile(g.c.ServiceRootPathAbsolute()+"/gen/ts/client.ts", []byte(code))
+}
+
+// GenerateHTTPClient generates "httpclient/client.go", a go client with one method per route in "urlmapping",
+// only grpc is supported, since request and response types are the same as GrpcSwitcher uses.
+func (g *Generator) GenerateHTTPClient() {
+	if g.RpcType != "grpc" {
+		panic("HTTP client can only be generated for grpc services")
+	}
+	type clientValues struct {
+		PkgPath     string
+		ServiceName string
+		Routes      []httpClientRoute
+	}
+	g.writeTemplate(
+		g.c.ServiceRootPathAbsolute()+"/gen/httpclient/client.go",
+		clientValues{
+			PkgPath:     g.PkgPath,
+			ServiceName: g.c.GrpcServiceName(),
+			Routes:      httpClientRoutes(g.c),
+		},
+		httpClientTemplate,
+	)
+}
+
+// httpClientRoute is a method of the generated HTTP client
+type httpClientRoute struct {
+	FuncName     string
+	MethodName   string
+	HTTPMethod   string
+	Pattern      string
+	Body         string
+	ResponseBody string
+}
+
+// httpClientRoutes returns a method for each route, the first route of a rpc method is named after the rpc method,
+// other routes of the same rpc method are suffixed with the HTTP method, e.g. "SayHello", "SayHelloPost".
+func httpClientRoutes(c *Config) []httpClientRoute {
+	routes := make([]httpClientRoute, 0)
+	used := make(map[string]bool)
+	for _, m := range c.mappings[urlServiceMaps] {
+		for _, httpMethod := range strings.Split(m[0], ",") {
+			r := httpClientRoute{FuncName: m[2], MethodName: m[2], HTTPMethod: httpMethod, Pattern: m[1]}
+			if used[r.FuncName] {
+				r.FuncName = m[2] + camelCase(strings.ToLower(httpMethod))
+			}
+			for i := 2; used[r.FuncName]; i++ {
+				r.FuncName = m[2] + camelCase(strings.ToLower(httpMethod)) + strconv.Itoa(i)
+			}
+			used[r.FuncName] = true
+			if rule, ok := c.httpRules[httpMethod+" "+m[1]]; ok {
+				r.Body = rule.body
+				r.ResponseBody = rule.responseBody
+			} else if httpMethod != "GET" && httpMethod != "DELETE" && httpMethod != "HEAD" {
+				r.Body = "*"
+			}
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+var httpClientTemplate string = `// Code generated by turbo. DO NOT EDIT.
+package httpclient
+
+import (
+	"context"
+
+	"github.com/vaporz/turbo"
+	g "{{.PkgPath}}/gen/proto"
+)
+
+// Client calls {{.ServiceName}} through the turbo HTTP server
+type Client struct {
+	*turbo.HTTPClient
+}
+
+// NewClient returns a Client, baseURL is the scheme and host of the HTTP server, e.g. "http://127.0.0.1:8081"
+func NewClient(baseURL string) *Client {
+	return &Client{HTTPClient: turbo.NewHTTPClient(baseURL)}
+}
+{{range .Routes}}
+// {{.FuncName}} calls "{{.HTTPMethod}} {{.Pattern}}"
+func (c *Client) {{.FuncName}}(ctx context.Context, request *g.{{.MethodName}}Request) (*g.{{.MethodName}}Response, error) {
+	response := new(g.{{.MethodName}}Response)
+	route := turbo.HTTPRoute{Method: "{{.HTTPMethod}}", Pattern: "{{.Pattern}}", Body: "{{.Body}}", ResponseBody: "{{.ResponseBody}}"}
+	if err := c.Do(ctx, route, request, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+{{end}}`
 
 // GenerateThriftStub generates Thrift stub codes
 func (g *Generator) GenerateThriftStub() {
@@ -2,7 +2,9 @@ package turbo
 
 import (
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -28,7 +30,7 @@ func TestValidateServiceRootPath(t *testing.T) {
 	g.validateServiceRootPath(r)
 
 	g = &Creator{PkgPath: "github.com/vaporz/turbo/test/a"}
-	p := GOPATH() + "/src/github.com/vaporz/turbo/test/a"
+	p := PackageDir("github.com/vaporz/turbo/test/a")
 	os.MkdirAll(p, 0755)
 	g.validateServiceRootPath(r)
 	_, err := os.Stat(p)
@@ -46,3 +48,113 @@ func TestInvalidPkgPath(t *testing.T) {
 	g := &Creator{}
 	g.validateServiceRootPath(nil)
 }
+
+func TestGenerateCheck(t *testing.T) {
+	root, _ := ioutil.TempDir("", "turbo_generate")
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	ioutil.WriteFile(root+"/go.mod", []byte("module example.com/app\n"), 0644)
+	ioutil.WriteFile(root+"/service.yaml", []byte("config:\n"+
+		"  service_root_path: example.com/app\n"+
+		"  thrift_service_name: TestService\n"+
+		"urlmapping:\n"+
+		"  - GET /test_json TestJson\n"+
+		"  - GET /hello SayHello\n"), 0644)
+	for _, name := range []string{"testservice.thrift", "shared.thrift"} {
+		data, _ := ioutil.ReadFile("test/testservice/" + name)
+		ioutil.WriteFile(root+"/"+name, data, 0644)
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(root)
+
+	g := &Generator{RpcType: "thrift", PkgPath: "example.com/app", ConfigFileName: "service", Check: true, OpenAPI: true}
+	g.Generate()
+	assert.Equal(t, []string{"gen/openapi.json", "gen/thriftfields.yaml", "gen/thriftswitcher.go"}, g.StaleFiles())
+	switcher := string(g.output[root+"/gen/thriftswitcher.go"])
+	assert.True(t, strings.Index(switcher, `case "SayHello":`) < strings.Index(switcher, `case "TestJson":`))
+	for p, data := range g.output {
+		os.MkdirAll(filepath.Dir(p), 0755)
+		ioutil.WriteFile(p, data, 0644)
+	}
+
+	g = &Generator{RpcType: "thrift", PkgPath: "example.com/app", ConfigFileName: "service", Check: true, OpenAPI: true}
+	g.Generate()
+	assert.Equal(t, []string{}, g.StaleFiles())
+	assert.Equal(t, switcher, string(g.output[root+"/gen/thriftswitcher.go"]))
+}
+
+func TestGenerateCheckBuildFields(t *testing.T) {
+	root, _ := ioutil.TempDir("", "turbo_generate")
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	ioutil.WriteFile(root+"/go.mod", []byte("module example.com/app\n"), 0644)
+	ioutil.WriteFile(root+"/service.yaml", []byte("config:\n"+
+		"  service_root_path: example.com/app\n"+
+		"  grpc_service_name: TestService\n"+
+		"urlmapping:\n"+
+		"  - GET /hello SayHello\n"), 0644)
+	os.MkdirAll(root+"/gen", 0755)
+	ioutil.WriteFile(root+"/gen/grpcfields.yaml", []byte("grpc-fieldmapping:\n  - SayHelloRequest[]\n"), 0644)
+	routes, _ := ioutil.ReadFile("test/gen/grpcroutes.yaml")
+	ioutil.WriteFile(root+"/gen/grpcroutes.yaml", routes, 0644)
+	// what protoc-gen-buildfields writes now
+	dir, _ := ioutil.TempDir("", "turbo_check")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(dir+"/gen", 0755)
+	for _, name := range []string{"grpcfields.yaml", "grpcroutes.yaml"} {
+		data, _ := ioutil.ReadFile("test/gen/" + name)
+		ioutil.WriteFile(dir+"/gen/"+name, data, 0644)
+	}
+	ioutil.WriteFile(dir+"/gen/grpcdescriptors.pb", []byte{}, 0644)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(root)
+
+	g := &Generator{RpcType: "grpc", PkgPath: "example.com/app", ConfigFileName: "service", Check: true}
+	g.c = NewConfig(g.RpcType, root+"/service.yaml")
+	g.useBuildFields(dir)
+	g.c.loadFieldMapping()
+	g.GenerateGrpcSwitcher()
+	assert.Equal(t, []string{"gen/grpcdescriptors.pb", "gen/grpcfields.yaml", "gen/grpcswitcher.go"}, g.StaleFiles())
+	switcher := string(g.output[root+"/gen/grpcswitcher.go"])
+	assert.Contains(t, switcher, "request := &g.SayHelloRequest{Values: &g.CommonValues{}}")
+	assert.Contains(t, switcher, `case "GetRule":`)
+}
+
+func TestGenerateThriftSwitcherWithoutBinders(t *testing.T) {
+	root, _ := ioutil.TempDir("", "turbo_generate")
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	ioutil.WriteFile(root+"/go.mod", []byte("module example.com/app\n"), 0644)
+	ioutil.WriteFile(root+"/service.yaml", []byte("config:\n"+
+		"  service_root_path: example.com/app\n"+
+		"  thrift_service_name: TestService\n"+
+		"urlmapping:\n"+
+		"  - GET /test_json TestJson\n"+
+		"  - GET /hello SayHello\n"), 0644)
+	for _, name := range []string{"testservice.thrift", "shared.thrift"} {
+		data, _ := ioutil.ReadFile("test/testservice/" + name)
+		ioutil.WriteFile(root+"/"+name, data, 0644)
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(root)
+
+	g := &Generator{RpcType: "thrift", PkgPath: "example.com/app", ConfigFileName: "service", Check: true}
+	g.Generate()
+	switcher := string(g.output[root+"/gen/thriftswitcher.go"])
+	assert.Contains(t, switcher, "turbo.BuildThriftRequestWithBinder(s, args, req, bindTestServiceSayHelloArgs)")
+	assert.NotContains(t, switcher, "buildStructArg")
+
+	content := g.thriftSwitcherContent()
+	content.Binders[0], content.BinderFuncs = "", ""
+	g.writeThriftSwitcher(content)
+	switcher = string(g.output[root+"/gen/thriftswitcher.go"])
+	assert.Contains(t, switcher, "args := new(gen.TestServiceSayHelloArgs)")
+	assert.Contains(t, switcher, "turbo.BuildThriftArgs(s, args, req, buildStructArg)")
+	assert.Contains(t, switcher, "turbo.BuildThriftRequestWithBinder(s, args, req, bindTestServiceTestJsonArgs)")
+	assert.Contains(t, switcher, `case "CommonValues":`)
+	assert.Contains(t, switcher, `case "TestJsonRequest":`)
+	assert.Contains(t, switcher, `"reflect"`)
+}
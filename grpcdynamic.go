@@ -0,0 +1,224 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// GrpcDescriptorSource returns "grpc_descriptor_source" in config file,
+// it's "reflection"(default) to fetch descriptors from the reflection service of the backend,
+// or the path of a descriptor set file, relative paths are relative to [service_root_path],
+// e.g. "gen/grpcdescriptors.pb", which is written by protoc-gen-buildfields.
+func (c *Config) GrpcDescriptorSource() string {
+	source := strings.TrimSpace(c.configs[grpcDescriptorSource])
+	if len(source) == 0 {
+		return "reflection"
+	}
+	return source
+}
+
+// dynamicDescriptors holds descriptors used by DynamicGrpcSwitcher, they are loaded lazily and refreshed on config reload
+type dynamicDescriptors struct {
+	mutex    sync.RWMutex
+	registry *descriptorRegistry
+}
+
+// StartDynamicHTTPServer starts a HTTP server which sends requests via grpc without generated codes,
+// rpc methods are called by name with messages built from descriptors, see GrpcDescriptorSource,
+// so new rpc methods can be exposed by editing "urlmapping" only.
+func (s *GrpcServer) StartDynamicHTTPServer() {
+	s.Initializer.InitService(s)
+	s.httpServer = s.startGrpcHTTPServerInternal(func(conn *grpc.ClientConn) interface{} { return conn }, DynamicGrpcSwitcher)
+	if _, err := s.descriptors(); err != nil {
+		log.Error("failed to load grpc descriptors, err=", err)
+	}
+	watchConfigReload(s)
+}
+
+// DynamicGrpcSwitcher is a switcher which calls rpc methods by name,
+// it can be used in place of a generated GrpcSwitcher, see StartDynamicHTTPServer.
+func DynamicGrpcSwitcher(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	gs, ok := s.(*GrpcServer)
+	if !ok {
+		return nil, errors.New("turbo: DynamicGrpcSwitcher only works with GrpcServer")
+	}
+	registry, err := gs.descriptors()
+	if err != nil {
+		return nil, err
+	}
+	fullMethod, in, out, err := registry.method(gs.Config.GrpcServiceName(), methodName)
+	if err != nil {
+		return nil, err
+	}
+	request := newDynamicMessage(registry, in)
+	if err = buildDynamicRequest(s, request, req); err != nil {
+		return nil, err
+	}
+	if err = request.setFieldMask(s, req); err != nil {
+		return nil, err
+	}
+	response := newDynamicMessage(registry, out)
+	callOptions, header, trailer, peer := CallOptions(methodName, req)
+	if err = grpc.Invoke(req.Context(), fullMethod, request, response, gs.gClient.conn, callOptions...); err != nil {
+		return nil, err
+	}
+	WithCallOptions(req, header, trailer, peer)
+	return &dynamicJSON{
+		message:   response,
+		marshaler: gs.Config.Marshaler(),
+	}, nil
+}
+
+// descriptors returns loaded descriptors, or loads them if not loaded yet
+func (s *GrpcServer) descriptors() (*descriptorRegistry, error) {
+	s.dynamic.mutex.RLock()
+	registry := s.dynamic.registry
+	s.dynamic.mutex.RUnlock()
+	if registry != nil {
+		return registry, nil
+	}
+	return s.reloadDescriptors()
+}
+
+// reloadDescriptors loads descriptors from GrpcDescriptorSource, descriptors loaded before are kept if it fails
+func (s *GrpcServer) reloadDescriptors() (*descriptorRegistry, error) {
+	if s.gClient == nil || s.gClient.conn == nil {
+		return nil, errors.New("turbo: grpc connection not initiated")
+	}
+	var files []*descriptor.FileDescriptorProto
+	var err error
+	if source := s.Config.GrpcDescriptorSource(); source == "reflection" {
+		files, err = reflectionDescriptors(s.gClient.conn, s.Config.GrpcServiceName())
+	} else {
+		if !path.IsAbs(source) {
+			source = s.Config.ServiceRootPathAbsolute() + "/" + source
+		}
+		files, err = descriptorSetFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	registry := newDescriptorRegistry(files)
+	s.dynamic.mutex.Lock()
+	s.dynamic.registry = registry
+	s.dynamic.mutex.Unlock()
+	log.Info("grpc descriptors loaded, services: ", len(registry.services), ", messages: ", len(registry.messages))
+	return registry, nil
+}
+
+// descriptorReloader is implemented by servers which load descriptors at runtime
+type descriptorReloader interface {
+	reloadOnConfigChange()
+}
+
+// reloadOnConfigChange refreshes descriptors if the dynamic switcher is used
+func (s *GrpcServer) reloadOnConfigChange() {
+	s.dynamic.mutex.RLock()
+	loaded := s.dynamic.registry != nil
+	s.dynamic.mutex.RUnlock()
+	if !loaded {
+		return
+	}
+	if _, err := s.reloadDescriptors(); err != nil {
+		log.Error("reload grpc descriptors failed, err=", err)
+	}
+}
+
+func descriptorSetFile(file string) ([]*descriptor.FileDescriptorProto, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	set := new(descriptor.FileDescriptorSet)
+	if err = proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return set.File, nil
+}
+
+// reflectionDescriptors fetches the file defining 'serviceName', and all its dependencies, from the reflection service
+func reflectionDescriptors(conn *grpc.ClientConn, serviceName string) ([]*descriptor.FileDescriptorProto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+	call := func(req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return nil, errors.New("turbo: reflection error: " + e.GetErrorMessage())
+		}
+		return resp, nil
+	}
+
+	symbol := serviceName
+	resp, err := call(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{ListServices: "*"}})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		if service.GetName() == serviceName || strings.HasSuffix(service.GetName(), "."+serviceName) {
+			symbol = service.GetName()
+			break
+		}
+	}
+
+	files := make(map[string]*descriptor.FileDescriptorProto)
+	result := make([]*descriptor.FileDescriptorProto, 0)
+	requests := []*rpb.ServerReflectionRequest{{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol}}}
+	for len(requests) > 0 {
+		resp, err := call(requests[0])
+		if err != nil {
+			return nil, err
+		}
+		requests = requests[1:]
+		for _, data := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			f := new(descriptor.FileDescriptorProto)
+			if err = proto.Unmarshal(data, f); err != nil {
+				return nil, err
+			}
+			if files[f.GetName()] != nil {
+				continue
+			}
+			files[f.GetName()] = f
+			result = append(result, f)
+		}
+		for _, f := range result {
+			for _, dep := range f.Dependency {
+				if _, ok := files[dep]; ok {
+					continue
+				}
+				files[dep] = nil
+				requests = append(requests, &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep}})
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package turbo
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestDescriptorRegistryMethod(t *testing.T) {
+	r := newDescriptorRegistry(testDescriptors())
+	fullMethod, in, out, err := r.method("YourService", "SayHello")
+	assert.Nil(t, err)
+	assert.Equal(t, "/proto.YourService/sayHello", fullMethod)
+	assert.Equal(t, ".proto.SayHelloRequest", in.name)
+	assert.Equal(t, ".proto.SayHelloResponse", out.name)
+
+	_, _, _, err = r.method("proto.YourService", "sayHello")
+	assert.Nil(t, err)
+	_, _, _, err = r.method("YourService", "EatApple")
+	assert.Equal(t, "No such method[EatApple]", err.Error())
+}
+
+func TestDynamicMessageMarshal(t *testing.T) {
+	r := newDescriptorRegistry(testDescriptors())
+	m := newDynamicMessage(r, r.messages[".proto.SayHelloRequest"])
+	req, _ := http.NewRequest("GET", "/hello?your_name=turbo&some_id=-3&int64_list=1,2,3", nil)
+	req.ParseForm()
+	assert.Nil(t, m.buildFromForm(req, 0))
+
+	data, err := m.Marshal()
+	assert.Nil(t, err)
+	decoded := newDynamicMessage(r, m.t)
+	assert.Nil(t, decoded.Unmarshal(data))
+	v, _ := decoded.Get("yourName")
+	assert.Equal(t, "turbo", v)
+	v, _ = decoded.Get("int64_list")
+	assert.Equal(t, []interface{}{int64(1), int64(2), int64(3)}, v)
+	v, _ = decoded.Get("values")
+	someID, _ := v.(*dynamicMessage).Get("some_id")
+	assert.Equal(t, int64(-3), someID)
+
+	j := &dynamicJSON{message: decoded, marshaler: Marshaler{FilterProtoJson: true, Int64AsNumber: true}}
+	b, err := j.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"int64_list":[1,2,3],"values":{"some_id":-3},"yourName":"turbo"}`, string(b))
+	b, err = j.responseBody("yourName").(*dynamicJSON).MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, `"turbo"`, string(b))
+
+	j.marshaler = Marshaler{FieldNaming: "go_name"}
+	b, _ = j.MarshalJSON()
+	assert.Equal(t, `{"Int64List":["1","2","3"],"Values":{"SomeId":"-3"},"YourName":"turbo"}`, string(b))
+}
+
+func TestDescriptorSetFile(t *testing.T) {
+	data, _ := proto.Marshal(&descriptor.FileDescriptorSet{File: testDescriptors()})
+	f, _ := ioutil.TempFile("", "turbo_descriptors")
+	defer os.Remove(f.Name())
+	f.Write(data)
+	f.Close()
+
+	files, err := descriptorSetFile(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "test.proto", files[0].GetName())
+}
+
+func TestDynamicGrpcSwitcher(t *testing.T) {
+	registry := newDescriptorRegistry(testDescriptors())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		request := newDynamicMessage(registry, registry.messages[".proto.SayHelloRequest"])
+		if err := stream.RecvMsg(request); err != nil {
+			return err
+		}
+		name, _ := request.Get("yourName")
+		response := newDynamicMessage(registry, registry.messages[".proto.SayHelloResponse"])
+		response.values[1] = "hello, " + name.(string)
+		return stream.SendMsg(response)
+	}))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	s := &GrpcServer{Server: newTestServer("test/service_test.yaml"), gClient: new(grpcClient)}
+	s.gClient.dial(lis.Addr().String())
+	defer s.gClient.close()
+	s.dynamic.registry = registry
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = DynamicGrpcSwitcher
+
+	resp := httptest.NewRecorder()
+	router(s).ServeHTTP(resp, httptest.NewRequest("GET", "/hello?your_name=turbo", nil))
+	assert.Equal(t, `{"message":"hello, turbo"}`, resp.Body.String())
+}
@@ -17,6 +17,7 @@ type GrpcServer struct {
 	*Server
 	gClient    *grpcClient
 	grpcServer *grpc.Server
+	dynamic    dynamicDescriptors
 }
 
 func NewGrpcServer(initializer Initializable, configFilePath string) *GrpcServer {
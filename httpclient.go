@@ -0,0 +1,354 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// HTTPClient calls routes of a turbo HTTP server, it's used by clients generated with "turbo generate --httpclient"
+type HTTPClient struct {
+	// BaseURL is the scheme and host of the server, e.g. "http://127.0.0.1:8081"
+	BaseURL string
+	Client  *http.Client
+	// Header is sent with every request
+	Header http.Header
+}
+
+// NewHTTPClient returns a HTTPClient using http.DefaultClient
+func NewHTTPClient(baseURL string) *HTTPClient {
+	return &HTTPClient{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Client:  http.DefaultClient,
+		Header:  make(http.Header),
+	}
+}
+
+// HTTPRoute is a route in "urlmapping"
+type HTTPRoute struct {
+	Method string
+	// Pattern is the url pattern, e.g. "/hello/{your_name:[a-z]+}"
+	Pattern string
+	// Body is the request field sent as the json body, "*" for the whole request, or "" for no body
+	Body string
+	// ResponseBody is the response field which the response body is decoded into, or "" for the whole response
+	ResponseBody string
+}
+
+// HTTPError is returned by HTTPClient if the server responds with a non-2xx status code
+type HTTPError struct {
+	StatusCode int
+	// Code is the "code" in a json error body, if any
+	Code string
+	// Message is the "message" or "error" in a json error body, or the body text
+	Message string
+	Body    []byte
+}
+
+func (e *HTTPError) Error() string {
+	msg := "turbo: http status " + strconv.Itoa(e.StatusCode)
+	if len(e.Code) > 0 {
+		msg += ", code: " + e.Code
+	}
+	if len(e.Message) > 0 {
+		msg += ", message: " + e.Message
+	}
+	return msg
+}
+
+// Do sends 'request' to 'route', and decodes the response body into 'response'.
+// Fields of 'request' are sent with the same naming rules the server uses to find values:
+// path params are filled with fields whose lower case name or snake_case name matches the route variable,
+// the request is not sent if any of them is missing or empty,
+// other fields are sent as snake_case query params, or as the json body if route.Body is not empty.
+func (c *HTTPClient) Do(ctx context.Context, route HTTPRoute, request, response interface{}) error {
+	req, err := c.newRequest(ctx, route, request)
+	if err != nil {
+		return err
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeHTTPError(resp, data)
+	}
+	if response == nil || len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if len(route.ResponseBody) > 0 {
+		data = wrapField(route.ResponseBody, data)
+	}
+	return decodeJSON(bytes.NewReader(data), response)
+}
+
+func (c *HTTPClient) newRequest(ctx context.Context, route HTTPRoute, request interface{}) (*http.Request, error) {
+	v := reflect.ValueOf(request)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		v = reflect.Value{}
+	}
+	used := make(map[string]bool)
+	var missing []string
+	path := matchPathVar.ReplaceAllStringFunc(route.Pattern, func(name string) string {
+		parts := matchPathVar.FindStringSubmatch(name)
+		value, ok := pathParamValue(v, parts[1])
+		if !ok || len(value) == 0 {
+			missing = append(missing, parts[1])
+			return ""
+		}
+		used[strings.ToLower(parts[1])] = true
+		segments := strings.Split(value, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		return strings.Join(segments, "/")
+	})
+	if len(missing) > 0 {
+		// a path with empty segments may match another route
+		return nil, errors.New("turbo: missing path params [" + strings.Join(missing, ",") + "] of " +
+			route.Method + " " + route.Pattern)
+	}
+
+	var body io.Reader
+	query := make(url.Values)
+	switch route.Body {
+	case "":
+		addQueryValues(v, "", used, query)
+	case "*":
+		data, err := encodeJSON(request)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	default:
+		addQueryValues(v, route.Body, used, query)
+		if v.IsValid() {
+			if index, ok := protoFieldIndex(v.Type(), route.Body); ok {
+				data, err := encodeJSON(v.Field(index).Interface())
+				if err != nil {
+					return nil, err
+				}
+				body = bytes.NewReader(data)
+			}
+		}
+	}
+
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(route.Method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range c.Header {
+		for _, value := range values {
+			req.Header.Add(k, value)
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return req.WithContext(ctx), nil
+}
+
+// pathParamValue finds the field for a route variable, the same way setPathParams does,
+// or the same way setFieldPaths does if it's a field path like "user.id"
+func pathParamValue(v reflect.Value, name string) (string, bool) {
+	if strings.Contains(name, ".") {
+		for _, fieldName := range strings.Split(name, ".") {
+			for v.Kind() == reflect.Ptr && !v.IsNil() {
+				v = v.Elem()
+			}
+			if v.Kind() != reflect.Struct {
+				return "", false
+			}
+			index, ok := protoFieldIndex(v.Type(), fieldName)
+			if !ok {
+				return "", false
+			}
+			v = v.Field(index)
+		}
+		return formatValue(v)
+	}
+	var value string
+	found := false
+	name = strings.ToLower(name)
+	eachScalarField(v, "", func(field reflect.StructField, fieldValue reflect.Value) {
+		if found || (strings.ToLower(field.Name) != name && ToSnakeCase(field.Name) != name) {
+			return
+		}
+		value, found = formatValue(fieldValue)
+	})
+	return value, found
+}
+
+// addQueryValues adds non-zero fields as query params, except fields used by path params, and the body field 'skip'
+func addQueryValues(v reflect.Value, skip string, used map[string]bool, query url.Values) {
+	eachScalarField(v, skip, func(field reflect.StructField, fieldValue reflect.Value) {
+		name := ToSnakeCase(field.Name)
+		if used[strings.ToLower(field.Name)] || used[name] || len(query.Get(name)) > 0 {
+			return
+		}
+		if isZero(fieldValue) {
+			return
+		}
+		if value, ok := formatValue(fieldValue); ok {
+			query.Set(name, value)
+		}
+	})
+}
+
+// eachScalarField walks fields of a struct recursively, struct pointers are flattened like BuildStruct does,
+// the top level field named 'skip' is ignored.
+func eachScalarField(v reflect.Value, skip string, fn func(reflect.StructField, reflect.Value)) {
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return
+	}
+	skipIndex := -1
+	if len(skip) > 0 {
+		if index, ok := protoFieldIndex(v.Type(), skip); ok {
+			skipIndex = index
+		}
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if i == skipIndex || len(field.PkgPath) > 0 || strings.HasPrefix(field.Name, "XXX_") {
+			continue
+		}
+		fieldValue := v.Field(i)
+		if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+			if !fieldValue.IsNil() {
+				eachScalarField(fieldValue.Elem(), "", fn)
+			}
+			continue
+		}
+		fn(field, fieldValue)
+	}
+}
+
+// formatValue formats a value in the format setValue parses, lists are comma separated
+func formatValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), true
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return "", false
+		}
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s, ok := formatValue(v.Index(i))
+			if !ok {
+				return "", false
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), true
+	}
+	return "", false
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	if pb, ok := v.(proto.Message); ok {
+		s, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(pb)
+		return []byte(s), err
+	}
+	return json.Marshal(v)
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	if pb, ok := v.(proto.Message); ok {
+		return (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(r, pb)
+	}
+	return json.NewDecoder(r).Decode(v)
+}
+
+// wrapField wraps 'data' into a json object, as the value of 'field'
+func wrapField(field string, data []byte) []byte {
+	name, _ := json.Marshal(field)
+	buf := bytes.NewBufferString("{")
+	buf.Write(name)
+	buf.WriteString(":")
+	buf.Write(data)
+	buf.WriteString("}")
+	return buf.Bytes()
+}
+
+// decodeHTTPError decodes an error body, e.g. {"code":"NotFound","message":"..."}, or a plain text message
+func decodeHTTPError(resp *http.Response, data []byte) error {
+	e := &HTTPError{StatusCode: resp.StatusCode, Body: data}
+	envelope := make(map[string]interface{})
+	if json.Unmarshal(data, &envelope) == nil {
+		if code, ok := envelope["code"]; ok {
+			e.Code = jsonString(code)
+		}
+		for _, key := range []string{"message", "error"} {
+			if msg, ok := envelope[key]; ok {
+				e.Message = jsonString(msg)
+				break
+			}
+		}
+		return e
+	}
+	e.Message = strings.TrimSpace(string(data))
+	return e
+}
+
+func jsonString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
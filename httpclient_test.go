@@ -0,0 +1,95 @@
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/format"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPClientDo(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	var request *testRuleRequest
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		if methodName == "ReplaceRule" {
+			return nil, errors.New("replace failed")
+		}
+		request = &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequest(s, request, req)
+		return request, err
+	}
+	server := httptest.NewServer(router(s))
+	defer server.Close()
+	c := NewHTTPClient(server.URL)
+
+	response := &testRuleRequest{}
+	err := c.Do(context.Background(), HTTPRoute{Method: "GET", Pattern: "/v1/rules/{id}"},
+		&testRuleRequest{Id: 12, Name: "a b", Child: &testRuleChild{Title: "t"}}, response)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "a b", request.Name)
+	assert.Equal(t, "t", request.Child.Title)
+	assert.Equal(t, int64(12), response.Id)
+
+	child := &testRuleChild{}
+	err = c.Do(context.Background(), HTTPRoute{Method: "POST", Pattern: "/v1/rules/{id}", Body: "child", ResponseBody: "child"},
+		&testRuleRequest{Id: 7, Name: "query", Child: &testRuleChild{Title: "body"}}, &testRuleRequest{Child: child})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), request.Id)
+	assert.Equal(t, "query", request.Name)
+	assert.Equal(t, "body", request.Child.Title)
+
+	err = c.Do(context.Background(), HTTPRoute{Method: "PUT", Pattern: "/v1/rules/{id}", Body: "*"},
+		&testRuleRequest{Id: 7}, &testRuleRequest{})
+	httpErr, ok := err.(*HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusInternalServerError, httpErr.StatusCode)
+	assert.Equal(t, "replace failed", httpErr.Message)
+
+	request = nil
+	err = c.Do(context.Background(), HTTPRoute{Method: "GET", Pattern: "/v1/rules/{name}/{owner}"},
+		&testRuleRequest{Id: 7}, response)
+	assert.Equal(t, "turbo: missing path params [name,owner] of GET /v1/rules/{name}/{owner}", err.Error())
+	assert.Nil(t, request)
+
+	v, ok := pathParamValue(reflect.ValueOf(&testRuleRequest{Id: 12, Child: &testRuleChild{Id: 3}}), "child.id")
+	assert.True(t, ok)
+	assert.Equal(t, "3", v)
+	_, ok = pathParamValue(reflect.ValueOf(&testRuleRequest{Id: 12}), "child.id")
+	assert.False(t, ok)
+}
+
+func TestDecodeHTTPError(t *testing.T) {
+	err := decodeHTTPError(&http.Response{StatusCode: 404}, []byte(`{"code":5,"message":"not found"}`)).(*HTTPError)
+	assert.Equal(t, "5", err.Code)
+	assert.Equal(t, "not found", err.Message)
+	assert.Equal(t, "turbo: http status 404, code: 5, message: not found", err.Error())
+}
+
+func TestHTTPClientRoutes(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	routes := httpClientRoutes(c)
+	assert.Equal(t, 6, len(routes))
+	assert.Equal(t, "SayHello", routes[0].FuncName)
+	assert.Equal(t, "SayHelloPost", routes[1].FuncName)
+	assert.Equal(t, "*", routes[1].Body)
+	assert.Equal(t, "UpdateRule", routes[4].FuncName)
+	assert.Equal(t, "child", routes[4].Body)
+	assert.Equal(t, "child", routes[4].ResponseBody)
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("").Parse(httpClientTemplate))
+	assert.Nil(t, tmpl.Execute(buf, map[string]interface{}{
+		"PkgPath": "github.com/vaporz/turbo/test", "ServiceName": "YourService", "Routes": routes}))
+	formatted, err := format.Source(buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, string(formatted), buf.String())
+}
@@ -0,0 +1,522 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// values of Marshaler.FieldNaming and Marshaler.EnumStyle
+const (
+	fieldNamingOriginal   = "original"
+	fieldNamingLowerCamel = "lower_camel"
+	fieldNamingGoName     = "go_name"
+	enumStyleNames        = "names"
+	enumStyleNumbers      = "numbers"
+)
+
+// indexes of field namings in jsonType.fields
+const (
+	namingOriginal = iota
+	namingLowerCamel
+	namingGoName
+	namingCount
+)
+
+// jsonField is a struct field written by encodeProto
+type jsonField struct {
+	name string
+	// key is the quoted name followed by ':'
+	key   string
+	index int
+	// enum is true if the field is a proto enum, or a thrift enum,
+	// enum lists are written as names by default, like jsonpb does
+	enum bool
+	// oneof is true if the field is a oneof, the name of the field is the name of the member which is set
+	oneof bool
+}
+
+// jsonType is the cached encoding info of a struct type
+type jsonType struct {
+	// fields are named in each naming style, sorted by name, oneof fields are at the end
+	fields [namingCount][]jsonField
+	// wellKnown is true if the struct is a google.protobuf type, which is written by jsonpb
+	wellKnown bool
+}
+
+var jsonTypes = struct {
+	sync.RWMutex
+	m map[reflect.Type]*jsonType
+}{m: make(map[reflect.Type]*jsonType)}
+
+// protoInternalFields are unexported fields of messages generated by newer versions of protoc-gen-go
+var protoInternalFields = map[string]bool{"state": true, "sizeCache": true, "unknownFields": true}
+
+// jsonTypeOf returns the encoding info of struct type 't'
+func jsonTypeOf(t reflect.Type) *jsonType {
+	jsonTypes.RLock()
+	jt, ok := jsonTypes.m[t]
+	jsonTypes.RUnlock()
+	if ok {
+		return jt
+	}
+	jt = &jsonType{}
+	if pm, ok := reflect.New(t).Interface().(proto.Message); ok {
+		jt.wellKnown = strings.HasPrefix(proto.MessageName(pm), "google.protobuf.")
+	}
+	oneofs := make([]jsonField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if _, ok := field.Tag.Lookup("protobuf_oneof"); ok {
+			oneofs = append(oneofs, jsonField{index: i, oneof: true})
+			continue
+		}
+		if strings.HasPrefix(field.Name, "XXX_") || protoInternalFields[field.Name] {
+			continue
+		}
+		f := jsonField{index: i}
+		if protoTag := strings.TrimSpace(field.Tag.Get("protobuf")); len(protoTag) > 0 {
+			var prop proto.Properties
+			prop.Parse(protoTag)
+			f.enum = len(prop.Enum) > 0
+		} else if _, ok := field.Tag.Lookup("thrift"); ok {
+			f.enum = isThriftEnum(field.Type)
+		}
+		for naming, name := range jsonFieldNamesOf(field) {
+			f.name = name
+			f.key = string(appendJSONString(nil, name)) + ":"
+			jt.fields[naming] = append(jt.fields[naming], f)
+		}
+	}
+	for naming := range jt.fields {
+		fields := jt.fields[naming]
+		sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+		jt.fields[naming] = append(fields, oneofs...)
+	}
+
+	jsonTypes.Lock()
+	jsonTypes.m[t] = jt
+	jsonTypes.Unlock()
+	return jt
+}
+
+// jsonFieldNamesOf returns the names of a field in json, in each naming style,
+// the original name is the name in .proto or .thrift, or the name in json tag, or the go name.
+func jsonFieldNamesOf(field reflect.StructField) [namingCount]string {
+	var names [namingCount]string
+	m := &Marshaler{}
+	names[namingGoName] = field.Name
+	if name, ok := m.lookupOrigNameInProtoTag(field); ok {
+		names[namingOriginal] = name
+	} else if name, ok := lookupNameInThriftTag(field); ok {
+		names[namingOriginal] = name
+	} else if name, ok := m.lookupJSONNameInProtoTag(field); ok {
+		names[namingOriginal] = name
+	} else if name, ok := m.lookupNameInJsonTag(field); ok {
+		names[namingOriginal] = name
+	} else {
+		names[namingOriginal] = field.Name
+	}
+	if name, ok := m.lookupJSONNameInProtoTag(field); ok {
+		names[namingLowerCamel] = name
+	} else {
+		names[namingLowerCamel] = lowerCamelCase(names[namingOriginal])
+	}
+	return names
+}
+
+// lookupNameInThriftTag returns the name in the tag of a struct field generated by thrift, e.g. `thrift:"name,1"`
+func lookupNameInThriftTag(field reflect.StructField) (string, bool) {
+	name := strings.TrimSpace(strings.Split(field.Tag.Get("thrift"), ",")[0])
+	return name, len(name) > 0
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+// isThriftEnum returns true if the type of a field (or an element of it) is an enum generated by thrift,
+// which is a named integer type with a String() method
+func isThriftEnum(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return len(t.PkgPath()) > 0 && t.Implements(stringerType)
+	}
+	return false
+}
+
+// styled returns true if FieldNaming is set, then "json_*" options are used
+func (m *Marshaler) styled() bool {
+	return len(m.FieldNaming) > 0
+}
+
+func (m *Marshaler) naming() int {
+	switch m.FieldNaming {
+	case fieldNamingLowerCamel:
+		return namingLowerCamel
+	case fieldNamingGoName:
+		return namingGoName
+	}
+	return namingOriginal
+}
+
+// enumAsName returns true if an enum value is written as its name
+func (m *Marshaler) enumAsName(inList bool) bool {
+	switch m.EnumStyle {
+	case enumStyleNames:
+		return true
+	case enumStyleNumbers:
+		return false
+	}
+	return inList
+}
+
+// uint64AsNumber returns true if an uint64 value is written as a number,
+// it follows Int64AsNumber if styled, or it's a number unless it's in a list, like FilterJsonWithStruct does
+func (m *Marshaler) uint64AsNumber(inList bool) bool {
+	if m.styled() {
+		return m.Int64AsNumber
+	}
+	return !inList
+}
+
+// jsonMember is a field to write, with its value
+type jsonMember struct {
+	field *jsonField
+	value reflect.Value
+}
+
+// encodeProto appends the json encoding of a proto message to b in one pass,
+// it's what FilterJsonWithStruct does to the output of jsonpb:
+// keys are original names in .proto (or names in FieldNaming style), sorted, fields added by protoc-gen-go are skipped,
+// 64-bit integers are numbers if Int64AsNumber, single (not repeated) enum and uint64 fields are numbers
+// (see enumAsName() and uint64AsNumber()),
+// zero values are written only if EmitZeroValues, nil messages are null if EmitZeroValues, or {} if not,
+// google.protobuf types, such as Timestamp, are written by jsonpb.
+func (m *Marshaler) encodeProto(b []byte, v reflect.Value) ([]byte, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return append(b, "null"...), nil
+		}
+		v = v.Elem()
+	}
+	jt := jsonTypeOf(v.Type())
+	if jt.wellKnown && v.CanAddr() && v.Addr().CanInterface() {
+		s, err := (&jsonpb.Marshaler{OrigName: true}).MarshalToString(v.Addr().Interface().(proto.Message))
+		if err != nil {
+			return b, err
+		}
+		return append(b, s...), nil
+	}
+	naming := m.naming()
+	fields := jt.fields[naming]
+	members := make([]jsonMember, 0, len(fields))
+	oneofs := 0
+	for i := range fields {
+		f := &fields[i]
+		fv := v.Field(f.index)
+		if !f.oneof {
+			if m.omitted(fv) {
+				continue
+			}
+			members = append(members, jsonMember{field: f, value: fv})
+			continue
+		}
+		// a oneof is an interface holding a pointer to a struct, the only field of which is the member set
+		if fv.IsNil() || fv.Elem().Kind() != reflect.Ptr || fv.Elem().IsNil() {
+			continue
+		}
+		wrapper := fv.Elem().Elem()
+		if wrapper.Kind() != reflect.Struct {
+			continue
+		}
+		wt := jsonTypeOf(wrapper.Type()).fields[naming]
+		if len(wt) == 0 || wt[0].oneof {
+			continue
+		}
+		members = append(members, jsonMember{field: &wt[0], value: wrapper.Field(wt[0].index)})
+		oneofs++
+	}
+	if oneofs > 0 {
+		sort.SliceStable(members, func(i, j int) bool { return members[i].field.name < members[j].field.name })
+	}
+
+	b = append(b, '{')
+	var err error
+	for i, member := range members {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, member.field.key...)
+		if b, err = m.encodeField(b, member.field, member.value); err != nil {
+			return b, err
+		}
+	}
+	return append(b, '}'), nil
+}
+
+// omitted returns true if a field is not written
+func (m *Marshaler) omitted(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr:
+		// nil messages are {} if not EmitZeroValues
+		return v.IsNil() && !m.EmitZeroValues && v.Type().Elem().Kind() != reflect.Struct
+	case reflect.Map:
+		return v.Len() == 0
+	case reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer, reflect.Array:
+		return true
+	}
+	return !m.EmitZeroValues && isZeroValue(v)
+}
+
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.String, reflect.Slice:
+		return v.Len() == 0
+	}
+	return false
+}
+
+func (m *Marshaler) encodeField(b []byte, f *jsonField, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			if m.EmitZeroValues {
+				return append(b, "null"...), nil
+			}
+			return append(b, "{}"...), nil
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			return m.encodeProto(b, v)
+		}
+		return m.encodeScalar(b, f, v.Elem(), false), nil
+	case reflect.Struct:
+		return m.encodeProto(b, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBase64(b, v.Bytes()), nil
+		}
+		b = append(b, '[')
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, err = m.encodeElement(b, f, v.Index(i), true); err != nil {
+				return b, err
+			}
+		}
+		return append(b, ']'), nil
+	case reflect.Map:
+		return m.encodeMap(b, f, v)
+	}
+	return m.encodeScalar(b, f, v, false), nil
+}
+
+// encodeElement writes an element of a list, or a value of a map
+func (m *Marshaler) encodeElement(b []byte, f *jsonField, v reflect.Value, inList bool) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Struct:
+		return m.encodeProto(b, v)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBase64(b, v.Bytes()), nil
+		}
+	}
+	return m.encodeScalar(b, f, v, inList), nil
+}
+
+// encodeMap writes a map with sorted keys, keys are strings in json
+func (m *Marshaler) encodeMap(b []byte, f *jsonField, v reflect.Value) ([]byte, error) {
+	keys := v.MapKeys()
+	names := make([]string, len(keys))
+	order := make([]int, len(keys))
+	for i, k := range keys {
+		names[i] = mapKeyString(k)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+	b = append(b, '{')
+	var err error
+	for i, k := range order {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, names[k])
+		b = append(b, ':')
+		if b, err = m.encodeElement(b, f, v.MapIndex(keys[k]), false); err != nil {
+			return b, err
+		}
+	}
+	return append(b, '}'), nil
+}
+
+func mapKeyString(k reflect.Value) string {
+	switch k.Kind() {
+	case reflect.String:
+		return k.String()
+	case reflect.Bool:
+		return strconv.FormatBool(k.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	return fmt.Sprint(k)
+}
+
+// encodeScalar writes a scalar value,
+// jsonpb writes 64-bit integers as strings and enums as names,
+// single (not repeated) uint64 and enum fields are numbers, and int64 values are numbers if Int64AsNumber,
+// see enumAsName() and uint64AsNumber().
+func (m *Marshaler) encodeScalar(b []byte, f *jsonField, v reflect.Value, inList bool) []byte {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.AppendBool(b, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f.enum {
+			if m.enumAsName(inList) && v.CanInterface() {
+				if s, ok := v.Interface().(fmt.Stringer); ok {
+					// thrift enums return "<UNSET>" for unknown values
+					name := s.String()
+					if _, err := strconv.Atoi(name); err != nil && name != "<UNSET>" {
+						return appendJSONString(b, name)
+					}
+				}
+			}
+			return strconv.AppendInt(b, v.Int(), 10)
+		}
+		if v.Kind() != reflect.Int64 || m.Int64AsNumber {
+			return strconv.AppendInt(b, v.Int(), 10)
+		}
+		b = append(b, '"')
+		b = strconv.AppendInt(b, v.Int(), 10)
+		return append(b, '"')
+	case reflect.Uint64:
+		if m.uint64AsNumber(inList) {
+			return strconv.AppendUint(b, v.Uint(), 10)
+		}
+		b = append(b, '"')
+		b = strconv.AppendUint(b, v.Uint(), 10)
+		return append(b, '"')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return strconv.AppendUint(b, v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return appendJSONFloat(b, v.Float())
+	case reflect.String:
+		return appendJSONString(b, v.String())
+	}
+	return append(b, "null"...)
+}
+
+func appendBase64(b []byte, data []byte) []byte {
+	b = append(b, '"')
+	n := len(b)
+	size := base64.StdEncoding.EncodedLen(len(data))
+	for cap(b)-n < size {
+		b = append(b[:cap(b)], 0)
+	}
+	b = b[:n+size]
+	base64.StdEncoding.Encode(b[n:], data)
+	return append(b, '"')
+}
+
+// appendJSONFloat writes a float the same way as encoding/json, NaN and Infinity are strings like jsonpb does
+func appendJSONFloat(b []byte, f float64) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(b, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(b, `"Infinity"`...)
+	case math.IsInf(f, -1):
+		return append(b, `"-Infinity"`...)
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString writes a quoted string the same way as encoding/json, with HTML characters escaped
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
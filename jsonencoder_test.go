@@ -0,0 +1,187 @@
+package turbo
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/stretchr/testify/assert"
+)
+
+type testColor int32
+
+func (c testColor) String() string {
+	switch c {
+	case 0:
+		return "RED"
+	case 1:
+		return "GREEN"
+	}
+	return "2"
+}
+
+type isTestEncoderMessage_Choice interface {
+	isTestEncoderMessage_Choice()
+}
+
+type testEncoderMessage_Label struct {
+	Label string `protobuf:"bytes,20,opt,name=label,proto3,oneof"`
+}
+
+func (*testEncoderMessage_Label) isTestEncoderMessage_Choice() {}
+
+type testEncoderMessage struct {
+	Id               int64                       `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name             string                      `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Color            testColor                   `protobuf:"varint,3,opt,name=color,proto3,enum=test.Color" json:"color,omitempty"`
+	Colors           []testColor                 `protobuf:"varint,4,rep,packed,name=colors,proto3,enum=test.Color" json:"colors,omitempty"`
+	Count            uint64                      `protobuf:"varint,5,opt,name=count,proto3" json:"count,omitempty"`
+	Counts           []uint64                    `protobuf:"varint,6,rep,packed,name=counts,proto3" json:"counts,omitempty"`
+	Ids              []int64                     `protobuf:"varint,7,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+	Ratio            float64                     `protobuf:"fixed64,8,opt,name=ratio,proto3" json:"ratio,omitempty"`
+	Data             []byte                      `protobuf:"bytes,9,opt,name=data,proto3" json:"data,omitempty"`
+	Child            *testRuleChild              `protobuf:"bytes,10,opt,name=child,proto3" json:"child,omitempty"`
+	Children         []*testRuleChild            `protobuf:"bytes,11,rep,name=children,proto3" json:"children,omitempty"`
+	Labels           map[string]int64            `protobuf:"bytes,12,rep,name=labels,proto3" json:"labels,omitempty"`
+	Choice           isTestEncoderMessage_Choice `protobuf_oneof:"choice"`
+	XXX_unrecognized []byte                      `json:"-"`
+	XXX_sizecache    int32                       `json:"-"`
+}
+
+func (t *testEncoderMessage) Reset()         { *t = testEncoderMessage{} }
+func (t *testEncoderMessage) String() string { return "" }
+func (t *testEncoderMessage) ProtoMessage()  {}
+
+func TestEncodeProto(t *testing.T) {
+	v := &testEncoderMessage{
+		Id:       12,
+		Name:     "<turbo>",
+		Color:    1,
+		Colors:   []testColor{0, 1, 2},
+		Count:    3,
+		Counts:   []uint64{4},
+		Ids:      []int64{5, 6},
+		Ratio:    0.5,
+		Data:     []byte("hi"),
+		Children: []*testRuleChild{{Title: "a"}, nil},
+		Labels:   map[string]int64{"b": 2, "a": 1},
+		Choice:   &testEncoderMessage_Label{Label: "x"},
+	}
+	m := Marshaler{FilterProtoJson: true}
+	b, err := m.JSON(v)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"child":{},"children":[{"title":"a"},null],"color":1,"colors":["RED","GREEN",2],`+
+		`"count":3,"counts":["4"],"data":"aGk=","id":"12","ids":["5","6"],"label":"x","labels":{"a":"1","b":"2"},`+
+		`"name":"\u003cturbo\u003e","ratio":0.5}`, string(b))
+
+	m.Int64AsNumber = true
+	b, _ = m.JSON(v)
+	assert.Contains(t, string(b), `"id":12,"ids":[5,6],"label":"x","labels":{"a":1,"b":2}`)
+
+	m = Marshaler{FilterProtoJson: true, EmitZeroValues: true}
+	b, _ = m.JSON(&testEncoderMessage{})
+	assert.Equal(t, `{"child":null,"children":[],"color":0,"colors":[],"count":0,"counts":[],"data":"",`+
+		`"id":"0","ids":[],"name":"","ratio":0}`, string(b))
+
+	m = Marshaler{FilterProtoJson: true}
+	b, _ = m.JSON(&testEncoderMessage{})
+	assert.Equal(t, `{"child":{}}`, string(b))
+}
+
+// testThriftColor and testThriftResponse look like types generated by thrift
+type testThriftColor int64
+
+func (c testThriftColor) String() string {
+	if c == 1 {
+		return "GREEN"
+	}
+	return "<UNSET>"
+}
+
+type testThriftResponse struct {
+	TransactionId int64             `thrift:"transactionId,1" json:"transactionId"`
+	UserName      string            `thrift:"user_name,2" json:"user_name"`
+	Color         testThriftColor   `thrift:"color,3" json:"color"`
+	Colors        []testThriftColor `thrift:"colors,4" json:"colors"`
+	Nickname      *string           `thrift:"nickname,5" json:"nickname,omitempty"`
+}
+
+func TestMarshalerStyles(t *testing.T) {
+	v := &testEncoderMessage{Id: 1, Color: 1, Colors: []testColor{1}, Count: 2, Counts: []uint64{3}, Choice: &testEncoderMessage_Label{Label: "x"}}
+	m := Marshaler{FieldNaming: "go_name", EnumStyle: "names", Int64AsNumber: true}
+	b, err := m.JSON(v)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Child":{},"Color":"GREEN","Colors":["GREEN"],"Count":2,"Counts":[3],"Id":1,"Label":"x"}`, string(b))
+
+	m = Marshaler{FieldNaming: "lower_camel", EnumStyle: "numbers"}
+	b, _ = m.JSON(&testThriftResponse{TransactionId: 7, UserName: "a", Color: 1, Colors: []testThriftColor{1, 2}})
+	assert.Equal(t, `{"color":1,"colors":[1,2],"transactionId":"7","userName":"a"}`, string(b))
+
+	m = Marshaler{FieldNaming: "original", EmitZeroValues: true, Int64AsNumber: true}
+	b, _ = m.JSON(&testThriftResponse{Colors: []testThriftColor{1, 2}})
+	assert.Equal(t, `{"color":0,"colors":["GREEN",2],"nickname":null,"transactionId":0,"user_name":""}`, string(b))
+
+	// thrift responses are written by encoding/json unless FieldNaming is set
+	m = Marshaler{FilterProtoJson: true}
+	b, _ = m.JSON(&testThriftResponse{})
+	assert.Equal(t, `{"transactionId":0,"user_name":"","color":0,"colors":null}`, string(b))
+}
+
+func TestAppendJSON(t *testing.T) {
+	for _, s := range []string{"", "a\"b\\c", "<&>", "\n\r\t\x01", "中文", "\u2028\u2029", "\xff"} {
+		expected, _ := json.Marshal(s)
+		assert.Equal(t, string(expected), string(appendJSONString(nil, s)))
+	}
+	for _, f := range []float64{0, 1, -1.5, 0.1, 1e-7, 1e21, 123456789, float64(float32(0.1))} {
+		expected, _ := json.Marshal(f)
+		assert.Equal(t, string(expected), string(appendJSONFloat(nil, f)))
+	}
+	assert.Equal(t, `"NaN"`, string(appendJSONFloat(nil, math.NaN())))
+	assert.Equal(t, `"-Infinity"`, string(appendJSONFloat(nil, math.Inf(-1))))
+}
+
+type testBenchMessage struct {
+	Id       int64            `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name     string           `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Ids      []int64          `protobuf:"varint,3,rep,packed,name=ids,proto3" json:"ids,omitempty"`
+	Ratio    float64          `protobuf:"fixed64,4,opt,name=ratio,proto3" json:"ratio,omitempty"`
+	Child    *testRuleChild   `protobuf:"bytes,5,opt,name=child,proto3" json:"child,omitempty"`
+	Children []*testRuleChild `protobuf:"bytes,6,rep,name=children,proto3" json:"children,omitempty"`
+}
+
+func (t *testBenchMessage) Reset()         { *t = testBenchMessage{} }
+func (t *testBenchMessage) String() string { return "" }
+func (t *testBenchMessage) ProtoMessage()  {}
+
+func newTestBenchMessage() *testBenchMessage {
+	return &testBenchMessage{
+		Id:       1234567,
+		Name:     "turbo",
+		Ids:      []int64{1, 2, 3, 4, 5},
+		Ratio:    0.25,
+		Child:    &testRuleChild{Title: "child"},
+		Children: []*testRuleChild{{Title: "a"}, {Title: "b"}, {Title: "c"}},
+	}
+}
+
+func BenchmarkMarshalerJSON(b *testing.B) {
+	m := Marshaler{FilterProtoJson: true, EmitZeroValues: true, Int64AsNumber: true}
+	v := newTestBenchMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.JSON(v)
+	}
+}
+
+func BenchmarkFilterJsonWithStruct(b *testing.B) {
+	m := Marshaler{FilterProtoJson: true, EmitZeroValues: true, Int64AsNumber: true}
+	v := newTestBenchMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var buf bytes.Buffer
+		(&jsonpb.Marshaler{OrigName: true}).Marshal(&buf, v)
+		m.FilterJsonWithStruct(buf.Bytes(), v)
+	}
+}
@@ -0,0 +1,552 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// jwtConfig is the "jwt" section in config file, e.g.
+//
+//	jwt:
+//	  hmac_secret_file: keys/hs256.secret
+//	  public_key_files: [keys/rs256.pem, keys/es256.pem]
+//	  jwks_file: keys/jwks.json
+//	  issuer: https://auth.example.com
+//	  audience: [api]
+//	  leeway: 30s
+//	  claims:
+//	    - sub user_id
+//	  metadata:
+//	    - sub x-user-id
+//	  routes:
+//	    - route: GET,POST /v1/
+//	    - route: /hello
+//	      audience: [hello]
+//	      optional: true
+//
+// Tokens in "Authorization: Bearer" header are verified for requests matching "routes", the same way as
+// component mappings do, "issuer" and "audience" of a route override those of the section.
+// HS256 tokens are verified with the secret, RS256 and ES256 tokens with public keys in PEM files,
+// keys in "jwks_file" are reloaded when the file changes, so keys can be rotated without restarting,
+// the "kid" of a key file is its name without extension. Relative paths are relative to [service_root_path].
+// "exp" is required, an "optional" route accepts requests without a token.
+// Each line in "claims" is "[claim] [name]", the claim is bound to the request field of that name,
+// it wins over query params, form params and json request bodies, see FindValue,
+// each line in "metadata" is "[claim] [metadata key]", the claim is sent to grpc backends in metadata.
+type jwtConfig struct {
+	HMACSecretFile string     `mapstructure:"hmac_secret_file"`
+	PublicKeyFiles []string   `mapstructure:"public_key_files"`
+	JWKSFile       string     `mapstructure:"jwks_file"`
+	Issuer         string     `mapstructure:"issuer"`
+	Audience       []string   `mapstructure:"audience"`
+	Leeway         string     `mapstructure:"leeway"`
+	Claims         []string   `mapstructure:"claims"`
+	Metadata       []string   `mapstructure:"metadata"`
+	Routes         []jwtRoute `mapstructure:"routes"`
+}
+
+// jwtRoute is an item in "routes" of the "jwt" section
+type jwtRoute struct {
+	Route    string   `mapstructure:"route"`
+	Issuer   string   `mapstructure:"issuer"`
+	Audience []string `mapstructure:"audience"`
+	Optional bool     `mapstructure:"optional"`
+	verifier *jwtVerifier
+}
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (r *jwtRoute) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// jwtKey is a []byte for HS256, a *rsa.PublicKey for RS256, or an *ecdsa.PublicKey for ES256
+type jwtKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+type jwtVerifier struct {
+	keys     []jwtKey
+	leeway   time.Duration
+	claims   [][2]string
+	metadata [][2]string
+
+	jwksFile    string
+	mutex       sync.RWMutex
+	jwks        []jwtKey
+	jwksModTime time.Time
+	jwksChecked time.Time
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaims returns claims of the verified token of a request, or nil if there is none
+func JWTClaims(ctx context.Context) map[string]interface{} {
+	if claims, ok := ctx.Value(jwtClaimsKey{}).(map[string]interface{}); ok {
+		return claims
+	}
+	return nil
+}
+
+// loadJWT loads the "jwt" section, jwtRoutes is nil if there is no such section
+func (c *Config) loadJWT() {
+	c.jwtRoutes = nil
+	if !c.IsSet("jwt") {
+		return
+	}
+	conf := &jwtConfig{}
+	panicIf(c.UnmarshalKey("jwt", conf))
+	v, err := c.newJWTVerifier(conf)
+	panicIf(err)
+	for i := range conf.Routes {
+		route := &conf.Routes[i]
+		methods, path := parseRoute(route.Route)
+		if len(path) == 0 {
+			panic("turbo: invalid jwt route: " + route.Route)
+		}
+		if len(route.Issuer) == 0 {
+			route.Issuer = conf.Issuer
+		}
+		if route.Audience == nil {
+			route.Audience = conf.Audience
+		}
+		route.verifier = v
+		c.jwtRoutes = setComponent(c.jwtRoutes, methods, path, route)
+	}
+}
+
+func (c *Config) newJWTVerifier(conf *jwtConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{}
+	var err error
+	if len(conf.Leeway) > 0 {
+		if v.leeway, err = time.ParseDuration(conf.Leeway); err != nil {
+			return nil, errors.New("turbo: invalid jwt leeway: " + conf.Leeway)
+		}
+	}
+	if v.claims, err = claimMappings(conf.Claims); err != nil {
+		return nil, err
+	}
+	if v.metadata, err = claimMappings(conf.Metadata); err != nil {
+		return nil, err
+	}
+	if len(conf.HMACSecretFile) > 0 {
+		secret, err := ioutil.ReadFile(c.absolutePath(conf.HMACSecretFile))
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, jwtKey{kid: keyID(conf.HMACSecretFile), alg: "HS256", key: bytes.TrimSpace(secret)})
+	}
+	for _, file := range conf.PublicKeyFiles {
+		keys, err := publicKeysOf(c.absolutePath(file))
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if len(conf.JWKSFile) > 0 {
+		v.jwksFile = c.absolutePath(conf.JWKSFile)
+		if err := v.reloadJWKS(time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if len(v.keys) == 0 && len(v.jwksFile) == 0 {
+		return nil, errors.New("turbo: no key for jwt, set 'hmac_secret_file', 'public_key_files' or 'jwks_file'")
+	}
+	return v, nil
+}
+
+// absolutePath returns the path relative to [service_root_path], if it's not absolute
+func (c *Config) absolutePath(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return c.ServiceRootPathAbsolute() + "/" + p
+}
+
+func keyID(file string) string {
+	name := filepath.Base(file)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func claimMappings(lines []string) ([][2]string, error) {
+	mappings := make([][2]string, 0)
+	for _, line := range lines {
+		values := strings.Fields(line)
+		if len(values) != 2 {
+			return nil, errors.New("turbo: invalid jwt claim mapping: '" + line + "', should be '[claim] [name]'")
+		}
+		mappings = append(mappings, [2]string{values[0], values[1]})
+	}
+	return mappings, nil
+}
+
+// publicKeysOf parses RSA and ECDSA public keys, or certificates, in a PEM file
+func publicKeysOf(file string) ([]jwtKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]jwtKey, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var pub interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				pub = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.New("turbo: invalid public key in " + file + ", error: " + err.Error())
+		}
+		key, ok := newJWTKey(keyID(file), pub)
+		if !ok {
+			return nil, errors.New("turbo: unsupported public key in " + file + ", RSA or ECDSA P-256 key is expected")
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("turbo: no public key found in " + file)
+	}
+	return keys, nil
+}
+
+func newJWTKey(kid string, pub interface{}) (jwtKey, bool) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwtKey{kid: kid, alg: "RS256", key: k}, true
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return jwtKey{kid: kid, alg: "ES256", key: k}, true
+		}
+	}
+	return jwtKey{}, false
+}
+
+// reloadJWKS reloads keys in jwksFile if it's modified, it's checked at most once per second,
+// keys loaded before are kept if the file is invalid.
+func (v *jwtVerifier) reloadJWKS(now time.Time) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if now.Sub(v.jwksChecked) < time.Second && v.jwks != nil {
+		return nil
+	}
+	v.jwksChecked = now
+	info, err := os.Stat(v.jwksFile)
+	if err != nil {
+		return err
+	}
+	if v.jwks != nil && info.ModTime().Equal(v.jwksModTime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(v.jwksFile)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return errors.New("turbo: invalid jwks file " + v.jwksFile + ", error: " + err.Error())
+	}
+	v.jwks, v.jwksModTime = keys, info.ModTime()
+	return nil
+}
+
+// parseJWKS parses RSA, EC(P-256) and oct keys for signing in a JSON Web Key Set
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]jwtKey, 0)
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		var key jwtKey
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return nil, errors.New("invalid RSA key: " + k.Kid)
+			}
+			key, _ = newJWTKey(k.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())})
+		case "EC":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if k.Crv != "P-256" || err1 != nil || err2 != nil {
+				return nil, errors.New("invalid EC key: " + k.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				return nil, errors.New("invalid EC key: " + k.Kid)
+			}
+			key, _ = newJWTKey(k.Kid, pub)
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, errors.New("invalid oct key: " + k.Kid)
+			}
+			key = jwtKey{kid: k.Kid, alg: "HS256", key: secret}
+		default:
+			continue
+		}
+		if len(k.Alg) > 0 && k.Alg != key.alg {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// keysOf returns keys for 'alg', those with 'kid' if there are any
+func (v *jwtVerifier) keysOf(alg, kid string) []jwtKey {
+	all := v.keys
+	if len(v.jwksFile) > 0 {
+		if err := v.reloadJWKS(time.Now()); err != nil {
+			log.Error("failed to reload jwks, err=", err)
+		}
+		v.mutex.RLock()
+		all = append(append([]jwtKey{}, all...), v.jwks...)
+		v.mutex.RUnlock()
+	}
+	keys, matched := make([]jwtKey, 0), make([]jwtKey, 0)
+	for _, k := range all {
+		if k.alg != alg {
+			continue
+		}
+		keys = append(keys, k)
+		if len(kid) > 0 && k.kid == kid {
+			matched = append(matched, k)
+		}
+	}
+	if len(matched) > 0 {
+		return matched
+	}
+	return keys
+}
+
+// verify verifies the signature and claims of a token, and returns the claims
+func (v *jwtVerifier) verify(token string, route *jwtRoute, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	if !v.verifySignature(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, errors.New("invalid signature")
+	}
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	exp, ok := numericClaim(claims["exp"])
+	if !ok {
+		return nil, errors.New("token has no expiry")
+	}
+	if now.Add(-v.leeway).Unix() >= exp {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := numericClaim(claims["nbf"]); ok && now.Add(v.leeway).Unix() < nbf {
+		return nil, errors.New("token is not valid yet")
+	}
+	if len(route.Issuer) > 0 && claims["iss"] != route.Issuer {
+		return nil, errors.New("invalid issuer")
+	}
+	if len(route.Audience) > 0 && !audienceMatched(claims["aud"], route.Audience) {
+		return nil, errors.New("invalid audience")
+	}
+	return claims, nil
+}
+
+func (v *jwtVerifier) verifySignature(alg, kid string, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	for _, k := range v.keysOf(alg, kid) {
+		switch key := k.key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, key)
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if len(sig) == 64 && ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func numericClaim(v interface{}) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return int64(f), err == nil
+}
+
+func audienceMatched(aud interface{}, audience []string) bool {
+	values := make([]interface{}, 0)
+	switch a := aud.(type) {
+	case string:
+		values = append(values, a)
+	case []interface{}:
+		values = a
+	}
+	for _, v := range values {
+		for _, expected := range audience {
+			if v == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString returns a claim as a string, numbers are kept as they are in the token, objects are in JSON
+func claimString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number, bool:
+		return fmt.Sprint(x)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[7:])
+	return token, len(token) > 0
+}
+
+// jwtAuthenticated verifies the bearer token of a request if its route is in the "jwt" section,
+// claims are put into the request context, and grpc metadata, see jwtConfig.
+// It responds with 401 and returns false if the token is missing or invalid.
+func jwtAuthenticated(s Servable, resp http.ResponseWriter, req *http.Request) bool {
+	cp := component(s.ServerField().Config.jwtRoutes, req)
+	if cp == nil {
+		return true
+	}
+	route := cp.(*jwtRoute)
+	token, ok := bearerToken(req)
+	if !ok {
+		if route.Optional {
+			return true
+		}
+		resp.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(resp, "turbo: missing bearer token", http.StatusUnauthorized)
+		return false
+	}
+	claims, err := route.verifier.verify(token, route, time.Now())
+	if err != nil {
+		resp.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+err.Error()+`"`)
+		http.Error(resp, "turbo: "+err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	ctx := context.WithValue(req.Context(), jwtClaimsKey{}, claims)
+	if sub, ok := claims["sub"]; ok && sub != nil {
+		ctx = context.WithValue(ctx, principalKey{}, Principal{Name: claimString(sub), Provider: authJWT})
+	}
+	for _, m := range route.verifier.claims {
+		if v, ok := claims[m[0]]; ok && v != nil {
+			ctx = bindValue(ctx, m[1], claimString(v))
+		}
+	}
+	pairs := make([]string, 0)
+	for _, m := range route.verifier.metadata {
+		if v, ok := claims[m[0]]; ok && v != nil {
+			pairs = append(pairs, m[1], claimString(v))
+		}
+	}
+	if len(pairs) > 0 {
+		md := metadata.Pairs(pairs...)
+		if outgoing, ok := metadata.FromOutgoingContext(ctx); ok {
+			md = metadata.Join(outgoing, md)
+		}
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+	*req = *req.WithContext(ctx)
+	return true
+}
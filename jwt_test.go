@@ -0,0 +1,201 @@
+package turbo
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func signTestJWT(alg, kid string, key interface{}, claims string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	signed := enc([]byte(`{"alg":"`+alg+`","kid":"`+kid+`"}`)) + "." + enc([]byte(claims))
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + enc(sig)
+}
+
+func writeTestPublicKey(t *testing.T, file string, pub interface{}) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+}
+
+func TestJWTVerify(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_jwt")
+	defer os.RemoveAll(dir)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("turbo-secret")
+	writeTestPublicKey(t, dir+"/rs.pem", &rsaKey.PublicKey)
+	writeTestPublicKey(t, dir+"/es.pem", &ecKey.PublicKey)
+	ioutil.WriteFile(dir+"/hs.secret", append(secret, '\n'), 0600)
+
+	c := &Config{}
+	v, err := c.newJWTVerifier(&jwtConfig{HMACSecretFile: dir + "/hs.secret",
+		PublicKeyFiles: []string{dir + "/rs.pem", dir + "/es.pem"}, Leeway: "10s"})
+	assert.Nil(t, err)
+	route := &jwtRoute{Issuer: "turbo", Audience: []string{"api", "web"}}
+	now := time.Now()
+	exp := strconv.FormatInt(now.Unix()+60, 10)
+	valid := `{"iss":"turbo","aud":["web"],"exp":` + exp + `,"sub":"alice","uid":12345678901}`
+	for _, token := range []string{
+		signTestJWT("HS256", "", secret, valid),
+		signTestJWT("RS256", "rs", rsaKey, valid),
+		signTestJWT("ES256", "other", ecKey, valid),
+	} {
+		claims, err := v.verify(token, route, now)
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", claims["sub"])
+		assert.Equal(t, "12345678901", claimString(claims["uid"]))
+	}
+	cases := map[string]string{
+		signTestJWT("HS256", "", []byte("wrong"), valid):                                            "invalid signature",
+		signTestJWT("none", "", secret, valid):                                                      "invalid signature",
+		signTestJWT("RS256", "", secret, valid):                                                     "invalid signature",
+		signTestJWT("HS256", "", secret, `{"iss":"turbo","aud":"api"}`):                             "token has no expiry",
+		signTestJWT("HS256", "", secret, `{"iss":"turbo","aud":"api","exp":1}`):                     "token is expired",
+		signTestJWT("HS256", "", secret, `{"iss":"x","aud":"api","exp":`+exp+`}`):                   "invalid issuer",
+		signTestJWT("HS256", "", secret, `{"iss":"turbo","aud":"admin","exp":`+exp+`}`):             "invalid audience",
+		signTestJWT("HS256", "", secret, `{"aud":"api","iss":"turbo","exp":`+exp+`,"nbf":`+exp+`}`): "token is not valid yet",
+		"a.b": "malformed token",
+	}
+	for token, msg := range cases {
+		_, err := v.verify(token, route, now)
+		if assert.NotNil(t, err, msg) {
+			assert.Equal(t, msg, err.Error())
+		}
+	}
+	// leeway
+	_, err = v.verify(signTestJWT("HS256", "", secret, `{"exp":`+strconv.FormatInt(now.Unix()-5, 10)+`}`), &jwtRoute{}, now)
+	assert.Nil(t, err)
+}
+
+func TestJWKSRotation(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_jwks")
+	defer os.RemoveAll(dir)
+	enc := base64.RawURLEncoding.EncodeToString
+	key1, key2 := []byte("key-1"), []byte("key-2")
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	file := dir + "/jwks.json"
+	ioutil.WriteFile(file, []byte(`{"keys":[{"kty":"oct","kid":"1","k":"`+enc(key1)+`"},`+
+		`{"kty":"EC","crv":"P-256","kid":"ec","x":"`+enc(ecKey.X.Bytes())+`","y":"`+enc(ecKey.Y.Bytes())+`"},`+
+		`{"kty":"RSA","kid":"rsa","use":"sig","n":"`+enc(rsaKey.N.Bytes())+`","e":"AQAB"},`+
+		`{"kty":"RSA","kid":"enc","use":"enc","n":"`+enc(rsaKey.N.Bytes())+`","e":"AQAB"}]}`), 0644)
+	v, err := (&Config{}).newJWTVerifier(&jwtConfig{JWKSFile: file})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(v.jwks))
+	now := time.Now()
+	claims := `{"exp":` + strconv.FormatInt(now.Unix()+60, 10) + `}`
+	_, err = v.verify(signTestJWT("HS256", "1", key1, claims), &jwtRoute{}, now)
+	assert.Nil(t, err)
+	_, err = v.verify(signTestJWT("ES256", "ec", ecKey, claims), &jwtRoute{}, now)
+	assert.Nil(t, err)
+	_, err = v.verify(signTestJWT("RS256", "rsa", rsaKey, claims), &jwtRoute{}, now)
+	assert.Nil(t, err)
+
+	ioutil.WriteFile(file, []byte(`{"keys":[{"kty":"oct","kid":"2","k":"`+enc(key2)+`"}]}`), 0644)
+	os.Chtimes(file, now.Add(time.Minute), now.Add(time.Minute))
+	v.jwksChecked = time.Time{}
+	_, err = v.verify(signTestJWT("HS256", "1", key1, claims), &jwtRoute{}, now)
+	assert.NotNil(t, err)
+	_, err = v.verify(signTestJWT("HS256", "2", key2, claims), &jwtRoute{}, now)
+	assert.Nil(t, err)
+
+	// invalid files are ignored
+	ioutil.WriteFile(file, []byte(`{`), 0644)
+	os.Chtimes(file, now.Add(2*time.Minute), now.Add(2*time.Minute))
+	v.jwksChecked = time.Time{}
+	_, err = v.verify(signTestJWT("HS256", "2", key2, claims), &jwtRoute{}, now)
+	assert.Nil(t, err)
+}
+
+func TestJWTAuthentication(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_jwt")
+	defer os.RemoveAll(dir)
+	secret := []byte("turbo-secret")
+	ioutil.WriteFile(dir+"/hs.secret", secret, 0600)
+	s := newTestServerWith(map[string]interface{}{"jwt": map[string]interface{}{
+		"hmac_secret_file": dir + "/hs.secret",
+		"audience":         []string{"api"},
+		"claims":           []string{"sub name", "tenantId tenant"},
+		"metadata":         []string{"sub x-user-id"},
+		"routes": []map[string]interface{}{
+			{"route": "GET /v1/rules/"},
+			{"route": "/hello", "audience": []string{"hello"}, "optional": true},
+		},
+	}})
+	var md metadata.MD
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		md, _ = metadata.FromOutgoingContext(req.Context())
+		v := &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequest(s, v, req)
+		return v, err
+	}
+	get := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		router(s).ServeHTTP(resp, req)
+		return resp
+	}
+	exp := strconv.FormatInt(time.Now().Unix()+60, 10)
+
+	resp := get("/v1/rules/12", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "Bearer", resp.Header().Get("WWW-Authenticate"))
+	resp = get("/v1/rules/12", signTestJWT("HS256", "", secret, `{"aud":"hello","exp":`+exp+`}`))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, `Bearer error="invalid_token", error_description="invalid audience"`, resp.Header().Get("WWW-Authenticate"))
+
+	resp = get("/v1/rules/12", signTestJWT("HS256", "", secret, `{"aud":"api","exp":`+exp+`,"sub":"alice"}`))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"name":"alice"`)
+	assert.Equal(t, []string{"alice"}, md["x-user-id"])
+
+	// claims win over values sent by clients
+	resp = get("/v1/rules/12?name=victim", signTestJWT("HS256", "", secret, `{"aud":"api","exp":`+exp+`,"sub":"alice"}`))
+	assert.Contains(t, resp.Body.String(), `"name":"alice"`)
+	req := httptest.NewRequest("POST", "/hello?name=victim", strings.NewReader(`{"name":"victim"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+signTestJWT("HS256", "", secret, `{"aud":"hello","exp":`+exp+`,"sub":"alice"}`))
+	resp = httptest.NewRecorder()
+	router(s).ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"name":"alice"`)
+}
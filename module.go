@@ -0,0 +1,108 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// goModule is a go module found by walking up from a directory
+type goModule struct {
+	// Dir is the directory containing "go.mod"
+	Dir string
+	// Path is the module path declared in "go.mod"
+	Path string
+}
+
+// findModule returns the module containing 'dir', or nil if there's no "go.mod" in 'dir' or its parents
+func findModule(dir string) *goModule {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil
+	}
+	for {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			if p := modulePath(data); len(p) > 0 {
+				return &goModule{Dir: dir, Path: p}
+			}
+			return nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// modulePath returns the path in the "module" directive of a go.mod file
+func modulePath(goMod []byte) string {
+	for _, line := range strings.Split(string(goMod), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if p, err := strconv.Unquote(fields[1]); err == nil {
+			return p
+		}
+		return fields[1]
+	}
+	return ""
+}
+
+// dir returns the directory of package 'pkgPath' if it's in this module
+func (m *goModule) dir(pkgPath string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	if pkgPath == m.Path {
+		return m.Dir, true
+	}
+	if strings.HasPrefix(pkgPath, m.Path+"/") {
+		return filepath.Join(m.Dir, filepath.FromSlash(strings.TrimPrefix(pkgPath, m.Path+"/"))), true
+	}
+	return "", false
+}
+
+// PackageDir returns the directory of package 'pkgPath',
+// it's found in the module containing the working directory, or in $GOPATH/src if it's not in that module.
+func PackageDir(pkgPath string) string {
+	wd, _ := os.Getwd()
+	return packageDir(pkgPath, wd)
+}
+
+// packageDir finds 'pkgPath' in the module containing 'dir', and falls back to $GOPATH/src
+func packageDir(pkgPath, dir string) string {
+	if d, ok := findModule(dir).dir(pkgPath); ok {
+		return d
+	}
+	return GOPATH() + "/src/" + pkgPath
+}
+
+// inGOPATH returns true if projects should be created in $GOPATH/src,
+// that's when modules are turned off, or the working directory is in $GOPATH/src.
+func inGOPATH() bool {
+	if os.Getenv("GO111MODULE") == "off" {
+		return true
+	}
+	if len(GOPATH()) == 0 {
+		return false
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	src := filepath.Join(GOPATH(), "src")
+	return wd == src || strings.HasPrefix(wd, src+string(filepath.Separator))
+}
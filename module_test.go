@@ -0,0 +1,61 @@
+package turbo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModulePath(t *testing.T) {
+	assert.Equal(t, "github.com/a/b", modulePath([]byte("// comment\nmodule github.com/a/b // comment\n\ngo 1.12\n")))
+	assert.Equal(t, "github.com/a/b", modulePath([]byte(`module "github.com/a/b"`)))
+	assert.Equal(t, "", modulePath([]byte("go 1.12\n")))
+}
+
+func TestPackageDirInModule(t *testing.T) {
+	root, _ := ioutil.TempDir("", "turbo_module")
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	os.MkdirAll(root+"/svc/gen", 0755)
+	ioutil.WriteFile(root+"/go.mod", []byte("module example.com/app\n"), 0644)
+	ioutil.WriteFile(root+"/svc/service.yaml", []byte("config:\n  service_root_path: example.com/app/svc\n"), 0644)
+
+	m := findModule(root + "/svc/gen")
+	assert.Equal(t, &goModule{Dir: root, Path: "example.com/app"}, m)
+	assert.Equal(t, root+"/svc", packageDir("example.com/app/svc", root+"/svc"))
+	assert.Equal(t, root, packageDir("example.com/app", root))
+	assert.Equal(t, GOPATH()+"/src/example.com/application", packageDir("example.com/application", root))
+
+	c := NewConfig("grpc", root+"/svc/service.yaml")
+	assert.Equal(t, root+"/svc", c.ServiceRootPathAbsolute())
+}
+
+func TestCreatorProjectDir(t *testing.T) {
+	root, _ := ioutil.TempDir("", "turbo_module")
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+	ioutil.WriteFile(root+"/go.mod", []byte("module example.com/app\n"), 0644)
+	os.Mkdir(root+"/other", 0755)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	os.Chdir(root)
+	c := &Creator{PkgPath: "example.com/app/svc"}
+	dir, newModule := c.projectDir()
+	assert.Equal(t, root+"/svc", dir)
+	assert.False(t, newModule)
+
+	os.Chdir(root + "/other")
+	c = &Creator{PkgPath: "example.com/svc"}
+	dir, newModule = c.projectDir()
+	if !inGOPATH() {
+		assert.Equal(t, root+"/other/svc", dir)
+		assert.True(t, newModule)
+	}
+	os.Mkdir(dir, 0755)
+	c.createGoMod(dir)
+	assert.Equal(t, &goModule{Dir: dir, Path: "example.com/svc"}, findModule(dir))
+}
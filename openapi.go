@@ -0,0 +1,331 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// openAPIDocument is an OpenAPI 3 document, only the parts turbo generates are declared
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Style    string         `json:"style,omitempty"`
+	Explode  *bool          `json:"explode,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Content map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// openAPIBuilder builds an OpenAPI document from "urlmapping" and the schema of rpc methods
+type openAPIBuilder struct {
+	c      *Config
+	schema *apiSchema
+	// int64AsString is true if int64 values are strings in responses, which is the behavior of jsonpb
+	int64AsString bool
+	// enumAsString is true if enum values are names in responses, which is the behavior of jsonpb
+	enumAsString bool
+}
+
+func newOpenAPIBuilder(c *Config, schema *apiSchema) *openAPIBuilder {
+	b := &openAPIBuilder{c: c, schema: schema}
+	if RpcType == "grpc" {
+		b.int64AsString = !c.FilterProtoJsonInt64AsNumber()
+		b.enumAsString = !c.FilterProtoJson()
+	}
+	return b
+}
+
+func (b *openAPIBuilder) build() *openAPIDocument {
+	title := b.c.GrpcServiceName()
+	if RpcType == "thrift" {
+		title = b.c.ThriftServiceName()
+	}
+	doc := &openAPIDocument{
+		OpenAPI:    "3.0.0",
+		Info:       openAPIInfo{Title: title, Version: "1.0.0"},
+		Paths:      make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{Schemas: make(map[string]*openAPISchema)},
+	}
+	for name, m := range b.schema.Messages {
+		doc.Components.Schemas[name] = b.messageSchema(m)
+	}
+	for name, values := range b.schema.Enums {
+		doc.Components.Schemas[name] = b.enumSchema(values)
+	}
+	for _, m := range b.c.mappings[urlServiceMaps] {
+		path, pathParams := openAPIPath(m[1])
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+		for _, httpMethod := range strings.Split(m[0], ",") {
+			doc.Paths[path][strings.ToLower(httpMethod)] = b.operation(httpMethod, m[1], m[2], pathParams)
+		}
+	}
+	return doc
+}
+
+func (b *openAPIBuilder) operation(httpMethod, urlPattern, methodName string, pathParams []*openAPIParameter) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: methodName,
+		Parameters:  append([]*openAPIParameter{}, pathParams...),
+		Responses: map[string]*openAPIResponse{
+			"default": {Description: "error"},
+		},
+	}
+	method, ok := b.schema.Methods[methodName]
+	if !ok {
+		op.Responses["200"] = &openAPIResponse{Description: "OK"}
+		return op
+	}
+	request := b.schema.message(method.Request)
+	rule, hasRule := b.c.httpRules[httpMethod+" "+urlPattern]
+	body := ""
+	if hasRule {
+		body = rule.body
+	} else if httpMethod != "GET" && httpMethod != "DELETE" && httpMethod != "HEAD" {
+		body = "*"
+	}
+	if body != "*" || !hasRule {
+		op.Parameters = append(op.Parameters, b.queryParameters(request, pathParams, body)...)
+	}
+	if len(body) > 0 && request != nil {
+		var bodySchema *openAPISchema
+		if body == "*" {
+			bodySchema = b.requestBodySchema(request)
+		} else if f := fieldByName(request, body); f != nil {
+			bodySchema = b.fieldSchema(f)
+		}
+		if bodySchema != nil {
+			op.RequestBody = &openAPIRequestBody{Content: map[string]*openAPIMediaType{
+				"application/json": {Schema: bodySchema}}}
+		}
+	}
+	responseSchema := &openAPISchema{Type: "object"}
+	if len(method.Response) > 0 {
+		responseSchema = &openAPISchema{Ref: "#/components/schemas/" + method.Response}
+	}
+	if response := b.schema.message(method.Response); hasRule && len(rule.responseBody) > 0 && response != nil {
+		if f := fieldByName(response, rule.responseBody); f != nil {
+			responseSchema = b.fieldSchema(f)
+		}
+	}
+	op.Responses["200"] = &openAPIResponse{
+		Description: "OK",
+		Content:     map[string]*openAPIMediaType{"application/json": {Schema: responseSchema}},
+	}
+	return op
+}
+
+// requestBodySchema returns the schema of a json request body,
+// for thrift, the json body is unmarshaled into the first argument.
+func (b *openAPIBuilder) requestBodySchema(request *apiMessage) *openAPISchema {
+	if RpcType == "thrift" {
+		if len(request.Fields) > 0 && request.Fields[0].Type == "message" {
+			return b.fieldSchema(request.Fields[0])
+		}
+		return nil
+	}
+	return &openAPISchema{Ref: "#/components/schemas/" + request.Name}
+}
+
+// queryParameters flattens a request message into query parameters, the same way BuildStruct finds values,
+// nested messages are flattened, field names are snake_case, and lists are comma separated.
+func (b *openAPIBuilder) queryParameters(request *apiMessage, pathParams []*openAPIParameter, body string) []*openAPIParameter {
+	params := make([]*openAPIParameter, 0)
+	if request == nil {
+		return params
+	}
+	seen := make(map[string]bool)
+	for _, p := range pathParams {
+		seen[strings.ToLower(p.Name)] = true
+		seen[ToSnakeCase(p.Name)] = true
+	}
+	visited := make(map[string]bool)
+	var flatten func(m *apiMessage)
+	flatten = func(m *apiMessage) {
+		if m == nil || visited[m.Name] {
+			return
+		}
+		visited[m.Name] = true
+		for _, f := range m.Fields {
+			if m == request && f.Name == body {
+				continue
+			}
+			if f.Type == "message" {
+				if !f.Repeated {
+					flatten(b.schema.message(f.Ref))
+				}
+				continue
+			}
+			if f.Type == "map" {
+				continue
+			}
+			name := ToSnakeCase(f.GoName)
+			if seen[name] || seen[strings.ToLower(f.GoName)] {
+				continue
+			}
+			seen[name] = true
+			param := &openAPIParameter{Name: name, In: "query", Schema: b.scalarSchema(f, false)}
+			if f.Repeated {
+				explode := false
+				param.Style = "form"
+				param.Explode = &explode
+				param.Schema = &openAPISchema{Type: "array", Items: param.Schema}
+			}
+			params = append(params, param)
+		}
+	}
+	flatten(request)
+	return params
+}
+
+func (b *openAPIBuilder) messageSchema(m *apiMessage) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	for _, f := range m.Fields {
+		s.Properties[f.Name] = b.fieldSchema(f)
+	}
+	return s
+}
+
+func (b *openAPIBuilder) enumSchema(values []string) *openAPISchema {
+	if b.enumAsString {
+		return &openAPISchema{Type: "string", Enum: values}
+	}
+	return &openAPISchema{Type: "integer", Format: "int32"}
+}
+
+func (b *openAPIBuilder) fieldSchema(f *apiField) *openAPISchema {
+	var s *openAPISchema
+	switch f.Type {
+	case "message":
+		s = &openAPISchema{Ref: "#/components/schemas/" + f.Ref}
+	case "map":
+		s = &openAPISchema{Type: "object", AdditionalProperties: b.fieldSchema(f.Value)}
+	default:
+		s = b.scalarSchema(f, b.int64AsString)
+	}
+	if f.Repeated {
+		return &openAPISchema{Type: "array", Items: s}
+	}
+	return s
+}
+
+func (b *openAPIBuilder) scalarSchema(f *apiField, int64AsString bool) *openAPISchema {
+	switch f.Type {
+	case "string":
+		return &openAPISchema{Type: "string"}
+	case "bool":
+		return &openAPISchema{Type: "boolean"}
+	case "int32", "uint32":
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case "int64", "uint64":
+		if int64AsString {
+			return &openAPISchema{Type: "string", Format: "int64"}
+		}
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case "float":
+		return &openAPISchema{Type: "number", Format: "float"}
+	case "double":
+		return &openAPISchema{Type: "number", Format: "double"}
+	case "bytes":
+		return &openAPISchema{Type: "string", Format: "byte"}
+	case "enum":
+		if len(f.Ref) > 0 {
+			return &openAPISchema{Ref: "#/components/schemas/" + f.Ref}
+		}
+		return &openAPISchema{Type: "integer"}
+	}
+	return &openAPISchema{Type: "string"}
+}
+
+func fieldByName(m *apiMessage, name string) *apiField {
+	for _, f := range m.Fields {
+		if f.Name == name || f.JSONName == name || f.GoName == name {
+			return f
+		}
+	}
+	return nil
+}
+
+var matchPathVar = regexp.MustCompile(`\{([^}:]+)(:([^}]*))?\}`)
+
+// openAPIPath converts a gorilla/mux path template into an OpenAPI path, and returns the path parameters,
+// e.g. "/hello/{your_name:[a-z]+}" -> "/hello/{your_name}"
+func openAPIPath(urlPattern string) (string, []*openAPIParameter) {
+	params := make([]*openAPIParameter, 0)
+	path := matchPathVar.ReplaceAllStringFunc(urlPattern, func(v string) string {
+		parts := matchPathVar.FindStringSubmatch(v)
+		schema := &openAPISchema{Type: "string"}
+		if len(parts[3]) > 0 {
+			schema.Pattern = "^" + parts[3] + "$"
+		}
+		params = append(params, &openAPIParameter{Name: parts[1], In: "path", Required: true, Schema: schema})
+		return "{" + parts[1] + "}"
+	})
+	return path, params
+}
+
+// OpenAPIPath returns "openapi_path" in config file, the path at which the generated OpenAPI document is served
+func (c *Config) OpenAPIPath() string {
+	return strings.TrimSpace(c.configs[openAPIPathKey])
+}
+
+// openAPIHandler serves [service_root_path]/gen/openapi.json
+func openAPIHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+		http.ServeFile(resp, req, s.ServerField().Config.ServiceRootPathAbsolute()+"/gen/openapi.json")
+	}
+}
@@ -0,0 +1,96 @@
+package turbo
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDescriptors() []*descriptor.FileDescriptorProto {
+	field := func(name string, number int32, t descriptor.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptor.FieldDescriptorProto {
+		label := descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptor.FieldDescriptorProto_LABEL_REPEATED
+		}
+		f := &descriptor.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: &t, Label: &label}
+		if len(typeName) > 0 {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	return []*descriptor.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("proto"),
+		MessageType: []*descriptor.DescriptorProto{
+			{Name: proto.String("CommonValues"), Field: []*descriptor.FieldDescriptorProto{
+				field("some_id", 1, descriptor.FieldDescriptorProto_TYPE_INT64, "", false)}},
+			{Name: proto.String("SayHelloRequest"), Field: []*descriptor.FieldDescriptorProto{
+				field("values", 1, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".proto.CommonValues", false),
+				field("yourName", 2, descriptor.FieldDescriptorProto_TYPE_STRING, "", false),
+				field("int64_list", 3, descriptor.FieldDescriptorProto_TYPE_INT64, "", true)}},
+			{Name: proto.String("SayHelloResponse"), Field: []*descriptor.FieldDescriptorProto{
+				field("message", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "", false)}},
+		},
+		Service: []*descriptor.ServiceDescriptorProto{{
+			Name: proto.String("YourService"),
+			Method: []*descriptor.MethodDescriptorProto{{
+				Name:       proto.String("sayHello"),
+				InputType:  proto.String(".proto.SayHelloRequest"),
+				OutputType: proto.String(".proto.SayHelloResponse")}},
+		}},
+	}}
+}
+
+func TestGrpcSchema(t *testing.T) {
+	s := grpcSchema(testDescriptors(), "YourService")
+	assert.Equal(t, &apiMethod{Name: "SayHello", Request: "SayHelloRequest", Response: "SayHelloResponse"}, s.Methods["SayHello"])
+	assert.Equal(t, 3, len(s.Messages))
+	f := s.Messages["SayHelloRequest"].Fields[0]
+	assert.Equal(t, "message", f.Type)
+	assert.Equal(t, "CommonValues", f.Ref)
+	f = s.Messages["SayHelloRequest"].Fields[2]
+	assert.Equal(t, "Int64List", f.GoName)
+	assert.Equal(t, "int64List", f.JSONName)
+	assert.True(t, f.Repeated)
+
+	assert.Equal(t, 0, len(grpcSchema(testDescriptors(), "OtherService").Methods))
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	path, params := openAPIPath("/eat_apple/{num:[0-9]+}/{name}")
+	assert.Equal(t, "/eat_apple/{num}/{name}", path)
+	assert.Equal(t, 2, len(params))
+	assert.Equal(t, "num", params[0].Name)
+	assert.Equal(t, "^[0-9]+$", params[0].Schema.Pattern)
+	assert.Equal(t, "", params[1].Schema.Pattern)
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	doc := newOpenAPIBuilder(c, grpcSchema(testDescriptors(), "YourService")).build()
+	assert.Equal(t, "YourService", doc.Info.Title)
+
+	get := doc.Paths["/hello"]["get"]
+	assert.Equal(t, "SayHello", get.OperationID)
+	assert.Nil(t, get.RequestBody)
+	names := make([]string, 0)
+	for _, p := range get.Parameters {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"some_id", "your_name", "int64_list"}, names)
+	assert.Equal(t, "array", get.Parameters[2].Schema.Type)
+	assert.Equal(t, "#/components/schemas/SayHelloResponse", get.Responses["200"].Content["application/json"].Schema.Ref)
+
+	post := doc.Paths["/hello"]["post"]
+	assert.Equal(t, "#/components/schemas/SayHelloRequest", post.RequestBody.Content["application/json"].Schema.Ref)
+
+	apple := doc.Paths["/eat_apple/{num}"]["get"]
+	assert.Equal(t, "path", apple.Parameters[0].In)
+	assert.Equal(t, 1, len(apple.Parameters))
+	assert.Equal(t, "OK", apple.Responses["200"].Description)
+
+	// filter_proto_json_int64_as_number is true in service_test.yaml
+	assert.Equal(t, "integer", doc.Components.Schemas["CommonValues"].Properties["some_id"].Type)
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/protoc-gen-go/plugin"
+)
+
+// HttpRule mirrors google.api.HttpRule in google/api/http.proto,
+// only fields turbo cares about are declared, they are wire compatible with the original message.
+type HttpRule struct {
+	Selector           string             `protobuf:"bytes,1,opt,name=selector,proto3" json:"selector,omitempty"`
+	Get                string             `protobuf:"bytes,2,opt,name=get,proto3" json:"get,omitempty"`
+	Put                string             `protobuf:"bytes,3,opt,name=put,proto3" json:"put,omitempty"`
+	Post               string             `protobuf:"bytes,4,opt,name=post,proto3" json:"post,omitempty"`
+	Delete             string             `protobuf:"bytes,5,opt,name=delete,proto3" json:"delete,omitempty"`
+	Patch              string             `protobuf:"bytes,6,opt,name=patch,proto3" json:"patch,omitempty"`
+	Body               string             `protobuf:"bytes,7,opt,name=body,proto3" json:"body,omitempty"`
+	Custom             *CustomHttpPattern `protobuf:"bytes,8,opt,name=custom,proto3" json:"custom,omitempty"`
+	AdditionalBindings []*HttpRule        `protobuf:"bytes,11,rep,name=additional_bindings,json=additionalBindings,proto3" json:"additional_bindings,omitempty"`
+	ResponseBody       string             `protobuf:"bytes,12,opt,name=response_body,json=responseBody,proto3" json:"response_body,omitempty"`
+}
+
+func (m *HttpRule) Reset()         { *m = HttpRule{} }
+func (m *HttpRule) String() string { return proto.CompactTextString(m) }
+func (*HttpRule) ProtoMessage()    {}
+
+// CustomHttpPattern mirrors google.api.CustomHttpPattern
+type CustomHttpPattern struct {
+	Kind string `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Path string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+}
+
+func (m *CustomHttpPattern) Reset()         { *m = CustomHttpPattern{} }
+func (m *CustomHttpPattern) String() string { return proto.CompactTextString(m) }
+func (*CustomHttpPattern) ProtoMessage()    {}
+
+// E_Http is the "google.api.http" extension on google.protobuf.MethodOptions
+var E_Http = &proto.ExtensionDesc{
+	ExtendedType:  (*descriptor.MethodOptions)(nil),
+	ExtensionType: (*HttpRule)(nil),
+	Field:         72295728,
+	Name:          "google.api.http",
+	Tag:           "bytes,72295728,opt,name=http",
+}
+
+// routeList extracts google.api.http rules from all services in the files to generate, as lines of
+// [service_root_path]/gen/grpcroutes.yaml, which is loaded by Config along with "urlmapping".
+func routeList(req *plugin_go.CodeGeneratorRequest) (string, error) {
+	toGenerate := make(map[string]bool)
+	for _, name := range req.FileToGenerate {
+		toGenerate[name] = true
+	}
+	messages := make(map[string]*descriptor.DescriptorProto)
+	for _, f := range req.ProtoFile {
+		addMessages(messages, "."+f.GetPackage(), f.MessageType)
+	}
+	var list string
+	for _, f := range req.ProtoFile {
+		if !toGenerate[f.GetName()] {
+			continue
+		}
+		for _, service := range f.Service {
+			for _, method := range service.Method {
+				routes, err := methodRoutes(method, messages)
+				if err != nil {
+					return "", err
+				}
+				for _, route := range routes {
+					list += "  - " + route + "\n"
+				}
+			}
+		}
+	}
+	return list, nil
+}
+
+// addMessages adds messages and their nested messages by full names like ".pkg.Message"
+func addMessages(messages map[string]*descriptor.DescriptorProto, prefix string, list []*descriptor.DescriptorProto) {
+	if prefix == "." {
+		prefix = ""
+	}
+	for _, m := range list {
+		name := prefix + "." + m.GetName()
+		messages[name] = m
+		addMessages(messages, name, m.NestedType)
+	}
+}
+
+type routesYamlValues struct {
+	List string
+}
+
+var routesYaml string = `# Code generated by protoc-gen-buildfields from google.api.http annotations. DO NOT EDIT.
+grpc-routes:
+{{.List}}
+`
+
+// methodRoutes returns route lines for a method, in the same format as "urlmapping",
+// followed by "body=" and "response_body=" options, e.g.
+// "POST /v1/users/{id} UpdateUser body=user response_body=user",
+// path templates are checked against the request message, see checkPathTemplate.
+func methodRoutes(method *descriptor.MethodDescriptorProto, messages map[string]*descriptor.DescriptorProto) ([]string, error) {
+	if method.Options == nil || !proto.HasExtension(method.Options, E_Http) {
+		return nil, nil
+	}
+	ext, err := proto.GetExtension(method.Options, E_Http)
+	if err != nil {
+		return nil, nil
+	}
+	rule, ok := ext.(*HttpRule)
+	if !ok {
+		return nil, nil
+	}
+	return ruleRoutes(camelCase(method.GetName()), rule, method.GetInputType(), messages)
+}
+
+func ruleRoutes(methodName string, rule *HttpRule, input string, messages map[string]*descriptor.DescriptorProto) ([]string, error) {
+	routes := make([]string, 0, len(rule.AdditionalBindings)+1)
+	if httpMethod, pattern := rulePattern(rule); len(pattern) > 0 {
+		if err := checkPathTemplate(pattern, input, messages); err != nil {
+			return nil, errors.New("invalid google.api.http path of " + methodName + ": " + err.Error())
+		}
+		route := fmt.Sprintf("%s %s %s", httpMethod, muxPathTemplate(pattern), methodName)
+		if len(rule.Body) > 0 {
+			route += " body=" + rule.Body
+		}
+		if len(rule.ResponseBody) > 0 {
+			route += " response_body=" + rule.ResponseBody
+		}
+		routes = append(routes, route)
+	}
+	// additional_bindings must not contain nested additional_bindings, so there is no recursion here
+	for _, binding := range rule.AdditionalBindings {
+		binding.AdditionalBindings = nil
+		bindingRoutes, err := ruleRoutes(methodName, binding, input, messages)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, bindingRoutes...)
+	}
+	return routes, nil
+}
+
+func rulePattern(rule *HttpRule) (string, string) {
+	switch {
+	case len(rule.Get) > 0:
+		return "GET", rule.Get
+	case len(rule.Put) > 0:
+		return "PUT", rule.Put
+	case len(rule.Post) > 0:
+		return "POST", rule.Post
+	case len(rule.Delete) > 0:
+		return "DELETE", rule.Delete
+	case len(rule.Patch) > 0:
+		return "PATCH", rule.Patch
+	case rule.Custom != nil && len(rule.Custom.Path) > 0:
+		return strings.ToUpper(rule.Custom.Kind), rule.Custom.Path
+	}
+	return "", ""
+}
+
+var matchTemplateVar = regexp.MustCompile(`\{([^}=]+)(=([^}]*))?\}`)
+
+// checkPathTemplate returns an error if a variable in a path template does not name a field of the request message
+// 'input', "{user.id}" names the field "id" of the message field "user", turbo binds it to that field only.
+func checkPathTemplate(pattern, input string, messages map[string]*descriptor.DescriptorProto) error {
+	seen := make(map[string]bool)
+	for _, parts := range matchTemplateVar.FindAllStringSubmatch(pattern, -1) {
+		if seen[parts[1]] {
+			return errors.New(fmt.Sprintf("{%s} is used more than once", parts[1]))
+		}
+		seen[parts[1]] = true
+		m, ok := messages[input]
+		if !ok {
+			continue
+		}
+		fieldPath := strings.Split(parts[1], ".")
+		for i, name := range fieldPath {
+			f := fieldByName(m, name)
+			if f == nil {
+				return errors.New(fmt.Sprintf("{%s}: no field '%s' in %s", parts[1], name, m.GetName()))
+			}
+			isMessage := f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE
+			if i == len(fieldPath)-1 {
+				if isMessage || f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+					return errors.New(fmt.Sprintf("{%s}: field '%s' of %s is not a singular scalar", parts[1], name, m.GetName()))
+				}
+				break
+			}
+			if !isMessage || f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+				return errors.New(fmt.Sprintf("{%s}: field '%s' of %s is not a singular message", parts[1], name, m.GetName()))
+			}
+			if m, ok = messages[f.GetTypeName()]; !ok {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func fieldByName(m *descriptor.DescriptorProto, name string) *descriptor.FieldDescriptorProto {
+	for _, f := range m.Field {
+		if f.GetName() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// muxPathTemplate converts a google.api.http path template into a gorilla/mux path template:
+// "{id}" and "{user.id}" keep their names, "{name=shelves/*}" becomes "{name:shelves/[^/]+}",
+// "**" matches the rest of the path, and bare "*" and "**" segments become unnamed variables like "{_1:[^/]+}".
+func muxPathTemplate(pattern string) string {
+	segments := make([]string, 0)
+	depth, start := 0, 0
+	for i, r := range pattern {
+		switch {
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		case r == '/' && depth == 0:
+			segments = append(segments, pattern[start:i])
+			start = i + 1
+		}
+	}
+	segments = append(segments, pattern[start:])
+	for i, s := range segments {
+		switch s {
+		case "*":
+			segments[i] = fmt.Sprintf("{_%d:[^/]+}", i)
+		case "**":
+			segments[i] = fmt.Sprintf("{_%d:.*}", i)
+		default:
+			segments[i] = matchTemplateVar.ReplaceAllStringFunc(s, muxPathVariable)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func muxPathVariable(v string) string {
+	parts := matchTemplateVar.FindStringSubmatch(v)
+	if len(parts[3]) == 0 || parts[3] == "*" {
+		return "{" + parts[1] + "}"
+	}
+	segments := strings.Split(parts[3], "/")
+	for i, s := range segments {
+		switch s {
+		case "*":
+			segments[i] = "[^/]+"
+		case "**":
+			segments[i] = ".+"
+		default:
+			segments[i] = regexp.QuoteMeta(s)
+		}
+	}
+	return "{" + parts[1] + ":" + strings.Join(segments, "/") + "}"
+}
+
+// camelCase converts a rpc name into the method name generated by protoc-gen-go, e.g. "say_hello" -> "SayHello"
+func camelCase(name string) string {
+	var result string
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			result += strings.ToUpper(string(r))
+			upper = false
+			continue
+		}
+		result += string(r)
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMuxPathTemplate(t *testing.T) {
+	assert.Equal(t, "/v1/users/{id}", muxPathTemplate("/v1/users/{id}"))
+	assert.Equal(t, "/v1/users/{id}", muxPathTemplate("/v1/users/{id=*}"))
+	assert.Equal(t, "/v1/groups/{group.id}/users/{user.id}", muxPathTemplate("/v1/groups/{group.id}/users/{user.id}"))
+	assert.Equal(t, "/v1/{name:shelves/[^/]+/books/[^/]+}", muxPathTemplate("/v1/{name=shelves/*/books/*}"))
+	assert.Equal(t, "/v1/{path:files/.+}", muxPathTemplate("/v1/{path=files/**}"))
+	assert.Equal(t, `/v1/{name:a\.b/[^/]+}`, muxPathTemplate("/v1/{name=a.b/*}"))
+	assert.Equal(t, "/v1/files/{_3:.*}", muxPathTemplate("/v1/files/**"))
+	assert.Equal(t, "/v1/{_2:[^/]+}/{name:books/[^/]+}", muxPathTemplate("/v1/*/{name=books/*}"))
+}
+
+func TestCheckPathTemplate(t *testing.T) {
+	messages := make(map[string]*descriptor.DescriptorProto)
+	addMessages(messages, ".test", []*descriptor.DescriptorProto{
+		{
+			Name: proto.String("UpdateUserRequest"),
+			Field: []*descriptor.FieldDescriptorProto{
+				testField("id", descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				testField("user", descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".test.User"),
+				testField("name", descriptor.FieldDescriptorProto_TYPE_STRING, ""),
+			},
+		},
+		{
+			Name: proto.String("User"),
+			Field: []*descriptor.FieldDescriptorProto{
+				testField("id", descriptor.FieldDescriptorProto_TYPE_INT64, ""),
+				testField("address", descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".test.User.Address"),
+			},
+			NestedType: []*descriptor.DescriptorProto{{
+				Name:  proto.String("Address"),
+				Field: []*descriptor.FieldDescriptorProto{testField("city", descriptor.FieldDescriptorProto_TYPE_STRING, "")},
+			}},
+		},
+	})
+	input := ".test.UpdateUserRequest"
+	assert.Nil(t, checkPathTemplate("/v1/users/{id}", input, messages))
+	assert.Nil(t, checkPathTemplate("/v1/users/{user.id}", input, messages))
+	assert.Nil(t, checkPathTemplate("/v1/users/{id}/{user.id}/{user.address.city=cities/*}", input, messages))
+	assert.Nil(t, checkPathTemplate("/v1/users/{anything}", ".test.Unknown", messages))
+
+	err := checkPathTemplate("/v1/users/{user.name}", input, messages)
+	assert.Equal(t, "{user.name}: no field 'name' in User", err.Error())
+	err = checkPathTemplate("/v1/users/{name.id}", input, messages)
+	assert.Equal(t, "{name.id}: field 'name' of UpdateUserRequest is not a singular message", err.Error())
+	err = checkPathTemplate("/v1/users/{user.address}", input, messages)
+	assert.Equal(t, "{user.address}: field 'address' of User is not a singular scalar", err.Error())
+	err = checkPathTemplate("/v1/users/{id}/{id}", input, messages)
+	assert.Equal(t, "{id} is used more than once", err.Error())
+}
+
+func testField(name string, t descriptor.FieldDescriptorProto_Type, typeName string) *descriptor.FieldDescriptorProto {
+	f := &descriptor.FieldDescriptorProto{Name: proto.String(name), Type: t.Enum()}
+	if len(typeName) > 0 {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+func TestCamelCase(t *testing.T) {
+	assert.Equal(t, "SayHello", camelCase("say_hello"))
+	assert.Equal(t, "SayHello", camelCase("SayHello"))
+	assert.Equal(t, "GetV2Rule", camelCase("get_v2_rule"))
+	assert.Equal(t, "", camelCase(""))
+}
@@ -28,9 +28,19 @@ func main() {
 		fmt.Println("parsing input proto:", err)
 	}
 	generateBuildFields(request, response)
+	if data, err = proto.Marshal(response); err != nil {
+		fmt.Println("marshaling output proto:", err)
+	}
+	os.Stdout.Write(data)
 }
 
+// generateBuildFields writes generated files, errors in proto files are reported to protoc in resp.Error
 func generateBuildFields(req *plugin_go.CodeGeneratorRequest, resp *plugin_go.CodeGeneratorResponse) {
+	routes, err := routeList(req)
+	if err != nil {
+		resp.Error = proto.String(err.Error())
+		return
+	}
 	files := req.ProtoFile
 	items := make([]string, 0)
 	for _, f := range files {
@@ -54,6 +64,24 @@ func generateBuildFields(req *plugin_go.CodeGeneratorRequest, resp *plugin_go.Co
 		fieldsYaml,
 		fieldsYamlValues{List: list},
 	)
+	writeFileWithTemplate(
+		m["service_root_path"]+"/gen/grpcroutes.yaml",
+		routesYaml,
+		routesYamlValues{List: routes},
+	)
+	writeDescriptorSet(req, m["service_root_path"])
+}
+
+// writeDescriptorSet saves all file descriptors into [service_root_path]/gen/grpcdescriptors.pb,
+// turbo reads request/response message types from it.
+func writeDescriptorSet(req *plugin_go.CodeGeneratorRequest, serviceRootPath string) {
+	data, err := proto.Marshal(&descriptor.FileDescriptorSet{File: req.ProtoFile})
+	if err != nil {
+		panic(err)
+	}
+	if err = ioutil.WriteFile(serviceRootPath+"/gen/grpcdescriptors.pb", data, 0644); err != nil {
+		panic("fail to create file:" + serviceRootPath + "/gen/grpcdescriptors.pb")
+	}
 }
 
 func parameterMap(parameter string) map[string]string {
@@ -0,0 +1,259 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rateLimitConfig is an item in "ratelimit" section in config file, e.g.
+//
+//	ratelimit:
+//	  - route: GET,POST /v1/
+//	    key: header:X-Api-Key
+//	    rate: 100/m
+//	    burst: 20
+//	  - route: /hello
+//	    key: claim:sub
+//	    rate: 10/s
+//
+// "route" matches requests the same way as component mappings do, methods are optional,
+// a path ends with "/" matches all paths with that prefix, the first matched item is used.
+// "key" is "ip" (default), "header:<name>" or "claim:<name>" of the JWT verified by the "jwt" section,
+// which is required for "claim:" keys. A "header:" key limits requests with that header by the principal verified
+// by the "auth" or "jwt" section, see PrincipalOf, not by the header value, so callers can't get a new bucket
+// by sending a new value. Requests without a verified principal or claim are limited by client IP.
+// "rate" is tokens added per second, minute or hour, e.g. "10/s", "100/m", "1000/h", a number means per second,
+// "burst" is the size of the bucket, default is the tokens added per second, at least 1.
+type rateLimitConfig struct {
+	Route string `mapstructure:"route"`
+	Key   string `mapstructure:"key"`
+	Rate  string `mapstructure:"rate"`
+	Burst int    `mapstructure:"burst"`
+}
+
+// rateLimit is a token bucket per key
+type rateLimit struct {
+	methods []string
+	path    string
+	key     string
+	rate    float64
+	burst   int
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	sweepAt int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (l *rateLimit) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// loadRateLimits loads the "ratelimit" section, rateLimits is nil if there is no such section
+func (c *Config) loadRateLimits() {
+	c.rateLimits = nil
+	if !c.IsSet("ratelimit") {
+		return
+	}
+	items := make([]rateLimitConfig, 0)
+	panicIf(c.UnmarshalKey("ratelimit", &items))
+	for _, item := range items {
+		l, err := newRateLimit(item)
+		panicIf(err)
+		if strings.HasPrefix(l.key, "claim:") && !c.IsSet("jwt") {
+			panic("turbo: ratelimit key " + l.key + " needs the \"jwt\" section to verify tokens, route: " + item.Route)
+		}
+		if strings.HasPrefix(l.key, "header:") && !c.IsSet("auth") && !c.IsSet("jwt") {
+			panic("turbo: ratelimit key " + l.key + " needs the \"auth\" or \"jwt\" section to verify callers, route: " + item.Route)
+		}
+		c.rateLimits = setComponent(c.rateLimits, l.methods, l.path, l)
+	}
+}
+
+func newRateLimit(item rateLimitConfig) (*rateLimit, error) {
+	methods, path := parseRoute(item.Route)
+	if len(path) == 0 {
+		return nil, errors.New("turbo: invalid ratelimit route: " + item.Route)
+	}
+	key := strings.TrimSpace(item.Key)
+	if len(key) == 0 {
+		key = "ip"
+	}
+	if key != "ip" && !strings.HasPrefix(key, "header:") && !strings.HasPrefix(key, "claim:") {
+		return nil, errors.New("turbo: invalid ratelimit key: " + key + ", should be 'ip', 'header:<name>' or 'claim:<name>'")
+	}
+	rate, err := parseRate(item.Rate)
+	if err != nil {
+		return nil, err
+	}
+	burst := item.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &rateLimit{methods: methods, path: path, key: key, rate: rate, burst: burst,
+		buckets: make(map[string]*tokenBucket), sweepAt: 1024}, nil
+}
+
+// parseRoute parses "GET,POST /path" or "/path" into methods and the path
+func parseRoute(route string) ([]string, string) {
+	fields := strings.Fields(route)
+	switch len(fields) {
+	case 1:
+		return nil, fields[0]
+	case 2:
+		return strings.Split(fields[0], ","), fields[1]
+	}
+	return nil, ""
+}
+
+// parseRate parses a rate like "10/s", "100/m" or "1000/h", and returns tokens per second
+func parseRate(rate string) (float64, error) {
+	value, unit := strings.TrimSpace(rate), "s"
+	if i := strings.Index(value, "/"); i >= 0 {
+		value, unit = strings.TrimSpace(value[:i]), strings.TrimSpace(value[i+1:])
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("turbo: invalid ratelimit rate: " + rate)
+	}
+	switch unit {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	}
+	return 0, errors.New("turbo: invalid ratelimit rate: " + rate + ", unit should be 's', 'm' or 'h'")
+}
+
+// inheritRateLimits keeps tokens of buckets in 'old' for items with the same route and key,
+// so reloading config does not reset limits.
+func (c *Config) inheritRateLimits(old *Config) {
+	if c.rateLimits == nil || old.rateLimits == nil {
+		return
+	}
+	oldLimits := make(map[string]*rateLimit)
+	old.rateLimits.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if l, ok := route.GetHandler().(*rateLimit); ok {
+			oldLimits[l.id()] = l
+		}
+		return nil
+	})
+	c.rateLimits.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		l, ok := route.GetHandler().(*rateLimit)
+		if !ok {
+			return nil
+		}
+		if o, ok := oldLimits[l.id()]; ok {
+			o.mu.Lock()
+			l.buckets, l.sweepAt = o.buckets, o.sweepAt
+			o.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+func (l *rateLimit) id() string {
+	return strings.Join(l.methods, ",") + " " + l.path + " " + l.key
+}
+
+// take takes a token from the bucket of 'key', it returns tokens remaining, and the time to wait if it's rejected
+func (l *rateLimit) take(key string, now time.Time) (float64, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		l.sweep(now)
+		b = &tokenBucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * l.rate
+		b.last = now
+	}
+	b.tokens = math.Min(b.tokens, float64(l.burst))
+	if b.tokens < 1 {
+		return b.tokens, time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return b.tokens, 0, true
+}
+
+// sweep removes buckets which are full again, when there are too many of them
+func (l *rateLimit) sweep(now time.Time) {
+	if len(l.buckets) < l.sweepAt {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= float64(l.burst) {
+			delete(l.buckets, key)
+		}
+	}
+	if 2*len(l.buckets) > l.sweepAt {
+		l.sweepAt = 2 * len(l.buckets)
+	}
+}
+
+// keyOf returns the key of the bucket a request takes tokens from
+func (l *rateLimit) keyOf(req *http.Request) string {
+	switch {
+	case strings.HasPrefix(l.key, "header:"):
+		p, ok := PrincipalOf(req.Context())
+		if ok && len(req.Header.Get(strings.TrimPrefix(l.key, "header:"))) > 0 {
+			return "principal:" + p.Provider + ":" + p.Name
+		}
+	case strings.HasPrefix(l.key, "claim:"):
+		if v, ok := jwtClaim(req, strings.TrimPrefix(l.key, "claim:")); ok {
+			return "claim:" + v
+		}
+	}
+	return "ip:" + ClientIP(req)
+}
+
+// jwtClaim returns a claim of the JWT verified by the "jwt" section, see JWTClaims,
+// unverified tokens are never used, since anyone can make up their claims.
+func jwtClaim(req *http.Request, name string) (string, bool) {
+	v, ok := JWTClaims(req.Context())[name]
+	if !ok || v == nil {
+		return "", false
+	}
+	return claimString(v), true
+}
+
+// rateLimited takes a token for a request, it sets "X-RateLimit-*" headers,
+// and responds with 429 and "Retry-After" header if there is no token left.
+func rateLimited(s Servable, resp http.ResponseWriter, req *http.Request) bool {
+	cp := component(s.ServerField().Config.rateLimits, req)
+	if cp == nil {
+		return false
+	}
+	l := cp.(*rateLimit)
+	now := time.Now()
+	remaining, wait, ok := l.take(l.keyOf(req), now)
+	h := resp.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(l.burst))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	reset := (float64(l.burst) - remaining) / l.rate
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(reset)), 10))
+	if ok {
+		return false
+	}
+	h.Set("Retry-After", strconv.FormatInt(int64(math.Max(1, math.Ceil(wait.Seconds()))), 10))
+	http.Error(resp, "turbo: rate limit exceeded", http.StatusTooManyRequests)
+	return true
+}
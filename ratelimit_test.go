@@ -0,0 +1,138 @@
+package turbo
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	rate, err := parseRate("10/s")
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, rate)
+	rate, _ = parseRate(" 120 / m ")
+	assert.Equal(t, 2.0, rate)
+	rate, _ = parseRate("5")
+	assert.Equal(t, 5.0, rate)
+	_, err = parseRate("10/d")
+	assert.NotNil(t, err)
+	_, err = parseRate("0/s")
+	assert.NotNil(t, err)
+	_, err = newRateLimit(rateLimitConfig{Route: "/a", Key: "cookie:x", Rate: "1"})
+	assert.NotNil(t, err)
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, err := newRateLimit(rateLimitConfig{Route: "/a", Rate: "2/s", Burst: 3})
+	assert.Nil(t, err)
+	now := time.Now()
+	for i := 2; i >= 0; i-- {
+		remaining, _, ok := l.take("k", now)
+		assert.True(t, ok)
+		assert.Equal(t, float64(i), remaining)
+	}
+	_, wait, ok := l.take("k", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+	_, _, ok = l.take("other", now)
+	assert.True(t, ok)
+	_, _, ok = l.take("k", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	remaining, _, _ := l.take("k", now.Add(time.Hour))
+	assert.Equal(t, 2.0, remaining)
+
+	l.sweepAt = 2
+	l.take("new", now.Add(2*time.Hour))
+	assert.Equal(t, 1, len(l.buckets))
+}
+
+func TestRateLimit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "turbo_ratelimit")
+	defer os.RemoveAll(dir)
+	auth := testAuthConfig(t, dir)
+	s := newTestServerWith(map[string]interface{}{"auth": auth, "ratelimit": []map[string]interface{}{
+		{"route": "GET /v1/rules/", "key": "header:X-Api-Key", "rate": "1/m", "burst": 2},
+		{"route": "GET /hello", "key": "header:X-Api-Key", "rate": "1/m", "burst": 1},
+	}})
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		return &testRuleRequest{Id: 12}, nil
+	}
+	get := func(url, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("X-Api-Key", apiKey)
+		resp := httptest.NewRecorder()
+		router(s).ServeHTTP(resp, req)
+		return resp
+	}
+	resp := get("/v1/rules/12", "key-a")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "2", resp.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", resp.Header().Get("X-RateLimit-Reset"))
+	get("/v1/rules/12", "key-a")
+	resp = get("/v1/rules/12", "key-a")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "0", resp.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+	// unknown keys are rejected by auth before they can get a bucket
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/rules/12", "key-x").Code)
+
+	// header values not verified by auth don't get their own buckets
+	assert.Equal(t, http.StatusOK, get("/hello", "rotating-1").Code)
+	for i := 2; i <= 4; i++ {
+		assert.Equal(t, http.StatusTooManyRequests, get("/hello", fmt.Sprintf("rotating-%d", i)).Code)
+	}
+
+	// tokens are kept on reload
+	c := newTestConfig(map[string]interface{}{"auth": auth, "ratelimit": []map[string]interface{}{{"route": "GET /v1/rules/", "key": "header:X-Api-Key", "rate": "1/m", "burst": 5}}})
+	c.inheritRateLimits(s.Config)
+	s.Config = c
+	resp = get("/v1/rules/12", "key-a")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "5", resp.Header().Get("X-RateLimit-Limit"))
+	s.Config.Set("ratelimit", []map[string]interface{}{})
+	s.Config.loadRateLimits()
+	assert.Equal(t, http.StatusOK, get("/v1/rules/12", "key-a").Code)
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	req := httptest.NewRequest("GET", "/hello", nil)
+	// a token not verified by the "jwt" section is ignored
+	req.Header.Set("Authorization", "Bearer x."+base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`))+".sig")
+	_, ok := jwtClaim(req, "sub")
+	assert.False(t, ok)
+	l, _ := newRateLimit(rateLimitConfig{Route: "/hello", Key: "claim:sub", Rate: "1"})
+	assert.Equal(t, "ip:192.0.2.1", l.keyOf(req))
+
+	verified := req.WithContext(context.WithValue(req.Context(), jwtClaimsKey{}, map[string]interface{}{"sub": "bob", "n": json.Number("1")}))
+	assert.Equal(t, "claim:bob", l.keyOf(verified))
+	v, _ := jwtClaim(verified, "n")
+	assert.Equal(t, "1", v)
+	_, ok = jwtClaim(verified, "aud")
+	assert.False(t, ok)
+	l, _ = newRateLimit(rateLimitConfig{Route: "/hello", Rate: "1"})
+	assert.Equal(t, "ip:192.0.2.1", l.keyOf(verified))
+
+	assert.Panics(t, func() {
+		newTestConfig(map[string]interface{}{"ratelimit": []map[string]interface{}{{"route": "/hello", "key": "claim:sub", "rate": "1"}}})
+	})
+
+	l, _ = newRateLimit(rateLimitConfig{Route: "/hello", Key: "header:X-Api-Key", Rate: "1"})
+	req.Header.Set("X-Api-Key", "key-a")
+	assert.Equal(t, "ip:192.0.2.1", l.keyOf(req))
+	authed := req.WithContext(context.WithValue(req.Context(), principalKey{}, Principal{Name: "Partner-A", Provider: authAPIKey}))
+	assert.Equal(t, "principal:apikey:Partner-A", l.keyOf(authed))
+	assert.Panics(t, func() {
+		newTestConfig(map[string]interface{}{"ratelimit": []map[string]interface{}{{"route": "/hello", "key": "header:X-Api-Key", "rate": "1"}}})
+	})
+}
@@ -0,0 +1,383 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retryConfig is an item in "retry" section in config file, e.g.
+//
+//	retry:
+//	  - route: GET,PUT /v1/rules/
+//	    idempotent: true
+//	    max_attempts: 3
+//	    codes: [UNAVAILABLE, RESOURCE_EXHAUSTED]
+//	    thrift_errors: [not_open, end_of_file, unknown]
+//	    backoff: 50ms
+//	    max_backoff: 1s
+//	    budget: 0.2
+//	    min_retries: 10
+//	  - route: GET /v1/search
+//	    idempotent: true
+//	    max_attempts: 2
+//	    hedge_delay: 30ms
+//
+// "route" matches requests the same way as component mappings do, methods are optional,
+// the first matched item is used. Only routes with "idempotent: true" can be retried, an item without it is invalid.
+// A call is tried at most "max_attempts"(default 3) times, it's retried if the error is a grpc status with one of
+// "codes"(default UNAVAILABLE), or a thrift transport error of "thrift_errors"(default not_open, end_of_file and unknown,
+// which is what a reset socket gets), the thrift connection is reopened before retrying, once for all calls failing on it.
+// The wait before the n-th retry is random between 0 and "backoff"(default 25ms) * 2^(n-1), at most "max_backoff"(default 1s).
+// Retries of a route are limited by a budget, in every 10 seconds, they are at most "min_retries"(default 10)
+// plus "budget"(default 0.2) of requests, so retries do not pile up on a failing backend.
+// If "hedge_delay" is set, another attempt is sent if there is no response in that time,
+// or at once if an attempt fails with a retryable error, the first response which is not retryable is used,
+// and the other attempts are canceled. Hedging is for grpc only, since a thrift client can not send concurrent calls.
+// Each attempt gets a copy of the request with the body rewound, so the backend request is rebuilt from scratch,
+// bodies are kept in memory for that, up to "max_request_body_size" in the "config" section.
+type retryConfig struct {
+	Route        string   `mapstructure:"route"`
+	Idempotent   bool     `mapstructure:"idempotent"`
+	MaxAttempts  int      `mapstructure:"max_attempts"`
+	Codes        []string `mapstructure:"codes"`
+	ThriftErrors []string `mapstructure:"thrift_errors"`
+	Backoff      string   `mapstructure:"backoff"`
+	MaxBackoff   string   `mapstructure:"max_backoff"`
+	Budget       float64  `mapstructure:"budget"`
+	MinRetries   int      `mapstructure:"min_retries"`
+	HedgeDelay   string   `mapstructure:"hedge_delay"`
+}
+
+type retryPolicy struct {
+	route        string
+	maxAttempts  int
+	codes        map[codes.Code]bool
+	thriftErrors map[int]bool
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	hedgeDelay   time.Duration
+	budget       *retryBudget
+}
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (p *retryPolicy) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+var thriftErrorTypes = map[string]int{
+	"unknown":     thrift.UNKNOWN_TRANSPORT_EXCEPTION,
+	"not_open":    thrift.NOT_OPEN,
+	"timed_out":   thrift.TIMED_OUT,
+	"end_of_file": thrift.END_OF_FILE,
+}
+
+// loadRetries loads the "retry" section, retries is nil if there is no such section
+func (c *Config) loadRetries() {
+	c.retries = nil
+	if !c.IsSet("retry") {
+		return
+	}
+	items := make([]retryConfig, 0)
+	panicIf(c.UnmarshalKey("retry", &items))
+	for _, item := range items {
+		methods, path := parseRoute(item.Route)
+		if len(path) == 0 {
+			panic("turbo: invalid retry route: " + item.Route)
+		}
+		p, err := newRetryPolicy(item)
+		panicIf(err)
+		if p.hedgeDelay > 0 && RpcType == "thrift" {
+			panic("turbo: hedge_delay is not supported by thrift, route: " + item.Route)
+		}
+		c.retries = setComponent(c.retries, methods, path, p)
+	}
+}
+
+func newRetryPolicy(item retryConfig) (*retryPolicy, error) {
+	if !item.Idempotent {
+		return nil, errors.New("turbo: retry route is not idempotent: " + item.Route +
+			", set 'idempotent: true' if it's safe to call the backend more than once")
+	}
+	p := &retryPolicy{route: item.Route, maxAttempts: item.MaxAttempts,
+		codes: make(map[codes.Code]bool), thriftErrors: make(map[int]bool),
+		budget: &retryBudget{ratio: item.Budget, minRetries: item.MinRetries}}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = 3
+	}
+	if p.budget.ratio <= 0 {
+		p.budget.ratio = 0.2
+	}
+	if p.budget.minRetries <= 0 {
+		p.budget.minRetries = 10
+	}
+	names := item.Codes
+	if len(names) == 0 {
+		names = []string{"UNAVAILABLE"}
+	}
+	for _, name := range names {
+		code, ok := parseCode(name)
+		if !ok {
+			return nil, errors.New("turbo: invalid grpc code in retry: " + name)
+		}
+		p.codes[code] = true
+	}
+	names = item.ThriftErrors
+	if len(names) == 0 {
+		names = []string{"not_open", "end_of_file", "unknown"}
+	}
+	for _, name := range names {
+		t, ok := thriftErrorTypes[strings.ToLower(name)]
+		if !ok {
+			return nil, errors.New("turbo: invalid thrift error in retry: " + name +
+				", should be 'unknown', 'not_open', 'timed_out' or 'end_of_file'")
+		}
+		p.thriftErrors[t] = true
+	}
+	var err error
+	for _, d := range []struct {
+		value        string
+		target       *time.Duration
+		defaultValue time.Duration
+	}{
+		{item.Backoff, &p.backoff, 25 * time.Millisecond},
+		{item.MaxBackoff, &p.maxBackoff, time.Second},
+		{item.HedgeDelay, &p.hedgeDelay, 0},
+	} {
+		*d.target = d.defaultValue
+		if len(d.value) > 0 {
+			if *d.target, err = time.ParseDuration(d.value); err != nil || *d.target < 0 {
+				return nil, errors.New(fmt.Sprintf("turbo: invalid duration in retry: %s", d.value))
+			}
+		}
+	}
+	return p, nil
+}
+
+// parseCode parses a grpc code like "UNAVAILABLE", "Unavailable" or "DEADLINE_EXCEEDED"
+func parseCode(name string) (codes.Code, bool) {
+	name = strings.ToLower(strings.Replace(strings.TrimSpace(name), "_", "", -1))
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.ToLower(c.String()) == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// retryable returns true if err is one of "codes" or "thrift_errors"
+func (p *retryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(thrift.TTransportException); ok {
+		return p.thriftErrors[e.TypeId()]
+	}
+	if s, ok := status.FromError(err); ok {
+		return p.codes[s.Code()]
+	}
+	return false
+}
+
+// backoffOf returns a random wait before the retry after 'attempt' (from 0)
+func (p *retryPolicy) backoffOf(attempt int) time.Duration {
+	d := p.backoff
+	for i := 0; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryBudget allows at most "min_retries" plus "budget" of requests to be retried in a window
+type retryBudget struct {
+	mutex       sync.Mutex
+	ratio       float64
+	minRetries  int
+	windowStart time.Time
+	requests    int
+	retries     int
+}
+
+const retryBudgetWindow = 10 * time.Second
+
+func (b *retryBudget) roll(now time.Time) {
+	if now.Sub(b.windowStart) >= retryBudgetWindow {
+		b.windowStart, b.requests, b.retries = now, 0, 0
+	}
+}
+
+// request records a request
+func (b *retryBudget) request(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.roll(now)
+	b.requests++
+}
+
+// retry returns true, and records a retry, if there is budget left
+func (b *retryBudget) retry(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.roll(now)
+	if float64(b.retries) >= float64(b.minRetries)+b.ratio*float64(b.requests) {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// callWithRetry calls the backend, see callBackend, and retries or hedges the call by the "retry" item of the route, if any
+func callWithRetry(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	cp := component(s.ServerField().Config.retries, req)
+	if cp == nil {
+		return callBackend(s, methodName, resp, req)
+	}
+	p := cp.(*retryPolicy)
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = readBody(resp, req.Body, s.ServerField().Config.MaxRequestBodySize()); err != nil {
+			return nil, err
+		}
+	}
+	p.budget.request(time.Now())
+	if p.hedgeDelay > 0 {
+		return p.hedge(s, methodName, resp, req, body)
+	}
+	return p.retry(s, methodName, resp, req, body)
+}
+
+// attemptRequest returns a copy of req with 'ctx' and a rewound body, switchers read the body and change the context
+func attemptRequest(ctx context.Context, req *http.Request, body []byte) *http.Request {
+	r := req.WithContext(ctx)
+	if body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return r
+}
+
+// retry sends attempts one by one, see retryConfig, each attempt writes to its own buffered ResponseWriter,
+// only the one of the attempt used is written to resp, so headers set by failed attempts don't leak.
+func (p *retryPolicy) retry(s Servable, methodName string, resp http.ResponseWriter, req *http.Request, body []byte) (interface{}, error) {
+	for attempt := 0; ; attempt++ {
+		r := attemptRequest(req.Context(), req, body)
+		buf := newResponseBuffer()
+		started := time.Now()
+		serviceResp, err := callBackend(s, methodName, buf, r)
+		if attempt+1 >= p.maxAttempts || !p.retryable(err) || !p.budget.retry(time.Now()) {
+			buf.copyTo(resp)
+			*req = *r
+			return serviceResp, err
+		}
+		log.Debugf("turbo: retrying %s of %s, attempt %d failed: %s", methodName, p.route, attempt+1, err)
+		reconnect(s, err, started)
+		select {
+		case <-time.After(p.backoffOf(attempt)):
+		case <-req.Context().Done():
+			buf.copyTo(resp)
+			*req = *r
+			return serviceResp, err
+		}
+	}
+}
+
+type attemptResult struct {
+	index       int
+	req         *http.Request
+	resp        *responseBuffer
+	serviceResp interface{}
+	err         error
+}
+
+// hedge sends attempts concurrently, see retryConfig, each attempt writes to its own buffered ResponseWriter,
+// only the one of the attempt used is written to resp, and values put into its context by the switcher,
+// e.g. grpc headers, are copied to req, see copyCallOptions.
+func (p *retryPolicy) hedge(s Servable, methodName string, resp http.ResponseWriter, req *http.Request, body []byte) (interface{}, error) {
+	results := make(chan attemptResult, p.maxAttempts)
+	cancels := make([]context.CancelFunc, 0, p.maxAttempts)
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	launch := func() {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels = append(cancels, cancel)
+		r := attemptRequest(ctx, req, body)
+		buf := newResponseBuffer()
+		index := len(cancels) - 1
+		go func() {
+			serviceResp, err := callBackend(s, methodName, buf, r)
+			results <- attemptResult{index: index, req: r, resp: buf, serviceResp: serviceResp, err: err}
+		}()
+	}
+	launch()
+	inflight := 1
+	timer := time.NewTimer(p.hedgeDelay)
+	defer timer.Stop()
+	var result attemptResult
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) < p.maxAttempts && p.budget.retry(time.Now()) {
+				launch()
+				inflight++
+				timer.Reset(p.hedgeDelay)
+			}
+			continue
+		case result = <-results:
+			inflight--
+		}
+		if p.retryable(result.err) && len(cancels) < p.maxAttempts && p.budget.retry(time.Now()) {
+			log.Debugf("turbo: hedging %s of %s, attempt %d failed: %s", methodName, p.route, result.index+1, result.err)
+			launch()
+			inflight++
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(p.hedgeDelay)
+			continue
+		}
+		if !p.retryable(result.err) || inflight == 0 {
+			break
+		}
+	}
+	result.resp.copyTo(resp)
+	copyCallOptions(req, result.req.Context())
+	return result.serviceResp, result.err
+}
+
+// reconnect reopens the thrift connection after a transport error of a call started at 'since',
+// so the next attempt does not use a broken socket, see thriftClient.reopen
+func reconnect(s Servable, err error, since time.Time) {
+	ts, ok := s.(*ThriftServer)
+	if _, transportError := err.(thrift.TTransportException); !ok || !transportError {
+		return
+	}
+	if err := ts.tClient.reopen(since); err != nil {
+		log.Warn("turbo: failed to reopen thrift connection: ", err)
+	}
+}
@@ -0,0 +1,150 @@
+package turbo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.apache.org/thrift.git/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	_, err := newRetryPolicy(retryConfig{Route: "GET /v1/"})
+	assert.NotNil(t, err)
+	_, err = newRetryPolicy(retryConfig{Route: "GET /v1/", Idempotent: true, Codes: []string{"SOMETIMES"}})
+	assert.Equal(t, "turbo: invalid grpc code in retry: SOMETIMES", err.Error())
+	_, err = newRetryPolicy(retryConfig{Route: "GET /v1/", Idempotent: true, ThriftErrors: []string{"reset"}})
+	assert.NotNil(t, err)
+
+	p, err := newRetryPolicy(retryConfig{Route: "GET /v1/", Idempotent: true,
+		Codes: []string{"DEADLINE_EXCEEDED", "Unavailable"}, ThriftErrors: []string{"timed_out"}, MaxBackoff: "100ms"})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, p.maxAttempts)
+	assert.False(t, p.retryable(nil))
+	assert.False(t, p.retryable(errors.New("eof")))
+	assert.True(t, p.retryable(status.Error(codes.DeadlineExceeded, "")))
+	assert.True(t, p.retryable(status.Error(codes.Unavailable, "")))
+	assert.False(t, p.retryable(status.Error(codes.NotFound, "")))
+	assert.True(t, p.retryable(thrift.NewTTransportException(thrift.TIMED_OUT, "")))
+	assert.False(t, p.retryable(thrift.NewTTransportException(thrift.END_OF_FILE, "")))
+	for i := 0; i < 10; i++ {
+		assert.True(t, p.backoffOf(0) <= 25*time.Millisecond)
+		assert.True(t, p.backoffOf(10) <= 100*time.Millisecond)
+	}
+
+	b := &retryBudget{ratio: 0.5, minRetries: 1}
+	now := time.Now()
+	b.request(now)
+	b.request(now)
+	assert.True(t, b.retry(now))
+	assert.True(t, b.retry(now))
+	assert.False(t, b.retry(now))
+	assert.True(t, b.retry(now.Add(retryBudgetWindow)))
+}
+
+func TestRetry(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"retry": []map[string]interface{}{
+		{"route": "PUT /v1/rules/", "idempotent": true, "backoff": "1ms"},
+	}})
+	var calls int
+	var failure error
+	var request *testRuleRequest
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		calls++
+		resp.Header().Set("X-Attempt", strconv.Itoa(calls))
+		if calls < 3 {
+			resp.WriteHeader(http.StatusConflict)
+		}
+		request = &testRuleRequest{Child: &testRuleChild{}}
+		if err := BuildRequest(s, request, req); err != nil {
+			return nil, err
+		}
+		if calls < 3 {
+			return nil, failure
+		}
+		return request, nil
+	}
+	failure = status.Error(codes.Unavailable, "unavailable")
+	resp := serveTestRequest(s, "PUT", "/v1/rules/12", `{"name":"body"}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 3, calls)
+	// only the response of the attempt used is written
+	assert.Equal(t, []string{"3"}, resp.Header()["X-Attempt"])
+	// the body is read again by each attempt
+	assert.Equal(t, "body", request.Name)
+
+	calls = 0
+	failure = status.Error(codes.NotFound, "not found")
+	serveTestRequest(s, "PUT", "/v1/rules/12", `{"name":"body"}`)
+	assert.Equal(t, 1, calls)
+
+	calls = -10
+	failure = status.Error(codes.Unavailable, "unavailable")
+	serveTestRequest(s, "PUT", "/v1/rules/12", `{"name":"body"}`)
+	assert.Equal(t, -7, calls)
+
+	// bodies kept for retries are limited
+	calls = 0
+	s.Config.configs[maxRequestBodySize] = "4"
+	resp = serveTestRequest(s, "PUT", "/v1/rules/12", `{"name":"body"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Equal(t, 0, calls)
+	delete(s.Config.configs, maxRequestBodySize)
+
+	// routes not in "retry" are called once
+	calls = 0
+	serveTestRequest(s, "POST", "/v1/rules/12", `{"title":"body"}`)
+	assert.Equal(t, 1, calls)
+}
+
+func TestHedge(t *testing.T) {
+	s := newTestServerWith(map[string]interface{}{"retry": []map[string]interface{}{
+		{"route": "GET /v1/rules/", "idempotent": true, "max_attempts": 2, "hedge_delay": "20ms"},
+	}})
+	var calls int32
+	canceled := make(chan bool, 1)
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			resp.Header().Set("X-Attempt", "1")
+			select {
+			case <-req.Context().Done():
+				canceled <- true
+				return nil, req.Context().Err()
+			case <-time.After(time.Second):
+				canceled <- false
+				return nil, errors.New("timeout")
+			}
+		}
+		resp.Header().Set("X-Attempt", "2")
+		return &testRuleRequest{Name: "hedged"}, nil
+	}
+	start := time.Now()
+	resp := serveTestRequest(s, "GET", "/v1/rules/12", "")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "hedged")
+	// only the response of the attempt used is written
+	assert.Equal(t, []string{"2"}, resp.Header()["X-Attempt"])
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+	assert.True(t, <-canceled)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// a retryable failure gets the next attempt at once
+	atomic.StoreInt32(&calls, 0)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, status.Error(codes.Unavailable, "unavailable")
+		}
+		return &testRuleRequest{Name: "retried"}, nil
+	}
+	resp = serveTestRequest(s, "GET", "/v1/rules/12", "")
+	assert.Contains(t, resp.Body.String(), "retried")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteCheck returns "route_check" in config file, which is one of:
+// "warn"(default): route issues are logged when the server starts,
+// "error": loading config panics if there is any route issue,
+// "off": routes are not checked.
+func (c *Config) RouteCheck() string {
+	switch mode := strings.TrimSpace(c.configs[routeCheck]); mode {
+	case "error", "off":
+		return mode
+	}
+	return "warn"
+}
+
+// RouteIssues returns problems found in "urlmapping" and component mappings when config is loaded
+func (c *Config) RouteIssues() []string {
+	return c.routeIssues
+}
+
+// checkRoutes finds route issues, and panics if "route_check" is "error"
+func (c *Config) checkRoutes() {
+	c.routeIssues = nil
+	mode := c.RouteCheck()
+	if mode == "off" {
+		return
+	}
+	c.routeIssues = findRouteIssues(c.mappings)
+	if mode == "error" && len(c.routeIssues) > 0 {
+		panic(errors.New("turbo: route check failed:\n" + strings.Join(c.routeIssues, "\n")))
+	}
+}
+
+func logRouteIssues(c *Config) {
+	for _, issue := range c.routeIssues {
+		log.Warn("route check: ", issue)
+	}
+}
+
+// checkedRoute is a route with a single HTTP method, in registration order
+type checkedRoute struct {
+	method  string
+	pattern string
+	name    string
+	router  *mux.Router
+}
+
+func (r *checkedRoute) String() string {
+	return fmt.Sprintf("'%s %s' (%s)", r.method, r.pattern, r.name)
+}
+
+// findRouteIssues reports:
+// routes that can never be matched because a route registered before them matches the same requests,
+// routes that conflict with a route registered before them on some requests,
+// component patterns that match no route,
+// components that never apply because a component of the same kind registered before them wins on every route.
+// Route variables are replaced with sample values which match their regexps,
+// so the analysis is a heuristic and may miss overlaps between two patterns with variables.
+func findRouteIssues(mappings map[string][][3]string) []string {
+	issues := make([]string, 0)
+	routes := make([]*checkedRoute, 0)
+	for _, m := range mappings[urlServiceMaps] {
+		for _, method := range strings.Split(m[0], ",") {
+			r := &checkedRoute{method: method, pattern: m[1], name: m[2]}
+			r.router = mux.NewRouter()
+			r.router.NewRoute().Path(m[1]).Methods(method)
+			routes = append(routes, r)
+		}
+	}
+	for j, r := range routes {
+		req := sampleRequest(r.method, r.pattern)
+		for _, earlier := range routes[:j] {
+			if earlier.method != r.method {
+				continue
+			}
+			if earlier.pattern == r.pattern {
+				issues = append(issues, fmt.Sprintf("route %s is unreachable, it is already mapped by %s", r, earlier))
+				break
+			}
+			if !earlier.router.Match(req, &mux.RouteMatch{}) {
+				continue
+			}
+			if matchPathVar.MatchString(r.pattern) {
+				issues = append(issues, fmt.Sprintf("route %s conflicts with %s, some requests are handled by %s",
+					r, earlier, earlier.name))
+			} else {
+				issues = append(issues, fmt.Sprintf("route %s is unreachable, it is shadowed by %s", r, earlier))
+			}
+			break
+		}
+	}
+	kinds := []struct {
+		name string
+		key  string
+	}{
+		{"interceptor", interceptors},
+		{"preprocessor", preprocessors},
+		{"postprocessor", postprocessors},
+		{"hijacker", hijackers},
+	}
+	for _, kind := range kinds {
+		issues = append(issues, componentIssues(kind.name, mappings[kind.key], routes)...)
+	}
+	return issues
+}
+
+func componentIssues(kind string, components [][3]string, routes []*checkedRoute) []string {
+	issues := make([]string, 0)
+	var all *mux.Router
+	for i, m := range components {
+		all = setComponent(all, strings.Split(m[0], ","), m[1], componentName(strconv.Itoa(i)))
+	}
+	for i, m := range components {
+		single := setComponent(nil, strings.Split(m[0], ","), m[1], componentName(strconv.Itoa(i)))
+		methods := strings.Split(m[0], ",")
+		matched, applied := false, false
+		shadowedBy := -1
+		for _, r := range routes {
+			req := sampleRequest(r.method, r.pattern)
+			if component(single, req) == nil {
+				if !componentCovers(methods, m[1], r) {
+					continue
+				}
+				req = sampleRequest(r.method, m[1])
+			}
+			matched = true
+			h := component(all, req)
+			if h == nil {
+				applied = true
+				break
+			}
+			winner, err := strconv.Atoi(string(h.(componentName)))
+			if err != nil || winner == i {
+				applied = true
+				break
+			}
+			if shadowedBy < 0 {
+				shadowedBy = winner
+			}
+		}
+		desc := fmt.Sprintf("%s '%s %s' (%s)", kind, m[0], m[1], m[2])
+		switch {
+		case !matched:
+			issues = append(issues, desc+" matches no route")
+		case !applied && shadowedBy >= 0:
+			s := components[shadowedBy]
+			issues = append(issues, fmt.Sprintf("%s never applies, all routes it matches are handled by %s '%s %s' (%s)",
+				desc, kind, s[0], s[1], s[2]))
+		}
+	}
+	return issues
+}
+
+// componentCovers returns true if route 'r' matches a sample request of the component pattern,
+// e.g. component "/hello/{n:[0-9]+}" is used by route "/hello/{name}", though "/hello/x" doesn't match the component.
+func componentCovers(methods []string, pattern string, r *checkedRoute) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return false
+	}
+	for _, method := range methods {
+		if method == r.method && r.router.Match(sampleRequest(method, pattern), &mux.RouteMatch{}) {
+			return true
+		}
+	}
+	return false
+}
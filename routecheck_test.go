@@ -0,0 +1,81 @@
+package turbo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindRouteIssues(t *testing.T) {
+	mappings := map[string][][3]string{
+		urlServiceMaps: {
+			{"GET", "/hello/{your_Name}", "SayHello"},
+			{"GET", "/hello/world", "SayWorld"},
+			{"POST", "/hello/world", "PostWorld"},
+			{"GET,POST", "/eat_apple/{num:[0-9]+}", "EatApple"},
+			{"GET", "/eat_apple/{name}", "EatNamedApple"},
+			{"GET", "/fruit/{name}", "GetFruit"},
+			{"GET", "/fruit/{id:[0-9]+}", "GetFruitByID"},
+			{"GET", "/eat_apple/{num:[0-9]+}", "EatAppleAgain"},
+		},
+		interceptors: {
+			{"GET", "/", "LogInterceptor"},
+			{"GET", "/hello/world", "WorldInterceptor"},
+		},
+		preprocessors: {
+			{"GET", "/eat_apple/{n:[0-9]+}", "pre"},
+			{"GET", "/helo", "typo"},
+		},
+		hijackers: {
+			{"POST", "/eat_apple/{name}", "hijacker"},
+		},
+	}
+	issues := findRouteIssues(mappings)
+	assert.Equal(t, []string{
+		"route 'GET /hello/world' (SayWorld) is unreachable, it is shadowed by 'GET /hello/{your_Name}' (SayHello)",
+		"route 'GET /fruit/{id:[0-9]+}' (GetFruitByID) conflicts with 'GET /fruit/{name}' (GetFruit), some requests are handled by GetFruit",
+		"route 'GET /eat_apple/{num:[0-9]+}' (EatAppleAgain) is unreachable, it is already mapped by 'GET /eat_apple/{num:[0-9]+}' (EatApple)",
+		"interceptor 'GET /hello/world' (WorldInterceptor) never applies, all routes it matches are handled by interceptor 'GET /' (LogInterceptor)",
+		"preprocessor 'GET /helo' (typo) matches no route",
+	}, issues)
+}
+
+func TestCheckRoutes(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	assert.Equal(t, "warn", c.RouteCheck())
+	assert.Equal(t, []string{}, c.RouteIssues())
+
+	c.mappings[urlServiceMaps] = append(c.mappings[urlServiceMaps], [3]string{"GET", "/hello", "SayHelloAgain"})
+	c.configs[routeCheck] = "off"
+	c.checkRoutes()
+	assert.Nil(t, c.RouteIssues())
+
+	c.configs[routeCheck] = "error"
+	assert.Panics(t, func() { c.checkRoutes() })
+}
+
+func TestReloadConfigWithRouteIssues(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	old := s.Config
+	data, err := ioutil.ReadFile("test/service_test.yaml")
+	assert.Nil(t, err)
+	dir, err := ioutil.TempDir("", "turbo")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s.Config.File = filepath.Join(dir, "service.yaml")
+
+	conf := strings.Replace(string(data), "config:\n", "config:\n  route_check: error\n", 1)
+	broken := strings.Replace(conf, "urlmapping:\n", "urlmapping:\n  - GET /hello SayHelloAgain\n", 1)
+	assert.Nil(t, ioutil.WriteFile(s.Config.File, []byte(broken), 0644))
+	assert.Nil(t, s.loadConfigNoPanic())
+	assert.True(t, s.Config == old)
+
+	assert.Nil(t, ioutil.WriteFile(s.Config.File, []byte(conf), 0644))
+	c := s.loadConfigNoPanic()
+	assert.NotNil(t, c)
+	assert.Equal(t, "error", c.RouteCheck())
+}
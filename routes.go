@@ -0,0 +1,271 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gorilla/mux"
+)
+
+const adminPathPrefix = "/_turbo"
+
+// RouteInfo describes a route in the effective route table, and the components resolved for it
+type RouteInfo struct {
+	HTTPMethod   string   `json:"http_method"`
+	Path         string   `json:"path"`
+	RPCMethod    string   `json:"rpc_method"`
+	Backend      string   `json:"backend"`
+	Interceptors []string `json:"interceptors"`
+	// CommonInterceptorsApply is true if no Interceptor is assigned to this route, then common Interceptors are used
+	CommonInterceptorsApply bool     `json:"common_interceptors_apply"`
+	CommonInterceptors      []string `json:"common_interceptors,omitempty"`
+	Preprocessor            string   `json:"preprocessor,omitempty"`
+	Postprocessor           string   `json:"postprocessor,omitempty"`
+	Hijacker                string   `json:"hijacker,omitempty"`
+}
+
+// componentName is a placeholder handler for components declared in config file
+type componentName string
+
+// ServeHTTP is an empty func, only for implementing http.Handler
+func (n componentName) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// Routes returns the route table declared in config file,
+// components are resolved by their names in config file, with the same matching rules used at runtime.
+func (c *Config) Routes() []*RouteInfo {
+	routers := make(map[int]*mux.Router)
+	keys := map[int]string{
+		rInterceptor:   interceptors,
+		rPreprocessor:  preprocessors,
+		rPostprocessor: postprocessors,
+		rHijacker:      hijackers,
+	}
+	for kind, key := range keys {
+		for _, m := range c.mappings[key] {
+			routers[kind] = setComponent(routers[kind], strings.Split(m[0], ","), m[1], componentName(m[2]))
+		}
+	}
+	return c.routeTable(func(kind int, req *http.Request) []string {
+		if h := component(routers[kind], req); h != nil {
+			return strings.Split(string(h.(componentName)), ",")
+		}
+		return nil
+	}, nil)
+}
+
+// Routes returns the effective route table of a running server,
+// components are resolved from Components, which may be registered either in config file or in code.
+func (s *Server) Routes() []*RouteInfo {
+	c := s.Components
+	common := make([]string, 0)
+	for _, i := range c.CommonInterceptors() {
+		common = append(common, c.nameOf(i))
+	}
+	return s.Config.routeTable(func(kind int, req *http.Request) []string {
+		switch kind {
+		case rInterceptor:
+			names := make([]string, 0)
+			for _, i := range c.Interceptors(req) {
+				names = append(names, c.nameOf(i))
+			}
+			return names
+		case rPreprocessor:
+			if p := c.Preprocessor(req); p != nil {
+				return []string{c.nameOf(p)}
+			}
+		case rPostprocessor:
+			if p := c.Postprocessor(req); p != nil {
+				return []string{c.nameOf(p)}
+			}
+		case rHijacker:
+			if h := c.Hijacker(req); h != nil {
+				return []string{c.nameOf(h)}
+			}
+		}
+		return nil
+	}, common)
+}
+
+func (c *Config) routeTable(resolve func(kind int, req *http.Request) []string, common []string) []*RouteInfo {
+	routes := make([]*RouteInfo, 0)
+	backend := c.backend()
+	for _, m := range c.mappings[urlServiceMaps] {
+		for _, method := range strings.Split(m[0], ",") {
+			req := sampleRequest(method, m[1])
+			info := &RouteInfo{
+				HTTPMethod:   method,
+				Path:         m[1],
+				RPCMethod:    m[2],
+				Backend:      backend,
+				Interceptors: resolve(rInterceptor, req),
+			}
+			if len(info.Interceptors) == 0 {
+				info.Interceptors = []string{}
+				info.CommonInterceptorsApply = true
+				info.CommonInterceptors = common
+			}
+			info.Preprocessor = firstName(resolve(rPreprocessor, req))
+			info.Postprocessor = firstName(resolve(rPostprocessor, req))
+			info.Hijacker = firstName(resolve(rHijacker, req))
+			routes = append(routes, info)
+		}
+	}
+	return routes
+}
+
+func (c *Config) backend() string {
+	if RpcType == "thrift" {
+		return "thrift://" + c.ThriftServiceHost() + ":" + c.ThriftServicePort() + "/" + c.ThriftServiceName()
+	}
+	return "grpc://" + c.GrpcServiceHost() + ":" + c.GrpcServicePort() + "/" + c.GrpcServiceName()
+}
+
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// nameOf returns the registered name of a component,
+// or the func name, or the type name if the component is not registered with a name.
+func (c *Components) nameOf(com interface{}) string {
+	target := reflect.ValueOf(com)
+	names := make([]string, 0, len(c.registeredComponents))
+	for name := range c.registeredComponents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := reflect.ValueOf(c.registeredComponents[name])
+		if v.Kind() != target.Kind() {
+			continue
+		}
+		if (v.Kind() == reflect.Func || v.Kind() == reflect.Ptr) && v.Pointer() == target.Pointer() {
+			return name
+		}
+	}
+	if target.Kind() == reflect.Func {
+		return runtime.FuncForPC(target.Pointer()).Name()
+	}
+	return fmt.Sprintf("%T", com)
+}
+
+var sampleValues = []string{"0", "1", "a", "x", "a0", "abc", "true"}
+
+// sampleRequest builds a request whose url matches 'urlPattern', route variables are replaced by sample values
+func sampleRequest(method, urlPattern string) *http.Request {
+	path := matchPathVar.ReplaceAllStringFunc(urlPattern, func(v string) string {
+		parts := matchPathVar.FindStringSubmatch(v)
+		if len(parts[3]) == 0 {
+			return "x"
+		}
+		r, err := regexp.Compile("^(" + parts[3] + ")$")
+		if err != nil {
+			return "x"
+		}
+		for _, sample := range sampleValues {
+			if r.MatchString(sample) {
+				return sample
+			}
+		}
+		return "x"
+	})
+	return httptest.NewRequest(method, path, nil)
+}
+
+// WriteRoutes prints a route table in aligned columns
+func WriteRoutes(w io.Writer, routes []*RouteInfo) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHOD\tPATH\tRPC\tBACKEND\tINTERCEPTORS\tPREPROCESSOR\tPOSTPROCESSOR\tHIJACKER")
+	for _, r := range routes {
+		interceptors := strings.Join(r.Interceptors, ",")
+		if r.CommonInterceptorsApply {
+			interceptors = "(common)"
+			if len(r.CommonInterceptors) > 0 {
+				interceptors += " " + strings.Join(r.CommonInterceptors, ",")
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.HTTPMethod, r.Path, r.RPCMethod, r.Backend,
+			interceptors, orDash(r.Preprocessor), orDash(r.Postprocessor), orDash(r.Hijacker))
+	}
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
+
+// AdminEnabled returns true if "admin_enabled" in config file is "true",
+// admin endpoints are served under "/_turbo/", only to principals in "admin_principals", see adminHandler.
+func (c *Config) AdminEnabled() bool {
+	return c.configs[adminEnabled] == "true"
+}
+
+// AdminPrincipals returns "admin_principals" in config file, a comma separated list of principals
+// allowed to call admin endpoints, e.g. "ops,deployer", no one is allowed by default.
+func (c *Config) AdminPrincipals() []string {
+	principals := make([]string, 0)
+	for _, p := range strings.Split(c.configs[adminPrincipals], ",") {
+		if p = strings.TrimSpace(p); len(p) > 0 {
+			principals = append(principals, p)
+		}
+	}
+	return principals
+}
+
+// adminHandler serves an admin endpoint only to principals in "admin_principals",
+// they are verified by the "auth" or "jwt" section, whose routes must cover "/_turbo/", e.g.
+//
+//	auth:
+//	  routes:
+//	    - GET,DELETE /_turbo/ apikey
+func adminHandler(s Servable, h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if !authenticated(s, resp, req) {
+			return
+		}
+		p, ok := PrincipalOf(req.Context())
+		if !ok {
+			http.Error(resp, "turbo: missing credentials", http.StatusUnauthorized)
+			return
+		}
+		for _, name := range s.ServerField().Config.AdminPrincipals() {
+			if name == p.Name {
+				h(resp, req)
+				return
+			}
+		}
+		http.Error(resp, "turbo: "+p.Name+" is not an admin principal", http.StatusForbidden)
+	}
+}
+
+// routesHandler serves the effective route table as json at "/_turbo/routes"
+func routesHandler(s Servable) func(http.ResponseWriter, *http.Request) {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		data, err := json.MarshalIndent(s.ServerField().Routes(), "", "  ")
+		if err != nil {
+			http.Error(resp, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write(data)
+	}
+}
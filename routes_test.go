@@ -0,0 +1,104 @@
+package turbo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigRoutes(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	routes := c.Routes()
+	assert.Equal(t, 6, len(routes))
+
+	hello := routes[0]
+	assert.Equal(t, "GET", hello.HTTPMethod)
+	assert.Equal(t, "/hello", hello.Path)
+	assert.Equal(t, "SayHello", hello.RPCMethod)
+	assert.Equal(t, "grpc://127.0.0.1:50051/YourService", hello.Backend)
+	assert.Equal(t, []string{"LogInterceptor"}, hello.Interceptors)
+	assert.False(t, hello.CommonInterceptorsApply)
+	assert.Equal(t, "preprocessor", hello.Preprocessor)
+	assert.Equal(t, "postprocessor", hello.Postprocessor)
+	assert.Equal(t, "hijacker", hello.Hijacker)
+	assert.Equal(t, "POST", routes[1].HTTPMethod)
+	assert.Equal(t, "hijacker", routes[1].Hijacker)
+
+	eat := routes[2]
+	assert.Equal(t, "/eat_apple/{num:[0-9]+}", eat.Path)
+	assert.Equal(t, []string{}, eat.Interceptors)
+	assert.True(t, eat.CommonInterceptorsApply)
+	assert.Equal(t, "", eat.Hijacker)
+
+	var buf bytes.Buffer
+	WriteRoutes(&buf, routes)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 7, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "METHOD"))
+	assert.Contains(t, lines[3], "(common)")
+}
+
+func TestSampleRequest(t *testing.T) {
+	assert.Equal(t, "/eat_apple/0", sampleRequest("GET", "/eat_apple/{num:[0-9]+}").URL.Path)
+	assert.Equal(t, "/v1/rules/x", sampleRequest("GET", "/v1/rules/{id}").URL.Path)
+	assert.Equal(t, "/a/b/a", sampleRequest("GET", "/a/b/{n:[a-c]+}").URL.Path)
+}
+
+type testRoutesInterceptor struct {
+	BaseInterceptor
+	name string
+}
+
+func TestServerRoutes(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	s.Components.registeredComponents = map[string]interface{}{"LogInterceptor": &testRoutesInterceptor{name: "log"}}
+	common := &testRoutesInterceptor{name: "common"}
+	s.Components.SetCommonInterceptor(common)
+	s.Components.Intercept([]string{"GET"}, "/hello", s.Components.registeredComponents["LogInterceptor"].(Interceptor))
+	s.Components.SetPreprocessor([]string{"GET"}, "/eat_apple/{num:[0-9]+}", func(http.ResponseWriter, *http.Request) error { return nil })
+
+	resp := serveTestRequest(s, "GET", "/_turbo/routes", "")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	s.Config.configs[adminEnabled] = "true"
+	resp = serveTestRequest(s, "GET", "/_turbo/routes", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	dir, _ := ioutil.TempDir("", "turbo_routes")
+	defer os.RemoveAll(dir)
+	auth := testAuthConfig(t, dir)
+	auth["routes"] = []string{"GET,DELETE /_turbo/ apikey"}
+	s.Config.Set("auth", auth)
+	s.Config.loadAuth()
+	resp = serveTestRequest(s, "GET", "/_turbo/routes", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = serveAdminRequest(s, "GET", "/_turbo/routes", "key-x")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp = serveAdminRequest(s, "GET", "/_turbo/routes", "key-a")
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	s.Config.configs[adminPrincipals] = "ops, Partner-A"
+	resp = serveAdminRequest(s, "GET", "/_turbo/routes", "key-a")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var routes []*RouteInfo
+	assert.Nil(t, json.Unmarshal(resp.Body.Bytes(), &routes))
+	assert.Equal(t, 6, len(routes))
+	assert.Equal(t, []string{"LogInterceptor"}, routes[0].Interceptors)
+	assert.True(t, routes[1].CommonInterceptorsApply)
+	assert.Equal(t, []string{"*turbo.testRoutesInterceptor"}, routes[1].CommonInterceptors)
+	assert.Contains(t, routes[2].Preprocessor, "TestServerRoutes")
+}
+
+func serveAdminRequest(s *Server, method, url, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	req.Header.Set("X-Api-Key", apiKey)
+	resp := httptest.NewRecorder()
+	router(s).ServeHTTP(resp, req)
+	return resp
+}
@@ -31,12 +31,23 @@ var switcherFunc switcher
 
 func router(s Servable) *mux.Router {
 	r := mux.NewRouter()
+	if p := s.ServerField().Config.OpenAPIPath(); len(p) > 0 {
+		r.HandleFunc(p, openAPIHandler(s)).Methods("GET")
+	}
+	if s.ServerField().Config.AdminEnabled() {
+		r.HandleFunc(adminPathPrefix+"/routes", adminHandler(s, routesHandler(s))).Methods("GET")
+		r.HandleFunc(adminPathPrefix+"/cache", adminHandler(s, cachePurgeHandler(s))).Methods("DELETE")
+	}
 	for _, v := range s.ServerField().Config.mappings[urlServiceMaps] {
 		httpMethods := strings.Split(v[0], ",")
 		path := v[1]
 		methodName := v[2]
 		r.HandleFunc(path, handler(s, methodName)).Methods(httpMethods...)
 	}
+	// preflight requests of CORS
+	for _, path := range s.ServerField().Config.corsPaths() {
+		r.HandleFunc(path, preflightHandler(s)).Methods("OPTIONS")
+	}
 	return r
 }
 
@@ -59,6 +70,10 @@ func components(req *http.Request) *Components {
 func handler(s Servable, methodName string) func(http.ResponseWriter, *http.Request) {
 	return func(resp http.ResponseWriter, req *http.Request) {
 		copyComponentsPtr(s, req)
+		setCORSHeaders(s, resp, req)
+		if !authenticated(s, resp, req) || rateLimited(s, resp, req) {
+			return
+		}
 		parseRequestForm(req)
 		interceptors := getInterceptors(s, req)
 		req, err := doBefore(&interceptors, resp, req)
@@ -83,7 +98,7 @@ func doBefore(interceptors *[]Interceptor, resp http.ResponseWriter, req *http.R
 	for index, i := range *interceptors {
 		err = i.Before(resp, req)
 		if err != nil {
-			log.Errorln("error in Before(): ", err.Error())
+			log.WithField("client_ip", ClientIP(req)).Errorln("error in Before(): ", err.Error())
 			*interceptors = (*interceptors)[0:index]
 			return req, err
 		}
@@ -101,7 +116,10 @@ func doRequest(s Servable, methodName string, resp http.ResponseWriter, req *htt
 		components(req).errorHandlerFunc()(resp, req, err)
 		return
 	}
-	serviceResp, err := switcherFunc(s, methodName, resp, req)
+	serviceResp, ok, err := callService(s, methodName, resp, req)
+	if !ok {
+		return
+	}
 	if err != nil {
 		components(req).errorHandlerFunc()(resp, req, err)
 		return
@@ -135,6 +153,18 @@ func WithCallOptions(req *http.Request, header *metadata.MD, trailer *metadata.M
 	*req = *req.WithContext(ctx)
 }
 
+// copyCallOptions puts header, trailer and peer in ctx, if any, into the context of req, see WithCallOptions,
+// it's for calls made with a copy of req.
+func copyCallOptions(req *http.Request, ctx context.Context) {
+	header, ok := ctx.Value(headerKey{}).(*metadata.MD)
+	if !ok {
+		return
+	}
+	trailer, _ := ctx.Value(trailerKey{}).(*metadata.MD)
+	p, _ := ctx.Value(peerKey{}).(*peer.Peer)
+	WithCallOptions(req, header, trailer, p)
+}
+
 // GrpcMetadataHeader returns the header in metadata
 func GrpcMetadataHeader(ctx context.Context) *metadata.MD {
 	return ctx.Value(headerKey{}).(*metadata.MD)
@@ -168,19 +198,34 @@ func doPostprocessor(s Servable, resp http.ResponseWriter, req *http.Request, se
 		return
 	}
 
-	// return as json
-	m := Marshaler{
-		FilterProtoJson: s.ServerField().Config.FilterProtoJson(),
-		EmitZeroValues:  s.ServerField().Config.FilterProtoJsonEmitZeroValues(),
-		Int64AsNumber:   s.ServerField().Config.FilterProtoJsonInt64AsNumber(),
+	if rule, ok := httpRuleOf(s, req); ok && len(rule.responseBody) > 0 {
+		serviceResponse = responseBody(serviceResponse, rule.responseBody)
+	}
+	// return as json, or in the media type asked for, see Components.SetEncoder
+	mediaType, encode := components(req).encoderOf(req)
+	m := s.ServerField().Config.Marshaler()
+	data, err := m.JSON(serviceResponse)
+	// prune fields not selected by "fields" query param or "X-Fields" header
+	if mask, ok := fieldMaskOf(req); ok && err == nil {
+		data, err = mask.pruneJSON(data)
 	}
-	jsonBytes, err := m.JSON(serviceResponse)
 	if err == nil {
-		resp.Write(jsonBytes)
+		data, err = encode(serviceResponse, data)
+	}
+	if err == nil {
+		if len(resp.Header().Get("Content-Type")) == 0 {
+			resp.Header().Set("Content-Type", mediaType)
+		}
+		resp.Header().Add("Vary", "Accept")
+		if route, _, ok := cacheRouteOf(s, req); ok && route.ttl > 0 && notModified(resp, req, data) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+		resp.Write(data)
 	} else {
 		log.Println(err.Error())
-		resp.Write([]byte(fmt.Sprintf("turbo: encounter error while converting response to json "+
-			"in doPostprocessor() for %s, error: %s", req.URL, err)))
+		resp.Write([]byte(fmt.Sprintf("turbo: encounter error while converting response to %s "+
+			"in doPostprocessor() for %s, error: %s", mediaType, req.URL, err)))
 	}
 }
 
@@ -189,7 +234,7 @@ func doAfter(interceptors []Interceptor, resp http.ResponseWriter, req *http.Req
 	for i := l - 1; i >= 0; i-- {
 		err = interceptors[i].After(resp, req)
 		if err != nil {
-			log.Errorln("turbo: error in After(): ", err.Error())
+			log.WithField("client_ip", ClientIP(req)).Errorln("turbo: error in After(): ", err.Error())
 		}
 	}
 	return nil
@@ -433,49 +478,127 @@ func reflectSliceValue(fieldType reflect.Type, fieldValue reflect.Value, v strin
 }
 
 func findValue(fieldName string, req *http.Request) (string, bool) {
-	lowerCasesName := strings.ToLower(fieldName)
-	snakeCaseName := ToSnakeCase(fieldName)
+	return FindValue(req, fieldName, strings.ToLower(fieldName), ToSnakeCase(fieldName))
+}
 
-	v, ok := req.Form[lowerCasesName]
-	if ok && len(v) > 0 {
-		return v[0], true
+func BuildRequest(s Servable, v proto.Message, req *http.Request) error {
+	var err error
+	if rule, ok := httpRuleOf(s, req); ok {
+		err = buildRequestWithHTTPRule(s, v, req, rule)
+	} else if contentTypes, ok := req.Header["Content-Type"]; ok && contentTypes[0] == "application/json" {
+		err = unmarshalRequest(v, req)
+	} else {
+		BuildStruct(s, reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
 	}
-	v, ok = req.Form[snakeCaseName]
-	if ok && len(v) > 0 {
-		return v[0], true
+	if err == nil {
+		setFieldMask(s, v, req)
 	}
-	ctxValue := req.Context().Value(fieldName)
-	if ctxValue != nil {
-		return ctxValue.(string), true
+	return err
+}
+
+// httpRuleOf returns the google.api.http options of the route matched by req, if the route is generated from annotations
+func httpRuleOf(s Servable, req *http.Request) (httpRule, bool) {
+	route := mux.CurrentRoute(req)
+	if route == nil {
+		return httpRule{}, false
 	}
-	ctxValue = req.Context().Value(lowerCasesName)
-	if ctxValue != nil {
-		return ctxValue.(string), true
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return httpRule{}, false
 	}
-	ctxValue = req.Context().Value(snakeCaseName)
-	if ctxValue != nil {
-		return ctxValue.(string), true
+	rule, ok := s.ServerField().Config.httpRules[req.Method+" "+tpl]
+	return rule, ok
+}
+
+// buildRequestWithHTTPRule builds a request following the semantics of google.api.HttpRule:
+// body "*" maps the whole request body to v, fields not bound by path params are ignored in query params,
+// body "field" maps the request body to that field, other fields come from path params and query params,
+// an empty body means there is no request body, all fields come from path params and query params.
+func buildRequestWithHTTPRule(s Servable, v proto.Message, req *http.Request, rule httpRule) error {
+	if rule.body != "*" {
+		BuildStruct(s, reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
 	}
-	return "", false
+	if err := buildRequestBody(v, req, rule); err != nil {
+		return err
+	}
+	setFieldPaths(reflect.ValueOf(v).Elem(), req)
+	return nil
 }
 
-func BuildRequest(s Servable, v proto.Message, req *http.Request) error {
-	var err error
-	if contentTypes, ok := req.Header["Content-Type"]; ok && contentTypes[0] == "application/json" {
-		buf := new(bytes.Buffer)
-		buf.ReadFrom(req.Body)
-		bodyStr := buf.String()
-		unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
-		err = unmarshaler.Unmarshal(strings.NewReader(bodyStr), v)
-		if err != nil {
+// buildRequestBody unmarshals the request body into v, or into the field of v named by the "body" of a HttpRule
+func buildRequestBody(v proto.Message, req *http.Request, rule httpRule) error {
+	if len(rule.body) == 0 || req.Body == nil {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(req.Body)
+	if buf.Len() == 0 {
+		return nil
+	}
+	unmarshaler := &jsonpb.Unmarshaler{AllowUnknownFields: true}
+	if rule.body == "*" {
+		if err := unmarshaler.Unmarshal(bytes.NewReader(buf.Bytes()), v); err != nil {
 			return errors.New(fmt.Sprintf("turbo: failed to BuildRequest for json api, "+
-				"request body: %s, error: %s", bodyStr, err))
+				"request body: %s, error: %s", buf.String(), err))
 		}
 		setPathParams(reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
-	} else {
-		BuildStruct(s, reflect.TypeOf(v).Elem(), reflect.ValueOf(v).Elem(), req)
+		return nil
 	}
-	return err
+	index, ok := protoFieldIndex(reflect.TypeOf(v).Elem(), rule.body)
+	if !ok {
+		return errors.New(fmt.Sprintf("turbo: no such field[%s] in %s", rule.body, reflect.TypeOf(v).Elem().Name()))
+	}
+	field := reflect.ValueOf(v).Elem().Field(index)
+	var message proto.Message
+	if field.Kind() == reflect.Ptr {
+		message, _ = reflect.New(field.Type().Elem()).Interface().(proto.Message)
+	}
+	if message == nil {
+		return errors.New(fmt.Sprintf("turbo: body field[%s] of %s is not a message", rule.body, reflect.TypeOf(v).Elem().Name()))
+	}
+	if err := unmarshaler.Unmarshal(bytes.NewReader(buf.Bytes()), message); err != nil {
+		return errors.New(fmt.Sprintf("turbo: failed to BuildRequest for json api, "+
+			"request body: %s, error: %s", buf.String(), err))
+	}
+	setParams(field.Type().Elem(), reflect.ValueOf(message).Elem(), boundValues(req))
+	field.Set(reflect.ValueOf(message))
+	return nil
+}
+
+// responseBody returns the field named 'fieldName' in serviceResponse, which is the "response_body" of a google.api.http annotation
+func responseBody(serviceResponse interface{}, fieldName string) interface{} {
+	if d, ok := serviceResponse.(*dynamicJSON); ok {
+		return d.responseBody(fieldName)
+	}
+	v := reflect.ValueOf(serviceResponse)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return serviceResponse
+	}
+	index, ok := protoFieldIndex(v.Elem().Type(), fieldName)
+	if !ok {
+		return serviceResponse
+	}
+	return v.Elem().Field(index).Interface()
+}
+
+// protoFieldIndex returns the index of the struct field whose original proto name, or go name, is 'name'
+func protoFieldIndex(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Name == name {
+			return i, true
+		}
+		protoTag := strings.TrimSpace(field.Tag.Get("protobuf"))
+		if len(protoTag) == 0 {
+			continue
+		}
+		var prop proto.Properties
+		prop.Parse(protoTag)
+		if prop.OrigName == name || prop.JSONName == name {
+			return i, true
+		}
+	}
+	return 0, false
 }
 
 func BuildThriftRequest(s Servable, args interface{}, req *http.Request, buildStructArg func(s Servable, typeName string, req *http.Request) (v reflect.Value, err error)) ([]reflect.Value, error) {
@@ -501,17 +624,75 @@ func BuildThriftRequest(s Servable, args interface{}, req *http.Request, buildSt
 	return params, err
 }
 
+// setFieldPaths sets path params named by field paths, like "{user.id}" of google.api.http path templates,
+// to the nested fields of struct v they name, other fields named "id" are not set,
+// nested struct pointers are allocated if they are nil, values bound by bindValue win over path params.
+func setFieldPaths(v reflect.Value, req *http.Request) {
+	bound := boundValues(req)
+	for name, value := range mux.Vars(req) {
+		fieldPath := strings.Split(name, ".")
+		if _, ok := bound[strings.ToLower(fieldPath[len(fieldPath)-1])]; ok || len(fieldPath) == 1 {
+			continue
+		}
+		target := v
+		for i, fieldName := range fieldPath {
+			index, ok := protoFieldIndex(target.Type(), fieldName)
+			if !ok {
+				target = reflect.Value{}
+				break
+			}
+			target = target.Field(index)
+			if i == len(fieldPath)-1 {
+				break
+			}
+			if target.Kind() != reflect.Ptr || target.Type().Elem().Kind() != reflect.Struct {
+				target = reflect.Value{}
+				break
+			}
+			if target.IsNil() {
+				target.Set(reflect.New(target.Type().Elem()))
+			}
+			target = target.Elem()
+		}
+		if target.IsValid() {
+			logErrorIf(setValue(target.Type(), target, value))
+		}
+	}
+}
+
+// setPathParams sets path params to fields of a struct, and values bound by bindValue, which win over them
 func setPathParams(theType reflect.Type, theValue reflect.Value, req *http.Request) {
+	setParams(theType, theValue, pathParams(req))
+}
+
+// pathParams returns path params of req, merged with values bound by bindValue, which win over them
+func pathParams(req *http.Request) map[string]string {
+	params := mux.Vars(req)
+	bound := boundValues(req)
+	if len(bound) == 0 {
+		return params
+	}
+	for k, v := range params {
+		if _, ok := bound[k]; !ok {
+			bound[k] = v
+		}
+	}
+	return bound
+}
+
+// setParams sets values in 'params' to fields of a struct recursively, see findPathParamValue
+func setParams(theType reflect.Type, theValue reflect.Value, params map[string]string) {
 	fieldNum := theType.NumField()
-	pathParams := mux.Vars(req)
 	for i := 0; i < fieldNum; i++ {
 		fieldName := theType.Field(i).Name
 		fieldValue := theValue.FieldByName(fieldName)
 		if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
-			setPathParams(fieldValue.Type().Elem(), fieldValue.Elem(), req)
+			if !fieldValue.IsNil() {
+				setParams(fieldValue.Type().Elem(), fieldValue.Elem(), params)
+			}
 			continue
 		}
-		v, ok := findPathParamValue(fieldName, pathParams)
+		v, ok := findPathParamValue(fieldName, params)
 		if !ok {
 			continue
 		}
@@ -0,0 +1,126 @@
+package turbo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRuleChild struct {
+	Title string `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Id    int64  `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (t *testRuleChild) Reset()         { *t = testRuleChild{} }
+func (t *testRuleChild) String() string { return "" }
+func (t *testRuleChild) ProtoMessage()  {}
+
+type testRuleRequest struct {
+	Id    int64          `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name  string         `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Child *testRuleChild `protobuf:"bytes,3,opt,name=child,proto3" json:"child,omitempty"`
+}
+
+func (t *testRuleRequest) Reset()         { *t = testRuleRequest{} }
+func (t *testRuleRequest) String() string { return "" }
+func (t *testRuleRequest) ProtoMessage()  {}
+
+func newTestServer(configFile string) *Server {
+	return &Server{
+		Config:     NewConfig("grpc", configFile),
+		Components: &Components{routers: make(map[int]*mux.Router)},
+	}
+}
+
+// newTestConfig loads the test config file with 'sections' set over those in it, e.g. {"ratelimit": [...]}
+func newTestConfig(sections map[string]interface{}) *Config {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	for key, value := range sections {
+		c.Set(key, value)
+	}
+	c.loadServiceConfig()
+	return c
+}
+
+// newTestServerWith returns a test server with 'sections' set in its config, see newTestConfig
+func newTestServerWith(sections map[string]interface{}) *Server {
+	s := newTestServer("test/service_test.yaml")
+	s.Config = newTestConfig(sections)
+	return s
+}
+
+func serveTestRequest(s *Server, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp := httptest.NewRecorder()
+	router(s).ServeHTTP(resp, req)
+	return resp
+}
+
+func TestBuildRequestWithHTTPRule(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	var request *testRuleRequest
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		request = &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequest(s, request, req)
+		return request, err
+	}
+
+	serveTestRequest(s, "GET", "/v1/rules/12?name=query", `{"title":"body"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "query", request.Name)
+	assert.Equal(t, "", request.Child.Title)
+
+	resp := serveTestRequest(s, "POST", "/v1/rules/12?name=query", `{"title":"body"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "query", request.Name)
+	assert.Equal(t, "body", request.Child.Title)
+	assert.Equal(t, `{"id":0,"title":"body"}`, resp.Body.String())
+	// the body is unmarshalled into the field, it can not set other fields
+	resp = serveTestRequest(s, "POST", "/v1/rules/12?name=query", `{"title":"body","name":"body"}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "query", request.Name)
+	assert.Equal(t, "body", request.Child.Title)
+
+	serveTestRequest(s, "PUT", "/v1/rules/12?name=query", `{"name":"body","id":"1"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, "body", request.Name)
+}
+
+func TestBuildRequestWithFieldPath(t *testing.T) {
+	s := newTestServer("test/service_test.yaml")
+	s.Config.mappings[urlServiceMaps] = append(s.Config.mappings[urlServiceMaps], [3]string{"GET,PUT", "/v1/rules/{id}/children/{child.id}", "GetChild"})
+	s.Config.httpRules["GET /v1/rules/{id}/children/{child.id}"] = httpRule{}
+	s.Config.httpRules["PUT /v1/rules/{id}/children/{child.id}"] = httpRule{body: "child"}
+	var request *testRuleRequest
+	defer func(sw switcher) { switcherFunc = sw }(switcherFunc)
+	switcherFunc = func(s Servable, methodName string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+		request = &testRuleRequest{Child: &testRuleChild{}}
+		err := BuildRequest(s, request, req)
+		return request, err
+	}
+
+	serveTestRequest(s, "GET", "/v1/rules/12/children/3?id=5", "")
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, int64(3), request.Child.Id)
+	// the path param wins over the body field
+	serveTestRequest(s, "PUT", "/v1/rules/12/children/3", `{"title":"body","id":"4"}`)
+	assert.Equal(t, int64(12), request.Id)
+	assert.Equal(t, int64(3), request.Child.Id)
+	assert.Equal(t, "body", request.Child.Title)
+
+	// nil messages on the path are allocated
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/", nil), map[string]string{"id": "12", "child.id": "3"})
+	request = &testRuleRequest{}
+	setFieldPaths(reflect.ValueOf(request).Elem(), req)
+	assert.Equal(t, int64(0), request.Id)
+	assert.Equal(t, int64(3), request.Child.Id)
+}
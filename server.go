@@ -85,10 +85,19 @@ func watchConfigReload(s Servable) {
 					continue
 				}
 				log.Info("Reloading configuration...")
+				logRouteIssues(s.ServerField().Config)
+				logUnknownEncodings(s.ServerField().Config)
 				newComponents := s.ServerField().loadComponentsNoPanic()
+				if newComponents == nil {
+					// keep the old components rather than serving without any
+					newComponents = s.ServerField().Components
+				}
 				newRouter := router(s)
-				s.ServerField().httpServer.Handler = newRouter
+				s.ServerField().httpServer.Handler = compressionHandler(s, accessHandler(s, newRouter))
 				s.ServerField().Components = newComponents
+				if r, ok := s.(descriptorReloader); ok {
+					r.reloadOnConfigChange()
+				}
 				log.Info("Configuration reloaded")
 			}
 		}
@@ -98,26 +107,49 @@ func watchConfigReload(s Servable) {
 func (s *Server) watchConfig() {
 	s.Config.WatchConfig()
 	s.Config.OnConfigChange(func(e fsnotify.Event) {
-		c := &Config{
-			Viper:    *viper.New(),
-			File:     s.Config.File,
-			mappings: make(map[string][][3]string)}
-		c.loadServiceConfig()
+		c := s.loadConfigNoPanic()
+		if c == nil {
+			return
+		}
 		s.Config = c
 		s.reloadConfig <- true
 	})
 }
 
+// loadConfigNoPanic loads the config file into a new Config, which inherits states of the current one,
+// it returns nil if loading panics, e.g. "route_check" is "error" and there are route issues,
+// the current Config is not changed in that case, so the server keeps running with the old config.
+func (s *Server) loadConfigNoPanic() (c *Config) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("reload config failed, the old config is kept, err=", err)
+			c = nil
+		}
+	}()
+	c = &Config{
+		Viper:    *viper.New(),
+		File:     s.Config.File,
+		mappings: make(map[string][][3]string)}
+	c.loadServiceConfig()
+	c.inheritRateLimits(s.Config)
+	c.inheritNonces(s.Config)
+	c.inheritCache(s.Config)
+	c.inheritBreakers(s.Config)
+	return c
+}
+
 func (s *Server) initChans() {
 	s.reloadConfig = make(chan bool)
 	s.exit = make(chan os.Signal, 1)
 }
 
 func startHTTPServer(s Servable) *http.Server {
+	logRouteIssues(s.ServerField().Config)
+	logUnknownEncodings(s.ServerField().Config)
 	s.ServerField().Components = s.ServerField().loadComponents()
 	hs := &http.Server{
 		Addr:    ":" + strconv.FormatInt(s.ServerField().Config.HTTPPort(), 10),
-		Handler: router(s),
+		Handler: compressionHandler(s, accessHandler(s, router(s))),
 	}
 	go func() {
 		if err := hs.ListenAndServe(); err != nil {
@@ -138,7 +170,8 @@ func (s *Server) loadComponentsNoPanic() *Components {
 }
 
 func (s *Server) loadComponents() *Components {
-	c := &Components{routers: make(map[int]*mux.Router), registeredComponents: s.Components.registeredComponents}
+	c := &Components{routers: make(map[int]*mux.Router), registeredComponents: s.Components.registeredComponents,
+		encoders: s.Components.encoders, encoderFormats: s.Components.encoderFormats}
 	for _, m := range s.Config.mappings[interceptors] {
 		names := strings.Split(m[2], ",")
 		components := make([]Interceptor, 0)
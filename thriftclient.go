@@ -6,6 +6,9 @@
 package turbo
 
 import (
+	"sync"
+	"time"
+
 	"git.apache.org/thrift.git/lib/go/thrift"
 )
 
@@ -13,6 +16,9 @@ type thriftClient struct {
 	thriftService interface{}
 	transport     thrift.TTransport
 	factory       thrift.TProtocolFactory
+	// mutex serializes reopening the transport, see reopen
+	mutex    sync.Mutex
+	reopened time.Time
 }
 
 func (t *thriftClient) init(addr string, clientCreator func(trans thrift.TTransport, f thrift.TProtocolFactory) interface{}) {
@@ -43,3 +49,16 @@ func (t *thriftClient) close() error {
 	}
 	return t.transport.Close()
 }
+
+// reopen closes the transport and opens it again, e.g. after the socket is reset, calls failing on the same socket
+// reopen it only once, it's skipped if the transport has been reopened after 'since', when the failed call started.
+func (t *thriftClient) reopen(since time.Time) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.transport == nil || t.reopened.After(since) {
+		return nil
+	}
+	t.transport.Close()
+	t.reopened = time.Now()
+	return t.transport.Open()
+}
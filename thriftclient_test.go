@@ -2,6 +2,7 @@ package turbo
 
 import (
 	"testing"
+	"time"
 
 	"git.apache.org/thrift.git/lib/go/thrift"
 	logger "github.com/sirupsen/logrus"
@@ -25,6 +26,28 @@ func TestThriftClose(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+type countingTransport struct {
+	thrift.TTransport
+	opens int
+}
+
+func (t *countingTransport) Open() error  { t.opens++; return nil }
+func (t *countingTransport) Close() error { return nil }
+
+func TestThriftReopen(t *testing.T) {
+	c := new(thriftClient)
+	assert.Nil(t, c.reopen(time.Now()))
+	trans := &countingTransport{}
+	c.transport = trans
+	since := time.Now()
+	assert.Nil(t, c.reopen(since))
+	// calls failing on the same socket reopen it once
+	assert.Nil(t, c.reopen(since))
+	assert.Equal(t, 1, trans.opens)
+	assert.Nil(t, c.reopen(time.Now()))
+	assert.Equal(t, 2, trans.opens)
+}
+
 func TestThriftService(t *testing.T) {
 	defer func() {
 		if err := recover(); err != nil {
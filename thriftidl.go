@@ -0,0 +1,977 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// thriftFile is a parsed .thrift file, only declarations needed by the generator are kept,
+// consts, default values and annotations are skipped.
+type thriftFile struct {
+	path string
+	// name is the file name without ".thrift", it's the prefix of types referred from other files
+	name string
+	// goPackage is the package name of generated go codes, from "namespace go"
+	goPackage string
+	includes  map[string]*thriftFile
+	typedefs  map[string]*thriftType
+	enums     map[string][]string
+	structs   map[string]*thriftStruct
+	services  map[string]*thriftService
+}
+
+// thriftType is a type reference, Name is a base type, "list", "set", "map", or a (maybe prefixed) declared name
+type thriftType struct {
+	Name  string
+	Key   *thriftType
+	Value *thriftType
+}
+
+// thriftStruct is a struct, a union or an exception
+type thriftStruct struct {
+	Name   string
+	Fields []*thriftField
+}
+
+type thriftField struct {
+	ID       int
+	Name     string
+	Optional bool
+	Type     *thriftType
+}
+
+type thriftService struct {
+	Name    string
+	Extends string
+	Methods []*thriftMethod
+}
+
+type thriftMethod struct {
+	Name string
+	// Result is nil if the method returns void
+	Result *thriftType
+	Args   []*thriftField
+}
+
+var thriftBaseTypes = map[string]string{
+	"bool":   "bool",
+	"byte":   "int8",
+	"i8":     "int8",
+	"i16":    "int16",
+	"i32":    "int32",
+	"i64":    "int64",
+	"double": "float64",
+	"string": "string",
+	"binary": "[]byte",
+}
+
+// parseThriftFile parses a .thrift file and all files it includes,
+// included files are searched in the directory of the including file, then in 'includePaths'.
+func parseThriftFile(path string, includePaths []string) (*thriftFile, error) {
+	return parseThriftFileCached(path, includePaths, make(map[string]*thriftFile))
+}
+
+func parseThriftFileCached(path string, includePaths []string, parsed map[string]*thriftFile) (*thriftFile, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := parsed[absPath]; ok {
+		if f == nil {
+			return nil, errors.New("turbo: circular include of " + path)
+		}
+		return f, nil
+	}
+	parsed[absPath] = nil
+	data, err := ioutil.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	f, includes, err := parseThrift(absPath, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, include := range includes {
+		includeFile, err := findThriftInclude(include, filepath.Dir(absPath), includePaths)
+		if err != nil {
+			return nil, err
+		}
+		inc, err := parseThriftFileCached(includeFile, includePaths, parsed)
+		if err != nil {
+			return nil, err
+		}
+		f.includes[inc.name] = inc
+	}
+	parsed[absPath] = f
+	return f, nil
+}
+
+func findThriftInclude(include, dir string, includePaths []string) (string, error) {
+	if filepath.IsAbs(include) {
+		return include, nil
+	}
+	for _, d := range append([]string{dir}, includePaths...) {
+		p := filepath.Join(d, include)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", errors.New("turbo: include file not found: " + include)
+}
+
+// parseThrift parses the content of a .thrift file, returns the file and paths of included files
+func parseThrift(path string, data []byte) (*thriftFile, []string, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".thrift")
+	f := &thriftFile{
+		path:      path,
+		name:      name,
+		goPackage: thriftGoPackage(name),
+		includes:  make(map[string]*thriftFile),
+		typedefs:  make(map[string]*thriftType),
+		enums:     make(map[string][]string),
+		structs:   make(map[string]*thriftStruct),
+		services:  make(map[string]*thriftService),
+	}
+	tokens, err := thriftTokens(string(data))
+	if err != nil {
+		return nil, nil, errors.New("turbo: failed to parse " + path + ": " + err.Error())
+	}
+	p := &thriftParser{tokens: tokens}
+	includes, err := p.parseFile(f)
+	if err != nil {
+		return nil, nil, errors.New("turbo: failed to parse " + path + ": " + err.Error())
+	}
+	return f, includes, nil
+}
+
+// thriftGoPackage returns the last part of a namespace, like the thrift go generator names packages
+func thriftGoPackage(namespace string) string {
+	parts := strings.FieldsFunc(namespace, func(r rune) bool { return r == '.' || r == '/' })
+	if len(parts) == 0 {
+		return namespace
+	}
+	return strings.Replace(parts[len(parts)-1], "-", "_", -1)
+}
+
+// thriftInitialisms are words the thrift go generator writes in upper case, see commonInitialisms in t_go_generator.cc
+var thriftInitialisms = map[string]bool{
+	"API": true, "ASCII": true, "CPU": true, "CSS": true, "DNS": true, "EOF": true, "GUID": true, "HTML": true,
+	"HTTP": true, "HTTPS": true, "ID": true, "IP": true, "JSON": true, "LHS": true, "QPS": true, "RAM": true,
+	"RHS": true, "RPC": true, "SLA": true, "SMTP": true, "SSH": true, "TCP": true, "TLS": true, "TTL": true,
+	"UDP": true, "UI": true, "UID": true, "UUID": true, "URI": true, "URL": true, "UTF8": true, "VM": true,
+	"XML": true, "XSRF": true, "XSS": true,
+}
+
+// thriftGoName returns the go name the thrift go generator gives a field, method, struct or service,
+// e.g. "id" -> "ID", "avatar_url" -> "AvatarURL", "new_user" -> "NewUser_"
+func thriftGoName(name string) string {
+	return thriftPublicize(name, false)
+}
+
+// thriftArgsName returns the name of the "[Service][Method]Args" struct of a method, e.g. "UserServiceGetUserIDArgs"
+func thriftArgsName(serviceName, methodName string) string {
+	return thriftGoName(serviceName) + thriftPublicize(methodName+"_args", true)
+}
+
+// thriftPublicize does what t_go_generator::publicize does
+func thriftPublicize(name string, argsOrResult bool) string {
+	if len(name) == 0 {
+		return name
+	}
+	b := []byte(name)
+	if b[0] >= 'a' && b[0] <= 'z' {
+		b[0] -= 'a' - 'A'
+	}
+	// upper case common initialisms in the word starting at i
+	fixInitialism := func(i int) {
+		end := len(b)
+		if j := strings.IndexByte(string(b[i:]), '_'); j >= 0 {
+			end = i + j
+		}
+		if word := strings.ToUpper(string(b[i:end])); thriftInitialisms[word] {
+			copy(b[i:end], word)
+		}
+	}
+	fixInitialism(0)
+	// "_" followed by a lower case letter is removed and the letter is upper cased
+	for i := 1; i < len(b)-1; i++ {
+		if b[i] != '_' {
+			continue
+		}
+		if b[i+1] >= 'a' && b[i+1] <= 'z' {
+			b = append(b[:i], b[i+1:]...)
+			b[i] -= 'a' - 'A'
+		}
+		fixInitialism(i)
+	}
+	s := string(b)
+	// an underscore is appended to names which may collide with constructors or Args/Result structs
+	suffix := ""
+	if strings.HasPrefix(s, "New") {
+		suffix += "_"
+	}
+	if !argsOrResult && (strings.HasSuffix(s, "Args") || strings.HasSuffix(s, "Result")) {
+		suffix += "_"
+	}
+	return s + suffix
+}
+
+type thriftToken struct {
+	text string
+	line int
+	// quoted is true for string literals
+	quoted bool
+}
+
+func thriftTokens(s string) ([]thriftToken, error) {
+	tokens := make([]thriftToken, 0)
+	line := 1
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' || (r == '/' && i+1 < len(rs) && rs[i+1] == '/'):
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			end := strings.Index(string(rs[i+2:]), "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			comment := string(rs[i : i+2+end+2])
+			line += strings.Count(comment, "\n")
+			i += len([]rune(comment))
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			tokens = append(tokens, thriftToken{text: string(rs[i+1 : j]), line: line, quoted: true})
+			i = j + 1
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-' || r == '+':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || strings.ContainsRune("_.-+", rs[j])) {
+				j++
+			}
+			tokens = append(tokens, thriftToken{text: string(rs[i:j]), line: line})
+			i = j
+		case strings.ContainsRune("{}()<>[],;:=*", r):
+			tokens = append(tokens, thriftToken{text: string(r), line: line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+		}
+	}
+	return tokens, nil
+}
+
+type thriftParser struct {
+	tokens []thriftToken
+	pos    int
+}
+
+func (p *thriftParser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *thriftParser) next() (thriftToken, error) {
+	if p.pos >= len(p.tokens) {
+		return thriftToken{}, errors.New("unexpected end of file")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *thriftParser) expect(text string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.quoted || t.text != text {
+		return fmt.Errorf("line %d: expected %q, found %q", t.line, text, t.text)
+	}
+	return nil
+}
+
+func (p *thriftParser) identifier() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.quoted || len(t.text) == 0 || !(unicode.IsLetter([]rune(t.text)[0]) || t.text[0] == '_') {
+		return "", fmt.Errorf("line %d: expected an identifier, found %q", t.line, t.text)
+	}
+	return t.text, nil
+}
+
+// skipSeparator skips an optional ',' or ';'
+func (p *thriftParser) skipSeparator() {
+	if s := p.peek(); s == "," || s == ";" {
+		p.pos++
+	}
+}
+
+// skipAnnotations skips "( key = "value", ... )"
+func (p *thriftParser) skipAnnotations() error {
+	if p.peek() != "(" {
+		return nil
+	}
+	return p.skipBlock("(", ")")
+}
+
+// skipBlock skips tokens until the bracket opened by the next token is closed
+func (p *thriftParser) skipBlock(open, close string) error {
+	if err := p.expect(open); err != nil {
+		return err
+	}
+	for depth := 1; depth > 0; {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		if t.quoted {
+			continue
+		}
+		switch t.text {
+		case open:
+			depth++
+		case close:
+			depth--
+		}
+	}
+	return nil
+}
+
+// skipValue skips a const value, which is a literal, a list or a map
+func (p *thriftParser) skipValue() error {
+	switch p.peek() {
+	case "[":
+		return p.skipBlock("[", "]")
+	case "{":
+		return p.skipBlock("{", "}")
+	}
+	_, err := p.next()
+	return err
+}
+
+func (p *thriftParser) parseFile(f *thriftFile) ([]string, error) {
+	includes := make([]string, 0)
+	for p.pos < len(p.tokens) {
+		t, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch t.text {
+		case "include", "cpp_include":
+			path, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if !path.quoted {
+				return nil, fmt.Errorf("line %d: expected a file path, found %q", path.line, path.text)
+			}
+			if t.text == "include" {
+				includes = append(includes, path.text)
+			}
+		case "namespace":
+			scope, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			ns, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			if scope.text == "go" {
+				f.goPackage = thriftGoPackage(ns.text)
+			}
+			if err = p.skipAnnotations(); err != nil {
+				return nil, err
+			}
+		case "typedef":
+			t, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			name, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			f.typedefs[name] = t
+			if err = p.skipAnnotations(); err != nil {
+				return nil, err
+			}
+		case "const":
+			if _, err = p.parseType(); err != nil {
+				return nil, err
+			}
+			if _, err = p.identifier(); err != nil {
+				return nil, err
+			}
+			if err = p.expect("="); err != nil {
+				return nil, err
+			}
+			if err = p.skipValue(); err != nil {
+				return nil, err
+			}
+		case "enum", "senum":
+			name, values, err := p.parseEnum()
+			if err != nil {
+				return nil, err
+			}
+			f.enums[name] = values
+		case "struct", "union", "exception":
+			s, err := p.parseStruct()
+			if err != nil {
+				return nil, err
+			}
+			f.structs[s.Name] = s
+		case "service":
+			s, err := p.parseService()
+			if err != nil {
+				return nil, err
+			}
+			f.services[s.Name] = s
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", t.line, t.text)
+		}
+		p.skipSeparator()
+	}
+	return includes, nil
+}
+
+func (p *thriftParser) parseType() (*thriftType, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	t := &thriftType{Name: name}
+	switch name {
+	case "list", "set", "map":
+		if err = p.expect("<"); err != nil {
+			return nil, err
+		}
+		if name == "map" {
+			if t.Key, err = p.parseType(); err != nil {
+				return nil, err
+			}
+			if err = p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if t.Value, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if err = p.expect(">"); err != nil {
+			return nil, err
+		}
+	}
+	return t, p.skipAnnotations()
+}
+
+func (p *thriftParser) parseEnum() (string, []string, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return "", nil, err
+	}
+	if err = p.expect("{"); err != nil {
+		return "", nil, err
+	}
+	values := make([]string, 0)
+	for p.peek() != "}" {
+		t, err := p.next()
+		if err != nil {
+			return "", nil, err
+		}
+		values = append(values, t.text)
+		if p.peek() == "=" {
+			p.pos++
+			if _, err = p.next(); err != nil {
+				return "", nil, err
+			}
+		}
+		if err = p.skipAnnotations(); err != nil {
+			return "", nil, err
+		}
+		p.skipSeparator()
+	}
+	p.pos++
+	return name, values, p.skipAnnotations()
+}
+
+// parseFields parses fields until 'end', which is "}" for structs and ")" for arguments
+func (p *thriftParser) parseFields(end string) ([]*thriftField, error) {
+	fields := make([]*thriftField, 0)
+	for p.peek() != end {
+		f := &thriftField{ID: len(fields) + 1}
+		if id, err := strconv.Atoi(p.peek()); err == nil {
+			f.ID = id
+			p.pos++
+			if err = p.expect(":"); err != nil {
+				return nil, err
+			}
+		}
+		switch p.peek() {
+		case "optional":
+			f.Optional = true
+			p.pos++
+		case "required":
+			p.pos++
+		}
+		var err error
+		if f.Type, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if f.Name, err = p.identifier(); err != nil {
+			return nil, err
+		}
+		if p.peek() == "=" {
+			p.pos++
+			if err = p.skipValue(); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.skipAnnotations(); err != nil {
+			return nil, err
+		}
+		p.skipSeparator()
+		fields = append(fields, f)
+	}
+	p.pos++
+	return fields, nil
+}
+
+func (p *thriftParser) parseStruct() (*thriftStruct, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() == "xsd_all" {
+		p.pos++
+	}
+	if err = p.expect("{"); err != nil {
+		return nil, err
+	}
+	fields, err := p.parseFields("}")
+	if err != nil {
+		return nil, err
+	}
+	return &thriftStruct{Name: name, Fields: fields}, p.skipAnnotations()
+}
+
+func (p *thriftParser) parseService() (*thriftService, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	s := &thriftService{Name: name}
+	if p.peek() == "extends" {
+		p.pos++
+		if s.Extends, err = p.identifier(); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.expect("{"); err != nil {
+		return nil, err
+	}
+	for p.peek() != "}" {
+		m := &thriftMethod{}
+		if p.peek() == "oneway" {
+			p.pos++
+		}
+		if p.peek() == "void" {
+			p.pos++
+		} else if m.Result, err = p.parseType(); err != nil {
+			return nil, err
+		}
+		if m.Name, err = p.identifier(); err != nil {
+			return nil, err
+		}
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		if m.Args, err = p.parseFields(")"); err != nil {
+			return nil, err
+		}
+		if p.peek() == "throws" {
+			p.pos++
+			if err = p.skipBlock("(", ")"); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.skipAnnotations(); err != nil {
+			return nil, err
+		}
+		p.skipSeparator()
+		s.Methods = append(s.Methods, m)
+	}
+	p.pos++
+	return s, p.skipAnnotations()
+}
+
+// resolve finds the declaration of a named type,
+// it returns the file declaring it, the name without prefix, and the typedef, enum or struct it refers to
+func (f *thriftFile) resolve(name string) (*thriftFile, string, interface{}) {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		if inc, ok := f.includes[name[:i]]; ok {
+			return inc.resolve(name[i+1:])
+		}
+	}
+	if t, ok := f.typedefs[name]; ok {
+		return f, name, t
+	}
+	if e, ok := f.enums[name]; ok {
+		return f, name, e
+	}
+	if s, ok := f.structs[name]; ok {
+		return f, name, s
+	}
+	return f, name, nil
+}
+
+// underlying follows typedefs, returns the file declaring the final type and the type
+func (f *thriftFile) underlying(t *thriftType) (*thriftFile, *thriftType) {
+	for i := 0; i < 32; i++ {
+		if _, ok := thriftBaseTypes[t.Name]; ok || t.Key != nil || t.Value != nil {
+			return f, t
+		}
+		file, _, decl := f.resolve(t.Name)
+		typedef, ok := decl.(*thriftType)
+		if !ok {
+			return f, t
+		}
+		f, t = file, typedef
+	}
+	return f, t
+}
+
+// structOf returns the struct a type refers to, or nil
+func (f *thriftFile) structOf(t *thriftType) (*thriftFile, *thriftStruct) {
+	file, t := f.underlying(t)
+	if t.Key != nil || t.Value != nil {
+		return nil, nil
+	}
+	declFile, _, decl := file.resolve(t.Name)
+	s, ok := decl.(*thriftStruct)
+	if !ok {
+		return nil, nil
+	}
+	return declFile, s
+}
+
+// goType returns the go type of a thrift type, the same as reflect.Type.String() of the generated type
+func (f *thriftFile) goType(t *thriftType) string {
+	if goType, ok := thriftBaseTypes[t.Name]; ok {
+		return goType
+	}
+	switch t.Name {
+	case "list", "set":
+		return "[]" + f.goType(t.Value)
+	case "map":
+		return "map[" + f.goType(t.Key) + "]" + f.goType(t.Value)
+	}
+	declFile, name, _ := f.resolve(t.Name)
+	goType := declFile.goPackage + "." + thriftGoName(name)
+	if _, s := f.structOf(t); s != nil {
+		return "*" + goType
+	}
+	return goType
+}
+
+// service finds a service by name, in this file or in included files
+func (f *thriftFile) service(name string) (*thriftFile, *thriftService) {
+	declFile, name, _ := f.resolve(name)
+	if s, ok := declFile.services[name]; ok {
+		return declFile, s
+	}
+	return nil, nil
+}
+
+// method finds a method by its go name, e.g. "SayHello", methods of extended services are searched too
+func (f *thriftFile) method(serviceName, methodName string) (*thriftFile, *thriftMethod) {
+	for i := 0; i < 32; i++ {
+		file, s := f.service(serviceName)
+		if s == nil {
+			return nil, nil
+		}
+		for _, m := range s.Methods {
+			if m.Name == methodName || thriftGoName(m.Name) == methodName {
+				return file, m
+			}
+		}
+		if len(s.Extends) == 0 {
+			return nil, nil
+		}
+		f, serviceName = file, s.Extends
+	}
+	return nil, nil
+}
+
+// parameters returns the arguments of a method, which are fields of its "[Service][Method]Args" struct named 'args',
+// it's used in ThriftSwitcher
+func (f *thriftFile) parameters(serviceName, methodName string) (string, error) {
+	_, m := f.method(serviceName, methodName)
+	if m == nil {
+		return "", errors.New("turbo: no such method[" + methodName + "] in service " + serviceName)
+	}
+	var result string
+	for _, arg := range m.Args {
+		result += "\n\t\t\targs." + thriftGoName(arg.Name) + ","
+	}
+	return result, nil
+}
+
+// binderMessages returns "[Service][Method]Args" structs of all methods of a service, and structs they refer to,
+// the second result maps method go names to names of their Args structs.
+func (f *thriftFile) binderMessages(serviceName string) (map[string]*binderMessage, map[string]string, error) {
+	messages := make(map[string]*binderMessage)
+	methods := make(map[string]string)
+	file, s := f.service(serviceName)
+	if s == nil {
+		return nil, nil, errors.New("turbo: no such service: " + serviceName)
+	}
+	for s != nil {
+		for _, m := range s.Methods {
+			name := thriftGoName(m.Name)
+			if _, ok := methods[name]; ok {
+				continue
+			}
+			args := &thriftStruct{Name: thriftArgsName(s.Name, m.Name), Fields: m.Args}
+			file.addBinderMessage(messages, args, true)
+			methods[name] = args.Name
+		}
+		if len(s.Extends) == 0 {
+			break
+		}
+		file, s = file.service(s.Extends)
+	}
+	return messages, methods, nil
+}
+
+// addBinderMessage adds a struct to messages by its go name, names of Args structs are go names already
+func (f *thriftFile) addBinderMessage(messages map[string]*binderMessage, s *thriftStruct, args bool) {
+	name := s.Name
+	if !args {
+		name = thriftGoName(s.Name)
+	}
+	if _, ok := messages[name]; ok {
+		return
+	}
+	message := &binderMessage{Name: name, GoType: f.goPackage + "." + name, Args: args}
+	messages[name] = message
+	for _, field := range s.Fields {
+		bf := &binderField{GoName: thriftGoName(field.Name), Kind: "fallback", GoType: f.goType(field.Type)}
+		file, t := f.underlying(field.Type)
+		switch {
+		case t.Name == "list" || t.Name == "set":
+			if kind, bits := file.binderKind(t.Value); len(kind) > 0 {
+				bf.Kind, bf.Bits, bf.Repeated = kind, bits, true
+				bf.GoType = strings.TrimPrefix(bf.GoType, "[]")
+			}
+		case t.Key != nil:
+			// maps are set with reflection
+		default:
+			if structFile, fieldStruct := file.structOf(t); fieldStruct != nil {
+				bf.Kind, bf.Ref = "message", thriftGoName(fieldStruct.Name)
+				structFile.addBinderMessage(messages, fieldStruct, false)
+			} else if kind, bits := file.binderKind(t); len(kind) > 0 && !field.Optional {
+				// optional fields of base types are pointers
+				bf.Kind, bf.Bits = kind, bits
+			}
+		}
+		message.Fields = append(message.Fields, bf)
+	}
+}
+
+// binderKind returns the Kind of a binderField and the bit size of a thrift type, Kind is "" if it's not supported
+func (f *thriftFile) binderKind(t *thriftType) (string, int) {
+	file, t := f.underlying(t)
+	switch t.Name {
+	case "string":
+		return "string", 0
+	case "bool":
+		return "bool", 0
+	case "byte", "i8":
+		return "int", 8
+	case "i16":
+		return "int", 16
+	case "i32":
+		return "int", 32
+	case "i64":
+		return "int", 64
+	case "double":
+		return "float", 64
+	}
+	if _, _, decl := file.resolve(t.Name); decl != nil {
+		if _, ok := decl.([]string); ok {
+			// enums are int64 in generated go codes
+			return "int", 64
+		}
+	}
+	return "", 0
+}
+
+// fieldMappings returns items in "thriftfields.yaml",
+// which are struct arguments of all methods, with their struct fields, e.g. "CommonValues[HelloValues values,]"
+func (f *thriftFile) fieldMappings(serviceName string) ([]string, error) {
+	items := make([]string, 0)
+	seen := make(map[string]bool)
+	var add func(file *thriftFile, name string, s *thriftStruct)
+	add = func(file *thriftFile, name string, s *thriftStruct) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		item := name + "["
+		for _, field := range s.Fields {
+			fieldFile, fieldStruct := file.structOf(field.Type)
+			if fieldStruct == nil {
+				continue
+			}
+			typeName := thriftGoName(fieldStruct.Name)
+			item += fmt.Sprintf("%s %s,", typeName, thriftGoName(field.Name))
+			add(fieldFile, typeName, fieldStruct)
+		}
+		items = append(items, item+"]")
+	}
+	file, s := f.service(serviceName)
+	if s == nil {
+		return nil, errors.New("turbo: no such service: " + serviceName)
+	}
+	for s != nil {
+		for _, m := range s.Methods {
+			for _, arg := range m.Args {
+				if argFile, argStruct := file.structOf(arg.Type); argStruct != nil {
+					add(argFile, thriftGoName(argStruct.Name), argStruct)
+				}
+			}
+		}
+		if len(s.Extends) == 0 {
+			break
+		}
+		file, s = file.service(s.Extends)
+	}
+	return items, nil
+}
+
+// schema returns request and response types of all methods of a service,
+// the request of a method is its "[Service][Method]Args" struct.
+func (f *thriftFile) schema(serviceName string) (*apiSchema, error) {
+	schema := &apiSchema{
+		Methods:  make(map[string]*apiMethod),
+		Messages: make(map[string]*apiMessage),
+		Enums:    make(map[string][]string),
+	}
+	file, s := f.service(serviceName)
+	if s == nil {
+		return nil, errors.New("turbo: no such service: " + serviceName)
+	}
+	for s != nil {
+		for _, m := range s.Methods {
+			name := thriftGoName(m.Name)
+			if _, ok := schema.Methods[name]; ok {
+				continue
+			}
+			args := &thriftStruct{Name: thriftArgsName(s.Name, m.Name), Fields: m.Args}
+			schema.Messages[args.Name] = file.apiMessage(schema, args)
+			response := ""
+			if m.Result != nil {
+				if resultFile, result := file.structOf(m.Result); result != nil {
+					response = result.Name
+					resultFile.addAPIMessage(schema, result)
+				}
+			}
+			schema.Methods[name] = &apiMethod{Name: name, Request: args.Name, Response: response}
+		}
+		if len(s.Extends) == 0 {
+			break
+		}
+		file, s = file.service(s.Extends)
+	}
+	return schema, nil
+}
+
+func (f *thriftFile) addAPIMessage(schema *apiSchema, s *thriftStruct) {
+	if _, ok := schema.Messages[s.Name]; ok {
+		return
+	}
+	schema.Messages[s.Name] = &apiMessage{Name: s.Name}
+	schema.Messages[s.Name] = f.apiMessage(schema, s)
+}
+
+func (f *thriftFile) apiMessage(schema *apiSchema, s *thriftStruct) *apiMessage {
+	m := &apiMessage{Name: s.Name, Fields: make([]*apiField, 0, len(s.Fields))}
+	for _, field := range s.Fields {
+		apiField := f.apiField(schema, field.Name, field.Type)
+		apiField.GoName = thriftGoName(field.Name)
+		apiField.Optional = field.Optional
+		m.Fields = append(m.Fields, apiField)
+	}
+	return m
+}
+
+// apiField describes a field the same way as encoding/json writes the generated go type
+func (f *thriftFile) apiField(schema *apiSchema, name string, t *thriftType) *apiField {
+	field := &apiField{Name: name, JSONName: name}
+	file, t := f.underlying(t)
+	switch t.Name {
+	case "list", "set":
+		elem := file.apiField(schema, name, t.Value)
+		field.Type, field.Ref, field.Value = elem.Type, elem.Ref, elem.Value
+		field.Repeated = true
+		return field
+	case "map":
+		field.Type = "map"
+		field.Value = file.apiField(schema, name, t.Value)
+		return field
+	case "string":
+		field.Type = "string"
+	case "binary":
+		field.Type = "bytes"
+	case "bool":
+		field.Type = "bool"
+	case "byte", "i8", "i16", "i32":
+		field.Type = "int32"
+	case "double":
+		field.Type = "double"
+	case "i64":
+		field.Type = "int64"
+	default:
+		if structFile, s := file.structOf(t); s != nil {
+			field.Type = "message"
+			field.Ref = s.Name
+			structFile.addAPIMessage(schema, s)
+		} else {
+			// enums are int64 in generated go codes
+			field.Type = "int64"
+		}
+	}
+	return field
+}
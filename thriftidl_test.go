@@ -0,0 +1,213 @@
+package turbo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseThriftFile(t *testing.T) {
+	f, err := parseThriftFile("test/testservice/testservice.thrift", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "gen", f.goPackage)
+	assert.Equal(t, "gen", f.includes["shared"].goPackage)
+
+	parameters, err := f.parameters("TestService", "SayHello")
+	assert.Nil(t, err)
+	assert.Equal(t, "\n\t\t\targs.Values,\n\t\t\targs.YourName,\n\t\t\targs.Int64Value,\n\t\t\targs.BoolValue,"+
+		"\n\t\t\targs.Float64Value,\n\t\t\targs.Uint64Value,\n\t\t\targs.Int32Value,\n\t\t\targs.Int16Value,"+
+		"\n\t\t\targs.StringList,\n\t\t\targs.I32List,\n\t\t\targs.BoolList,\n\t\t\targs.DoubleList,", parameters)
+
+	items, err := f.fieldMappings("TestService")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"CommonValues[]", "TestJsonRequest[]"}, items)
+
+	_, err = f.parameters("TestService", "EatApple")
+	assert.Equal(t, "turbo: no such method[EatApple] in service TestService", err.Error())
+}
+
+func TestThriftSchema(t *testing.T) {
+	f, err := parseThriftFile("test/testservice/testservice.thrift", nil)
+	assert.Nil(t, err)
+	s, err := f.schema("TestService")
+	assert.Nil(t, err)
+	assert.Equal(t, &apiMethod{Name: "TestJson", Request: "TestServiceTestJsonArgs", Response: "TestJsonResponse"}, s.Methods["TestJson"])
+	args := s.Messages["TestServiceSayHelloArgs"]
+	assert.Equal(t, 12, len(args.Fields))
+	assert.Equal(t, &apiField{Name: "values", JSONName: "values", GoName: "Values", Type: "message", Ref: "CommonValues"}, args.Fields[0])
+	assert.Equal(t, &apiField{Name: "i32List", JSONName: "i32List", GoName: "I32List", Type: "int32", Repeated: true}, args.Fields[9])
+	assert.Equal(t, "transactionId", s.Messages["CommonValues"].Fields[0].Name)
+	assert.NotNil(t, s.Messages["SayHelloResponse"])
+}
+
+var testThriftIDL = `
+namespace go com.example.api  // the last part is the package name
+namespace java com.example.api
+
+# a comment
+/* a multi-line
+   comment */
+typedef i64 UserId
+typedef Address Location (annotation = "x")
+
+const list<string> NAMES = ["a", "b"]
+const map<string, i32> AGES = {"a": 1, "b": 2};
+
+enum Color {
+  RED = 1,
+  GREEN,
+  BLUE = 0x10
+}
+
+struct Address {
+  1: required string city = "Beijing";
+  2: optional string street
+}
+
+union Contact {
+  1: string email,
+  2: string phone,
+}
+
+exception NotFound {
+  1: string message
+}
+
+struct User {
+  1: UserId id,
+  2: optional Location address,
+  3: list<Contact> contacts,
+  4: map<string, list<Address>> history,
+  5: Color color,
+  6: binary avatar,
+  7: set<i16> tags,
+}
+
+service Base {
+  void ping()
+}
+
+service UserService extends Base {
+  User get_user(1: UserId id, 2: User template) throws (1: NotFound notFound),
+  oneway void notify(1: string message);
+}
+`
+
+func TestParseThrift(t *testing.T) {
+	f, includes, err := parseThrift("user.thrift", []byte(testThriftIDL))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(includes))
+	assert.Equal(t, "api", f.goPackage)
+	assert.Equal(t, []string{"RED", "GREEN", "BLUE"}, f.enums["Color"])
+	assert.True(t, f.structs["Address"].Fields[1].Optional)
+	assert.Equal(t, "UserService", f.services["UserService"].Name)
+
+	parameters, err := f.parameters("UserService", "GetUser")
+	assert.Nil(t, err)
+	assert.Equal(t, "\n\t\t\targs.ID,\n\t\t\targs.Template,", parameters)
+	user := f.structs["User"]
+	assert.Equal(t, "*api.Location", f.goType(user.Fields[1].Type))
+	assert.Equal(t, "[]*api.Contact", f.goType(user.Fields[2].Type))
+	assert.Equal(t, "map[string][]*api.Address", f.goType(user.Fields[3].Type))
+	assert.Equal(t, "api.Color", f.goType(user.Fields[4].Type))
+	assert.Equal(t, "[]byte", f.goType(user.Fields[5].Type))
+	assert.Equal(t, "[]int16", f.goType(user.Fields[6].Type))
+
+	_, err = f.parameters("UserService", "Ping")
+	assert.Nil(t, err)
+
+	items, err := f.fieldMappings("UserService")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Address[]", "User[Address Address,]"}, items)
+
+	s, err := f.schema("UserService")
+	assert.Nil(t, err)
+	assert.Equal(t, &apiMethod{Name: "GetUser", Request: "UserServiceGetUserArgs", Response: "User"}, s.Methods["GetUser"])
+	assert.Equal(t, &apiMethod{Name: "Ping", Request: "BasePingArgs", Response: ""}, s.Methods["Ping"])
+	fields := s.Messages["User"].Fields
+	assert.Equal(t, "int64", fields[0].Type)
+	assert.Equal(t, &apiField{Name: "address", JSONName: "address", GoName: "Address", Type: "message", Ref: "Address", Optional: true}, fields[1])
+	assert.Equal(t, "map", fields[3].Type)
+	assert.Equal(t, &apiField{Name: "history", JSONName: "history", Type: "message", Ref: "Address", Repeated: true}, fields[3].Value)
+	assert.Equal(t, "bytes", fields[5].Type)
+	assert.NotNil(t, s.Messages["Contact"])
+}
+
+var testThriftInitialismIDL = `
+namespace go api
+
+struct Profile {
+  1: i64 id,
+  2: string avatar_url,
+  3: string homeUrl,
+  4: string user_uuid,
+  5: string http_proxy,
+}
+
+struct UrlArgs {
+  1: string url,
+  2: Profile profile,
+}
+
+service ProfileService {
+  Profile get_profile_by_id(1: i64 user_id, 2: UrlArgs args),
+  void new_profile(1: Profile profile),
+}
+`
+
+func TestThriftGoName(t *testing.T) {
+	assert.Equal(t, "ID", thriftGoName("id"))
+	assert.Equal(t, "UserID", thriftGoName("user_id"))
+	assert.Equal(t, "UserId", thriftGoName("userId"))
+	assert.Equal(t, "AvatarURL", thriftGoName("avatar_url"))
+	assert.Equal(t, "HTTPProxy", thriftGoName("http_proxy"))
+	assert.Equal(t, "GetProfileByID", thriftGoName("get_profile_by_id"))
+	assert.Equal(t, "Value_1", thriftGoName("value_1"))
+	assert.Equal(t, "NewProfile_", thriftGoName("new_profile"))
+	assert.Equal(t, "UrlArgs_", thriftGoName("UrlArgs"))
+	assert.Equal(t, "", thriftGoName(""))
+	assert.Equal(t, "ProfileServiceGetProfileByIDArgs", thriftArgsName("ProfileService", "get_profile_by_id"))
+	assert.Equal(t, "ProfileServiceNewProfileArgs_", thriftArgsName("ProfileService", "new_profile"))
+}
+
+func TestParseThriftInitialisms(t *testing.T) {
+	f, _, err := parseThrift("profile.thrift", []byte(testThriftInitialismIDL))
+	assert.Nil(t, err)
+
+	parameters, err := f.parameters("ProfileService", "GetProfileByID")
+	assert.Nil(t, err)
+	assert.Equal(t, "\n\t\t\targs.UserID,\n\t\t\targs.Args_,", parameters)
+	_, err = f.parameters("ProfileService", "get_profile_by_id")
+	assert.Nil(t, err)
+	assert.Equal(t, "*api.UrlArgs_", f.goType(&thriftType{Name: "UrlArgs"}))
+
+	items, err := f.fieldMappings("ProfileService")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Profile[]", "UrlArgs_[Profile Profile,]"}, items)
+
+	messages, methods, err := f.binderMessages("ProfileService")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"GetProfileByID": "ProfileServiceGetProfileByIDArgs",
+		"NewProfile_": "ProfileServiceNewProfileArgs_"}, methods)
+	assert.Equal(t, "api.UrlArgs_", messages["UrlArgs_"].GoType)
+	names := make([]string, 0)
+	for _, field := range messages["Profile"].Fields {
+		names = append(names, field.GoName)
+	}
+	assert.Equal(t, []string{"ID", "AvatarURL", "HomeUrl", "UserUUID", "HTTPProxy"}, names)
+	assert.Equal(t, "Profile", messages["UrlArgs_"].Fields[1].Ref)
+
+	s, err := f.schema("ProfileService")
+	assert.Nil(t, err)
+	assert.Equal(t, &apiMethod{Name: "GetProfileByID", Request: "ProfileServiceGetProfileByIDArgs", Response: "Profile"},
+		s.Methods["GetProfileByID"])
+	assert.Equal(t, &apiField{Name: "avatar_url", JSONName: "avatar_url", GoName: "AvatarURL", Type: "string"},
+		s.Messages["Profile"].Fields[1])
+}
+
+func TestParseThriftError(t *testing.T) {
+	_, _, err := parseThrift("bad.thrift", []byte("struct A {\n  1: string\n}"))
+	assert.Equal(t, `turbo: failed to parse bad.thrift: line 3: expected an identifier, found "}"`, err.Error())
+	_, err = parseThriftFile("test/no_such.thrift", nil)
+	assert.NotNil(t, err)
+}
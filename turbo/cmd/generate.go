@@ -7,6 +7,9 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
 	"github.com/vaporz/turbo"
 )
@@ -28,6 +31,9 @@ var generateCmd = &cobra.Command{
 		if RpcType != "grpc" && RpcType != "thrift" {
 			return errors.New("invalid rpctype")
 		}
+		if httpClient && RpcType != "grpc" {
+			return errors.New("--httpclient is only supported for grpc")
+		}
 		if RpcType == "grpc" && len(FilePaths) == 0 {
 			return errors.New("missing .proto file path (-I)")
 		}
@@ -47,8 +53,19 @@ var generateCmd = &cobra.Command{
 			PkgPath:        args[0],
 			ConfigFileName: "service",
 			Options:        options,
+			OpenAPI:        openAPI,
+			HTTPClient:     httpClient,
+			TypeScript:     typeScript,
+			Check:          check,
 		}
 		g.Generate()
+		if check {
+			if stale := g.StaleFiles(); len(stale) > 0 {
+				return errors.New("generated files are stale, run 'turbo generate' to update them:\n  " +
+					strings.Join(stale, "\n  "))
+			}
+			fmt.Println("generated files are up to date")
+		}
 		return nil
 	},
 }
@@ -59,8 +76,21 @@ var FilePaths []string
 // RpcType should be either "grpc" or "thrift"
 var RpcType string
 
+var openAPI bool
+
+var httpClient bool
+
+var typeScript bool
+
+var check bool
+
 func init() {
 	RootCmd.AddCommand(generateCmd)
 	generateCmd.Flags().StringVarP(&RpcType, "rpctype", "r", "", "required, (grpc|thrift)")
 	generateCmd.Flags().StringArrayVarP(&FilePaths, "include-path", "I", []string{}, "required for grpc, .proto|.thrift file paths(absolute path)")
+	generateCmd.Flags().BoolVar(&openAPI, "openapi", false, "generate an OpenAPI 3 document 'gen/openapi.json'")
+	generateCmd.Flags().BoolVar(&httpClient, "httpclient", false, "generate a go HTTP client package 'gen/httpclient' (grpc only)")
+	generateCmd.Flags().BoolVar(&typeScript, "ts", false, "generate TypeScript definitions and a fetch client 'gen/ts/client.ts'")
+	generateCmd.Flags().BoolVar(&check, "check", false, "regenerate in memory and fail if generated files on disk are stale, "+
+		"grpc|thrift stubs are not checked, grpc field and descriptor files are regenerated in a temp dir")
 }
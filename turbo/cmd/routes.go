@@ -0,0 +1,42 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vaporz/turbo"
+)
+
+var routesCmd = &cobra.Command{
+	Use:     "routes package_path",
+	Example: "turbo routes package/path/to/yourservice -r grpc",
+	Short: "Print the route table declared in service.yaml, \n" +
+		"with the rpc method, backend and components resolved for each route",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.New("Usage: routes [package_path] -r [grpc|thrift]")
+		}
+		if routesRpcType != "grpc" && routesRpcType != "thrift" {
+			return errors.New("invalid rpctype")
+		}
+		c := turbo.NewConfig(routesRpcType, turbo.PackageDir(args[0])+"/"+routesConfigName+".yaml")
+		turbo.WriteRoutes(os.Stdout, c.Routes())
+		return nil
+	},
+}
+
+var routesRpcType string
+
+var routesConfigName string
+
+func init() {
+	RootCmd.AddCommand(routesCmd)
+	routesCmd.Flags().StringVarP(&routesRpcType, "rpctype", "r", "grpc", "(grpc|thrift)")
+	routesCmd.Flags().StringVarP(&routesConfigName, "config", "c", "service", "config file name, without '.yaml'")
+}
@@ -0,0 +1,360 @@
+/*
+ * Copyright © 2017 Xiao Zhang <zzxx513@gmail.com>.
+ * Use of this source code is governed by an MIT-style
+ * license that can be found in the LICENSE file.
+ */
+package turbo
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tsBuilder builds TypeScript interfaces and a fetch based client from "urlmapping" and the schema of rpc methods,
+// types follow the json actually written by doPostprocessor, see Marshaler.
+type tsBuilder struct {
+	c      *Config
+	schema *apiSchema
+	thrift bool
+	// filter, emitZeroValues and int64AsNumber are options of Marshaler
+	filter         bool
+	emitZeroValues bool
+	int64AsNumber  bool
+}
+
+func newTSBuilder(c *Config, schema *apiSchema) *tsBuilder {
+	return &tsBuilder{
+		c:              c,
+		schema:         schema,
+		thrift:         RpcType == "thrift",
+		filter:         c.FilterProtoJson(),
+		emitZeroValues: c.FilterProtoJsonEmitZeroValues(),
+		int64AsNumber:  c.FilterProtoJsonInt64AsNumber(),
+	}
+}
+
+func (b *tsBuilder) build() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString(tsHeader)
+	enumNames := make([]string, 0, len(b.schema.Enums))
+	for name := range b.schema.Enums {
+		enumNames = append(enumNames, name)
+	}
+	sort.Strings(enumNames)
+	for _, name := range enumNames {
+		values := make([]string, 0, len(b.schema.Enums[name]))
+		for _, v := range b.schema.Enums[name] {
+			values = append(values, strconv.Quote(v))
+		}
+		if len(values) == 0 {
+			values = append(values, "string")
+		}
+		fmt.Fprintf(buf, "export type %s = %s;\n\n", name, strings.Join(values, " | "))
+	}
+	messageNames := make([]string, 0, len(b.schema.Messages))
+	for name := range b.schema.Messages {
+		messageNames = append(messageNames, name)
+	}
+	sort.Strings(messageNames)
+	for _, name := range messageNames {
+		fmt.Fprintf(buf, "export interface %s {\n", name)
+		for _, f := range b.schema.Messages[name].Fields {
+			optional := ""
+			if b.optional(f) {
+				optional = "?"
+			}
+			fmt.Fprintf(buf, "  %s%s: %s;\n", tsPropertyName(f.Name), optional, b.fieldType(f))
+		}
+		buf.WriteString("}\n\n")
+	}
+	buf.WriteString(tsClient)
+	for _, r := range httpClientRoutes(b.c) {
+		b.writeMethod(buf, r)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+func (b *tsBuilder) writeMethod(buf *bytes.Buffer, r httpClientRoute) {
+	requestType, responseType := "any", "any"
+	var request *apiMessage
+	if method, ok := b.schema.Methods[r.MethodName]; ok {
+		request = b.schema.message(method.Request)
+		if request != nil {
+			requestType = "DeepPartial<" + request.Name + ">"
+		}
+		if response := b.schema.message(method.Response); response != nil {
+			responseType = response.Name
+			if len(r.ResponseBody) > 0 {
+				if f := fieldByName(response, r.ResponseBody); f != nil {
+					responseType = b.fieldType(f)
+				}
+			}
+		}
+	}
+	used := make(map[string]bool)
+	path := matchPathVar.ReplaceAllStringFunc(r.Pattern, func(v string) string {
+		name := matchPathVar.FindStringSubmatch(v)[1]
+		fieldPath := b.pathParamField(request, name)
+		used[strings.ToLower(name)] = true
+		return "${this.path(request, " + tsStringList(fieldPath) + ")}"
+	})
+	query := "[]"
+	body := "undefined"
+	switch r.Body {
+	case "":
+		query = b.queryParams(request, "", used)
+	case "*":
+		body = "request"
+		if b.thrift && request != nil && len(request.Fields) > 0 {
+			// the json body is unmarshaled into the first argument
+			body = "request." + tsAccessor(request.Fields[0].Name)
+		}
+	default:
+		query = b.queryParams(request, r.Body, used)
+		if request != nil {
+			if f := fieldByName(request, r.Body); f != nil {
+				body = "request." + tsAccessor(f.Name)
+			}
+		}
+	}
+	fmt.Fprintf(buf, "\n  /** %s %s */\n", r.HTTPMethod, r.Pattern)
+	fmt.Fprintf(buf, "  %s(request: %s = {}): Promise<%s> {\n", lowerCamelCase(r.FuncName), requestType, responseType)
+	fmt.Fprintf(buf, "    return this.call(%s, `%s`, request, %s, %s);\n", strconv.Quote(r.HTTPMethod), path, query, body)
+	buf.WriteString("  }\n")
+}
+
+// pathParamField returns the property path of the field bound to a route variable, the same way setPathParams finds it,
+// or the same way setFieldPaths does if it's a field path like "user.id"
+func (b *tsBuilder) pathParamField(request *apiMessage, name string) []string {
+	if strings.Contains(name, ".") {
+		path := make([]string, 0)
+		m := request
+		for _, fieldName := range strings.Split(name, ".") {
+			var f *apiField
+			if m != nil {
+				f = fieldByName(m, fieldName)
+			}
+			if f == nil {
+				return []string{name}
+			}
+			path = append(path, f.Name)
+			m = b.schema.message(f.Ref)
+		}
+		return path
+	}
+	name = strings.ToLower(name)
+	var found []string
+	b.eachScalarField(request, "", nil, func(path []string, f *apiField) {
+		if found == nil && (strings.ToLower(f.GoName) == name || ToSnakeCase(f.GoName) == name) {
+			found = path
+		}
+	})
+	if found == nil {
+		return []string{name}
+	}
+	return found
+}
+
+// queryParams returns a list of [query param name, property path] pairs, query params are named like BuildStruct finds them
+func (b *tsBuilder) queryParams(request *apiMessage, skip string, used map[string]bool) string {
+	params := make([]string, 0)
+	seen := make(map[string]bool)
+	b.eachScalarField(request, skip, nil, func(path []string, f *apiField) {
+		name := ToSnakeCase(f.GoName)
+		if used[strings.ToLower(f.GoName)] || used[name] || seen[name] {
+			return
+		}
+		seen[name] = true
+		params = append(params, "["+strconv.Quote(name)+", "+tsStringList(path)+"]")
+	})
+	return "[" + strings.Join(params, ", ") + "]"
+}
+
+// eachScalarField walks non-message fields, nested messages are flattened, repeated messages and maps are skipped
+func (b *tsBuilder) eachScalarField(m *apiMessage, skip string, parent []string, fn func([]string, *apiField)) {
+	if m == nil || len(parent) > 8 {
+		return
+	}
+	for _, f := range m.Fields {
+		if len(parent) == 0 && len(skip) > 0 && (f.Name == skip || f.JSONName == skip || f.GoName == skip) {
+			continue
+		}
+		path := append(append([]string{}, parent...), f.Name)
+		switch {
+		case f.Type == "map":
+		case f.Type == "message":
+			if !f.Repeated {
+				b.eachScalarField(b.schema.message(f.Ref), "", path, fn)
+			}
+		default:
+			fn(path, f)
+		}
+	}
+}
+
+// optional returns true if the property may be missing in json
+func (b *tsBuilder) optional(f *apiField) bool {
+	if b.thrift {
+		return f.Optional
+	}
+	if f.Type == "map" || !b.filter {
+		return true
+	}
+	// Marshaler.JSON sets all fields if EmitZeroValues, and always sets nested messages
+	return !b.emitZeroValues && !(f.Type == "message" && !f.Repeated)
+}
+
+func (b *tsBuilder) fieldType(f *apiField) string {
+	var t string
+	switch f.Type {
+	case "message":
+		t = f.Ref
+		if !f.Repeated && (b.thrift || (b.filter && b.emitZeroValues)) {
+			t += " | null"
+		}
+	case "map":
+		t = "{ [key: string]: " + b.fieldType(f.Value) + " }"
+	default:
+		t = b.scalarType(f)
+	}
+	if f.Repeated {
+		if strings.Contains(t, " ") {
+			t = "(" + t + ")"
+		}
+		return t + "[]"
+	}
+	return t
+}
+
+func (b *tsBuilder) scalarType(f *apiField) string {
+	switch f.Type {
+	case "string", "bytes":
+		return "string"
+	case "bool":
+		return "boolean"
+	case "int32", "uint32", "float", "double":
+		return "number"
+	case "int64":
+		if b.thrift || (b.filter && b.int64AsNumber) {
+			return "number"
+		}
+		return "string"
+	case "uint64":
+		// Marshaler.JSON changes uint64 fields into numbers, but not uint64 lists
+		if b.thrift || (b.filter && !f.Repeated) {
+			return "number"
+		}
+		return "string"
+	case "enum":
+		// Marshaler.JSON changes enum fields into numbers, but not enum lists
+		if b.thrift || (b.filter && !f.Repeated) || len(f.Ref) == 0 {
+			return "number"
+		}
+		return f.Ref
+	}
+	return "any"
+}
+
+var matchTSIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsPropertyName(name string) string {
+	if matchTSIdentifier.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+func tsAccessor(name string) string {
+	if matchTSIdentifier.MatchString(name) {
+		return name
+	}
+	return "[" + strconv.Quote(name) + "]"
+}
+
+func tsStringList(list []string) string {
+	quoted := make([]string, 0, len(list))
+	for _, s := range list {
+		quoted = append(quoted, strconv.Quote(s))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+var tsHeader = `// Code generated by turbo. DO NOT EDIT.
+
+export type DeepPartial<T> = {
+  [P in keyof T]?: T[P] extends (infer U)[] ? DeepPartial<U>[] : T[P] extends object | null ? DeepPartial<T[P]> : T[P];
+};
+
+`
+
+var tsClient = `export class HTTPError extends Error {
+  constructor(public status: number, public code: string, message: string, public body: string) {
+    super(message);
+  }
+}
+
+export class Client {
+  constructor(private baseURL: string, private init: RequestInit = {}) {
+    this.baseURL = baseURL.replace(/\/+$/, "");
+  }
+
+  private value(request: any, path: string[]): any {
+    let v = request;
+    for (const key of path) {
+      if (v === undefined || v === null) {
+        return undefined;
+      }
+      v = v[key];
+    }
+    return v;
+  }
+
+  private format(v: any): string {
+    return Array.isArray(v) ? v.map(String).join(",") : String(v);
+  }
+
+  private path(request: any, path: string[]): string {
+    const v = this.value(request, path);
+    return v === undefined || v === null ? "" : this.format(v).split("/").map(encodeURIComponent).join("/");
+  }
+
+  private async call(method: string, path: string, request: any, query: [string, string[]][], body: any): Promise<any> {
+    const params: string[] = [];
+    for (const [name, field] of query) {
+      const v = this.value(request, field);
+      if (v !== undefined && v !== null && !(Array.isArray(v) && v.length === 0)) {
+        params.push(encodeURIComponent(name) + "=" + encodeURIComponent(this.format(v)));
+      }
+    }
+    const url = this.baseURL + path + (params.length > 0 ? "?" + params.join("&") : "");
+    const headers = new Headers(this.init.headers);
+    headers.set("Accept", "application/json");
+    const init: RequestInit = { ...this.init, method, headers };
+    if (body !== undefined) {
+      headers.set("Content-Type", "application/json");
+      init.body = JSON.stringify(body);
+    }
+    const resp = await fetch(url, init);
+    const text = await resp.text();
+    if (!resp.ok) {
+      let code = "";
+      let message = text.trim();
+      try {
+        const envelope = JSON.parse(text);
+        if (envelope && typeof envelope === "object") {
+          code = envelope.code === undefined ? "" : String(envelope.code);
+          message = String(envelope.message !== undefined ? envelope.message : envelope.error !== undefined ? envelope.error : "");
+        }
+      } catch (e) {
+        // not a json envelope, the body is the message
+      }
+      throw new HTTPError(resp.status, code, message, text);
+    }
+    return text.trim().length === 0 ? {} : JSON.parse(text);
+  }
+`
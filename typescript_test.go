@@ -0,0 +1,27 @@
+package turbo
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTypeScript(t *testing.T) {
+	c := NewConfig("grpc", "test/service_test.yaml")
+	code := newTSBuilder(c, grpcSchema(testDescriptors(), "YourService")).build()
+	// filter_proto_json, filter_proto_json_emit_zerovalues and filter_proto_json_int64_as_number are true in service_test.yaml
+	assert.Contains(t, code, "export interface CommonValues {\n  some_id: number;\n}\n")
+	assert.Contains(t, code, "export interface SayHelloRequest {\n  values: CommonValues | null;\n  yourName: string;\n  int64_list: number[];\n}\n")
+	assert.Contains(t, code, "  sayHello(request: DeepPartial<SayHelloRequest> = {}): Promise<SayHelloResponse> {\n"+
+		"    return this.call(\"GET\", `/hello`, request, [[\"some_id\", [\"values\", \"some_id\"]], [\"your_name\", [\"yourName\"]], [\"int64_list\", [\"int64_list\"]]], undefined);\n")
+	assert.Contains(t, code, "  sayHelloPost(request: DeepPartial<SayHelloRequest> = {}): Promise<SayHelloResponse> {\n"+
+		"    return this.call(\"POST\", `/hello`, request, [], request);\n")
+	assert.Contains(t, code, "`/eat_apple/${this.path(request, [\"num\"])}`")
+	assert.True(t, strings.HasSuffix(code, "  }\n}\n"))
+
+	c.configs[filterProtoJson] = "false"
+	code = newTSBuilder(c, grpcSchema(testDescriptors(), "YourService")).build()
+	assert.Contains(t, code, "export interface CommonValues {\n  some_id?: string;\n}\n")
+	assert.Contains(t, code, "  values?: CommonValues;\n")
+}
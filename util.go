@@ -121,25 +121,81 @@ type Marshaler struct {
 	FilterProtoJson bool
 	EmitZeroValues  bool
 	Int64AsNumber   bool
+	// FieldNaming is the style of keys, "original"(names in .proto or .thrift), "lower_camel" or "go_name",
+	// if it's not blank, proto messages and thrift structs are both written by encodeProto in the same style.
+	FieldNaming string
+	// EnumStyle is "names" or "numbers", by default, single (not repeated) enum fields are numbers,
+	// and enum lists are names.
+	EnumStyle string
+}
+
+// Marshaler returns the Marshaler which writes responses, it's configured by "filter_proto_json*" options,
+// which apply to proto messages only, and by "json_*" options, which apply to both grpc and thrift responses:
+// json_field_naming: original(default)|lower_camel|go_name
+// json_enum_style: names|numbers
+// json_int64_style: string|number, uint64 values follow it as well
+// json_emit_zerovalues: true|false, write empty fields or omit them
+func (c *Config) Marshaler() Marshaler {
+	m := Marshaler{
+		FilterProtoJson: c.FilterProtoJson(),
+		EmitZeroValues:  c.FilterProtoJsonEmitZeroValues(),
+		Int64AsNumber:   c.FilterProtoJsonInt64AsNumber(),
+	}
+	styled := false
+	if v, ok := c.configs[jsonFieldNaming]; ok {
+		styled = true
+		switch strings.Replace(strings.ToLower(strings.TrimSpace(v)), "_", "", -1) {
+		case "lowercamel":
+			m.FieldNaming = fieldNamingLowerCamel
+		case "goname":
+			m.FieldNaming = fieldNamingGoName
+		}
+	}
+	if v, ok := c.configs[jsonEnumStyle]; ok {
+		styled = true
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case enumStyleNames, enumStyleNumbers:
+			m.EnumStyle = strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	if v, ok := c.configs[jsonInt64Style]; ok {
+		styled = true
+		m.Int64AsNumber = strings.ToLower(strings.TrimSpace(v)) == "number"
+	}
+	if v, ok := c.configs[jsonEmitZeroValues]; ok {
+		styled = true
+		m.EmitZeroValues = strings.TrimSpace(v) == "true"
+	}
+	if styled && len(m.FieldNaming) == 0 {
+		m.FieldNaming = fieldNamingOriginal
+	}
+	return m
 }
 
 // JSON returns the json encoding of v,
-// if v implements 'proto.Message', then FilterJsonWithStruct() is called, see comments for FilterJsonWithStruct(),
+// if v implements 'proto.Message', it's written by jsonpb, or by encodeProto() if FilterProtoJson or FieldNaming is set,
+// see comments for encodeProto(), other structs (e.g. thrift responses) are written by encodeProto() if FieldNaming
+// is set, unless they implement json.Marshaler,
 // otherwise, call encoding/json.Marshal()
 func (m *Marshaler) JSON(v interface{}) ([]byte, error) {
 	if _, ok := v.(proto.Message); ok {
+		if m.FilterProtoJson || m.styled() {
+			return m.encodeProto(make([]byte, 0, 256), reflect.ValueOf(v))
+		}
 		var buf bytes.Buffer
 		jm := &jsonpb.Marshaler{}
 		jm.OrigName = true
 		if err := jm.Marshal(&buf, v.(proto.Message)); err != nil {
 			return []byte{}, err
 		}
-
-		if m.FilterProtoJson {
-			return m.FilterJsonWithStruct(buf.Bytes(), v)
-		}
 		return buf.Bytes(), nil
 	}
+	if _, ok := v.(json.Marshaler); !ok && m.styled() {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Struct || (rv.Kind() == reflect.Ptr && rv.Type().Elem().Kind() == reflect.Struct) {
+			return m.encodeProto(make([]byte, 0, 256), rv)
+		}
+	}
 	return json.Marshal(v)
 }
 
@@ -152,6 +208,8 @@ func (m *Marshaler) JSON(v interface{}) ([]byte, error) {
 // (a) protobuf parse int64 as string,
 // (b) a Key with a nil Ptr value is missing in the json marshaled by github.com/golang/protobuf/jsonpb.Marshaler
 // So, this func is a 'patch' to protobuf
+//
+// Deprecated: Marshaler.JSON writes proto messages in one pass without it.
 func (m *Marshaler) FilterJsonWithStruct(jsonBytes []byte, structObj interface{}) (bytes []byte, e error) {
 	defer func() {
 		if err := recover(); err != nil {